@@ -0,0 +1,189 @@
+/*
+File summary: go test of recording sessions and generating Go from them
+Package: autoexpect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package autoexpect
+
+import (
+	"bytes"
+	"context"
+	"go/parser"
+	"go/token"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// terminal is the user's side of a recording. Each answer is typed a key at
+// a time once its prompt has been output.
+type terminal struct {
+	mu      sync.Mutex
+	out     bytes.Buffer
+	answers []struct{ prompt, keys string }
+	keys    string
+}
+
+func (term *terminal) Write(p []byte) (int, error) {
+	term.mu.Lock()
+	defer term.mu.Unlock()
+	return term.out.Write(p)
+}
+
+func (term *terminal) Read(p []byte) (int, error) {
+	for term.keys == "" {
+		if len(term.answers) == 0 {
+			// Leave the program to exit
+			time.Sleep(time.Hour)
+			return 0, io.EOF
+		}
+		term.mu.Lock()
+		seen := strings.Contains(term.out.String(), term.answers[0].prompt)
+		term.mu.Unlock()
+		if seen {
+			time.Sleep(20 * time.Millisecond)
+			term.keys = term.answers[0].keys
+			term.answers = term.answers[1:]
+			term.out.Reset()
+		} else {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	p[0] = term.keys[0]
+	term.keys = term.keys[1:]
+	return 1, nil
+}
+
+func Test_Record(t *testing.T) {
+	term := &terminal{answers: []struct{ prompt, keys string }{
+		{"[/opt]: ", "/tmp/x\r"},
+		{"(y/n) ", "y\r"},
+	}}
+	script := `printf 'Install to [/opt]: '; read dir
+printf 'Downloading 42%%\r100%%\r\nContinue? (y/n) '; read yn
+echo "installing to $dir"; exit 3`
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	rec, err := Record(ctx, term, term, "sh", "-c", script)
+	if err != nil {
+		t.Fatalf("Record failed %s", err)
+	}
+	if !rec.Exited || rec.ExitCode != 3 {
+		t.Errorf("exit is %v %d", rec.Exited, rec.ExitCode)
+	}
+
+	steps, final := rec.Steps()
+	for _, st := range steps {
+		t.Logf("step %q then %q after %q", st.Prompt, st.Send, st.Output)
+	}
+	if len(steps) != 2 {
+		t.Fatalf("%d steps", len(steps))
+	}
+	if steps[0].Prompt != "Install to [/opt]: " || string(steps[0].Send) != "/tmp/x\r" ||
+		steps[1].Prompt != "Continue? (y/n) " || string(steps[1].Send) != "y\r" {
+		t.Errorf("steps are %+v", steps)
+	}
+	if !strings.Contains(string(final), "installing to /tmp/x") {
+		t.Errorf("final output is %q", final)
+	}
+
+	src, err := rec.GoSource(CodeOptions{Package: "wizard", Func: "Install", Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("GoSource failed %s", err)
+	}
+	t.Logf("GoSource:\n%s", src)
+	if _, err := parser.ParseFile(token.NewFileSet(), "install.go", src, 0); err != nil {
+		t.Errorf("GoSource does not parse %s", err)
+	}
+	for _, want := range []string{
+		"package wizard",
+		"func Install() error {",
+		`expect.NewExpect("sh", "-c", `,
+		"exp.SetTimeout(5 * time.Second)",
+		`wait("Install to [/opt]: ")`,
+		`exp.Send("/tmp/x\r")`,
+		`wait("Continue? (y/n) ")`,
+		"exp.Expect(expect.EndOfFile)",
+		"code != 3",
+	} {
+		if !bytes.Contains(src, []byte(want)) {
+			t.Errorf("GoSource does not contain %s", want)
+		}
+	}
+}
+
+func Test_Steps(t *testing.T) {
+	at := time.Duration(0)
+	chunk := func(input bool, data string) Chunk {
+		at += 100 * time.Millisecond
+		return Chunk{Input: input, Data: []byte(data), At: at}
+	}
+	rec := &Recording{Command: "prog", Chunks: []Chunk{
+		chunk(false, "Name: "),
+		chunk(true, "b"), chunk(false, "b"),
+		chunk(true, "o"), chunk(false, "o"),
+		chunk(true, "b"), chunk(false, "b"),
+		chunk(true, "\r"), chunk(false, "\r\nPassword: "),
+		chunk(true, "s"), chunk(true, "3"), chunk(true, "\r"), // no echo
+		chunk(false, "\r\nMenu\r\n1) a\r\n2) b\r\n> "),
+		chunk(true, "\x1b[B"), chunk(false, "\x1b[2K\r> b"), // redraws
+		chunk(true, "\x03"), chunk(false, "^C\r\n"),
+		chunk(true, "q"),
+	}}
+	steps, final := rec.Steps()
+	var got []string
+	for _, st := range steps {
+		got = append(got, st.Prompt+"|"+string(st.Send))
+	}
+	want := []string{"Name: |bob\r", "Password: |s3\r", "\n> |\x1b[B", "> b|\x03", "^C|q"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("steps are %q not %q", got, want)
+	}
+	if len(final) != 0 {
+		t.Errorf("final output is %q", final)
+	}
+	if steps[0].Pause != 100*time.Millisecond {
+		t.Errorf("pause is %s", steps[0].Pause)
+	}
+
+	src, err := rec.GoSource(CodeOptions{Pauses: true})
+	if err != nil {
+		t.Fatalf("GoSource failed %s", err)
+	}
+	if !bytes.Contains(src, []byte("time.Sleep(100 * time.Millisecond)")) ||
+		!bytes.Contains(src, []byte("func Run() error")) || bytes.Contains(src, []byte("EndOfFile")) {
+		t.Errorf("GoSource is\n%s", src)
+	}
+}
+
+func Test_PickPrompt(t *testing.T) {
+	for _, tc := range []struct {
+		output string
+		prompt string
+		re     bool
+	}{
+		{"Name: ", "Name: ", false},
+		{"step 3 of 10\r\nPassword: ", "Password: ", false},
+		{"Progress 50%\rProgress 100%\r\nDone. Continue? ", "Done. Continue? ", false},
+		{"Press Enter to continue\r\n", "Press Enter to continue", false},
+		{"\x1b[1mChoose\x1b[0m a colour: ", " a colour: ", false},
+		{"Choose [1-3]: ", `Choose \[\d+-\d+\]: `, true},
+		{"Build 1234 ok? ", "ok? ", false},
+		{"output\r\n$ ", "\n$ ", false},
+		{"This is a very long question that goes on and on before it asks: ", "that goes on and on before it asks: ", false},
+		{"", "", false},
+		{"\r\n\r\n", "", false},
+	} {
+		prompt, re := pickPrompt([]byte(tc.output))
+		if prompt != tc.prompt || re != tc.re {
+			t.Errorf("pickPrompt(%q) is %q %v not %q %v", tc.output, prompt, re, tc.prompt, tc.re)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+/*
+File summary: Turn a Recording into steps and Go source
+Package: autoexpect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package autoexpect
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Step is something the user typed and what to wait for before typing it
+type Step struct {
+	// Output is everything printed between the previous step's input and
+	// this step's
+	Output []byte
+
+	// Prompt is the fragment of Output to wait for, "" if there was no
+	// output. If PromptRegexp is true it is a regexp, otherwise a literal.
+	Prompt       string
+	PromptRegexp bool
+
+	// Send is what the user typed
+	Send []byte
+
+	// Pause is how long the user waited after the last output before typing
+	Pause time.Duration
+}
+
+// MaxPrompt is the most characters of a line that are used as a prompt
+var MaxPrompt = 40
+
+// MinPrompt is the fewest non-space characters a prompt may have before the
+// start of its line is included
+var MinPrompt = 3
+
+// Steps divides the recording into steps. Keys typed one after another are
+// one step while the program only echoes them and until Enter or a control
+// key such as ^C is typed. Anything else printed starts a new step with a
+// prompt picked from it. The output after the last step is also returned.
+func (rec *Recording) Steps() ([]Step, []byte) {
+	var steps []Step
+	var since []byte // output since the last input
+	var cur *Step
+	var last []byte // the last input
+	var lastOutput time.Duration
+	for _, c := range rec.Chunks {
+		if !c.Input {
+			since = append(since, c.Data...)
+			lastOutput = c.At
+			continue
+		}
+		if cur != nil && !endsStep(last) && isEcho(since, last) {
+			cur.Send = append(cur.Send, c.Data...)
+		} else {
+			if cur != nil {
+				steps = append(steps, *cur)
+			}
+			cur = &Step{Output: since, Send: append([]byte{}, c.Data...), Pause: c.At - lastOutput}
+			cur.Prompt, cur.PromptRegexp = pickPrompt(since)
+		}
+		since = nil
+		last = c.Data
+	}
+	if cur != nil {
+		steps = append(steps, *cur)
+	}
+	return steps, since
+}
+
+// endsStep reports whether typing input finishes a step
+func endsStep(input []byte) bool {
+	if len(input) == 0 {
+		return false
+	}
+	switch input[len(input)-1] {
+	case '\r', '\n', 0x03, 0x04, 0x1a:
+		return true
+	}
+	return false
+}
+
+var escapeSeq = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[@-Z\\-_]`)
+
+// isEcho reports whether out is no more than the echo of typed
+func isEcho(out, typed []byte) bool {
+	out = escapeSeq.ReplaceAll(out, nil)
+	for _, b := range out {
+		switch {
+		case bytes.IndexByte(typed, b) >= 0:
+		case b == '\r' || b == '\n' || b == '\b' || b == ' ' || b == '^':
+		case b >= '@' && b <= '_' && bytes.IndexByte(typed, b-'@') >= 0:
+			// A control character echoed as ^X
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+var digits = regexp.MustCompile(`[0-9]+`)
+
+// pickPrompt picks the stable end of output to wait for. This is the end of
+// the last line that has something on it, after any escape sequences. Digits
+// are assumed to change from run to run, such as progress counts, times and
+// ids, so the part after the last digit is used if that is long enough.
+// Otherwise digits are matched with a regexp. A prompt that is still short,
+// such as "> ", includes the newline before it so it only matches at the
+// start of a line.
+func pickPrompt(output []byte) (string, bool) {
+	raw := strings.TrimRight(string(output), "\r\n")
+	line := raw
+	start := 0
+	if i := strings.LastIndexAny(line, "\r\n"); i >= 0 {
+		start = i + 1
+	}
+	if loc := escapeSeq.FindAllStringIndex(line[start:], -1); loc != nil {
+		start += loc[len(loc)-1][1]
+	}
+	line = line[start:]
+	for len(line) > 0 && line[0] < ' ' {
+		line = line[1:]
+	}
+	if strings.TrimSpace(line) == "" {
+		return "", false
+	}
+
+	short := func(s string) bool {
+		return utf8.RuneCountInString(strings.TrimSpace(s)) < MinPrompt
+	}
+	atLineStart := strings.HasSuffix(raw, "\n"+line)
+
+	fixed := line
+	if loc := digits.FindAllStringIndex(line, -1); loc != nil {
+		fixed = strings.TrimLeft(line[loc[len(loc)-1][1]:], " ")
+	}
+	fixed = limit(fixed)
+	if !short(fixed) {
+		return fixed, false
+	}
+
+	if fixed != line && !short(line) {
+		re := regexp.QuoteMeta(limit(line))
+		return digits.ReplaceAllString(re, `\d+`), true
+	}
+	if atLineStart && line == limit(line) {
+		return "\n" + line, false
+	}
+	return limit(line), false
+}
+
+// limit cuts s to its last MaxPrompt characters, starting at a word if it can
+func limit(s string) string {
+	if utf8.RuneCountInString(s) <= MaxPrompt {
+		return s
+	}
+	runes := []rune(s)
+	s = string(runes[len(runes)-MaxPrompt:])
+	if i := strings.IndexByte(s, ' '); i >= 0 && i < len(s)-1 {
+		if rest := s[i+1:]; utf8.RuneCountInString(strings.TrimSpace(rest)) >= MinPrompt {
+			return rest
+		}
+	}
+	return s
+}
+
+// CodeOptions control the Go source made by GoSource()
+type CodeOptions struct {
+	// Package is the package clause, the default is main
+	Package string
+
+	// Func is the name of the function, the default is Run
+	Func string
+
+	// Timeout is passed to SetTimeout(), the default is 10 seconds
+	Timeout time.Duration
+
+	// Pauses, if true, sleeps before each Send for as long as the user
+	// paused before typing
+	Pauses bool
+}
+
+// GoSource returns the source of a Go file with a function that repeats the
+// recorded session. The function returns an error if an expected prompt does
+// not appear or, when the recording ended with the program exiting, the exit
+// code differs.
+func (rec *Recording) GoSource(opts CodeOptions) ([]byte, error) {
+	if opts.Package == "" {
+		opts.Package = "main"
+	}
+	if opts.Func == "" {
+		opts.Func = "Run"
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	steps, _ := rec.Steps()
+
+	var body bytes.Buffer
+	usesRegexp := false
+	args := []string{strconv.Quote(rec.Command)}
+	for _, a := range rec.Args {
+		args = append(args, strconv.Quote(a))
+	}
+	fmt.Fprintf(&body, "exp, err := expect.NewExpect(%s)\n", strings.Join(args, ", "))
+	fmt.Fprintf(&body, "if err != nil {\nreturn err\n}\ndefer exp.Kill()\n")
+	fmt.Fprintf(&body, "exp.SetTimeout(%s)\n\n", durationSource(opts.Timeout))
+	fmt.Fprintf(&body, "wait := func(pattern interface{}) error {\n")
+	fmt.Fprintf(&body, "if n, _, err := exp.Expect(pattern); n < 0 {\n")
+	fmt.Fprintf(&body, "if err == nil {\nerr = errors.New(\"program closed its output\")\n}\n")
+	fmt.Fprintf(&body, "return fmt.Errorf(\"waiting for %%q: %%w\", pattern, err)\n}\nreturn nil\n}\n")
+
+	for _, st := range steps {
+		fmt.Fprintln(&body)
+		if st.Prompt != "" {
+			pattern := strconv.Quote(st.Prompt)
+			if st.PromptRegexp {
+				usesRegexp = true
+				pattern = "regexp.MustCompile(" + goRegexp(st.Prompt) + ")"
+			}
+			fmt.Fprintf(&body, "if err := wait(%s); err != nil {\nreturn err\n}\n", pattern)
+		}
+		if opts.Pauses && st.Pause >= 100*time.Millisecond {
+			fmt.Fprintf(&body, "time.Sleep(%s)\n", durationSource(st.Pause.Round(100*time.Millisecond)))
+		}
+		fmt.Fprintf(&body, "if _, err := exp.Send(%s); err != nil {\nreturn err\n}\n", strconv.Quote(string(st.Send)))
+	}
+
+	if rec.Exited {
+		fmt.Fprintf(&body, "\nif n, _, err := exp.Expect(expect.EndOfFile); n != 0 {\n")
+		fmt.Fprintf(&body, "return fmt.Errorf(\"waiting for the program to exit: %%v\", err)\n}\n")
+		fmt.Fprintf(&body, "exp.Wait()\nif code := exp.ExitCode(); code != %d {\n", rec.ExitCode)
+		fmt.Fprintf(&body, "return fmt.Errorf(\"exit code %%d not %d\", code)\n}\n", rec.ExitCode)
+	}
+	fmt.Fprintf(&body, "return nil\n")
+
+	var src bytes.Buffer
+	fmt.Fprintf(&src, "// Generated by autoexpect from a session with %s\n", rec.commandLine())
+	fmt.Fprintf(&src, "// recorded at %s. Edit it to suit.\n\n", rec.Started.Format("2006-01-02 15:04"))
+	fmt.Fprintf(&src, "package %s\n\nimport (\n\"errors\"\n\"fmt\"\n", opts.Package)
+	if usesRegexp {
+		fmt.Fprintf(&src, "\"regexp\"\n")
+	}
+	fmt.Fprintf(&src, "\"time\"\n\n\"github.com/leemcloughlin/expect\"\n)\n\n")
+	fmt.Fprintf(&src, "// %s repeats the recorded session with %s\n", opts.Func, rec.Command)
+	fmt.Fprintf(&src, "func %s() error {\n%s}\n", opts.Func, body.String())
+	return format.Source(src.Bytes())
+}
+
+// commandLine returns the command and args on one line, quoting any args
+// that need it
+func (rec *Recording) commandLine() string {
+	words := []string{rec.Command}
+	for _, a := range rec.Args {
+		if a == "" || strings.ContainsAny(a, " \t\r\n\"'\\") || !strconv.IsPrint(rune(a[0])) {
+			a = strconv.Quote(a)
+		}
+		words = append(words, a)
+	}
+	return strings.Join(words, " ")
+}
+
+// durationSource returns d as Go source
+func durationSource(d time.Duration) string {
+	switch {
+	case d%time.Second == 0:
+		return fmt.Sprintf("%d * time.Second", d/time.Second)
+	default:
+		return fmt.Sprintf("%d * time.Millisecond", d/time.Millisecond)
+	}
+}
+
+// goRegexp returns re as a Go string literal, raw if it can be
+func goRegexp(re string) string {
+	if !strings.ContainsAny(re, "`\r\n") && strconv.CanBackquote(re) {
+		return "`" + re + "`"
+	}
+	return strconv.Quote(re)
+}
@@ -0,0 +1,124 @@
+/*
+File summary: Record an interactive session with a program
+Package: autoexpect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+// Package autoexpect records a user driving a program by hand, as the
+// original autoexpect does, and turns the recording into a Go function that
+// repeats the session with expect.NewExpect(), Expect() and Send():
+//
+//	rec, err := autoexpect.Record(ctx, os.Stdin, os.Stdout, "./install.sh")
+//	...
+//	src, err := rec.GoSource(autoexpect.CodeOptions{Func: "Install"})
+//
+// The generated code waits for a stable fragment of what the program printed
+// before each thing the user typed. It is a starting point to be edited, not
+// a finished test.
+package autoexpect
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/leemcloughlin/expect"
+)
+
+// Chunk is some output from the program or some input typed by the user
+type Chunk struct {
+	// Input is true for what the user typed
+	Input bool
+	Data  []byte
+
+	// At is when the chunk arrived, from the start of the recording
+	At time.Duration
+}
+
+// Recording is a session with a program as a sequence of Chunks
+type Recording struct {
+	Command string
+	Args    []string
+
+	Chunks []Chunk
+
+	// Exited is true if the program ended the session by exiting, when
+	// ExitCode is its exit code
+	Exited   bool
+	ExitCode int
+
+	// Started is when the recording started
+	Started time.Time
+}
+
+// ExitWait is how long Record waits for the program to exit after it closes
+// its output
+var ExitWait = 2 * time.Second
+
+// Record runs prog under expect.Interact() with in and out as the user's
+// terminal and records what is typed and printed until the program exits, in
+// ends or ctx is done. If the program is still running it is killed.
+func Record(ctx context.Context, in io.Reader, out io.Writer, prog string, args ...string) (*Recording, error) {
+	rec := &Recording{Command: prog, Args: args, Started: time.Now()}
+	var mu sync.Mutex
+	add := func(input bool, p []byte) {
+		if len(p) == 0 {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		rec.Chunks = append(rec.Chunks, Chunk{Input: input, Data: append([]byte{}, p...), At: time.Since(rec.Started)})
+	}
+
+	exp, err := expect.NewExpect(prog, args...)
+	if err != nil {
+		return nil, err
+	}
+	err = exp.Interact(ctx, &inputRecorder{in, add}, &outputRecorder{out, add})
+
+	if exp.Eof {
+		timer := time.NewTimer(ExitWait)
+		defer timer.Stop()
+		select {
+		case <-exp.Done():
+			exp.Wait()
+			rec.Exited = true
+			rec.ExitCode = exp.ExitCode()
+		case <-timer.C:
+		}
+	}
+	if !rec.Exited {
+		exp.Kill()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	return rec, err
+}
+
+// inputRecorder records what is read from the user
+type inputRecorder struct {
+	r   io.Reader
+	add func(bool, []byte)
+}
+
+func (ir *inputRecorder) Read(p []byte) (int, error) {
+	n, err := ir.r.Read(p)
+	ir.add(true, p[:n])
+	return n, err
+}
+
+// outputRecorder records what the program writes to the user
+type outputRecorder struct {
+	w   io.Writer
+	add func(bool, []byte)
+}
+
+func (or *outputRecorder) Write(p []byte) (int, error) {
+	or.add(false, p)
+	return or.w.Write(p)
+}
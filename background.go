@@ -0,0 +1,321 @@
+/*
+File summary: Watch for patterns in the background as expect_background does
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"context"
+	"regexp"
+	"sync"
+)
+
+// Background watches for its patterns while the process runs. See
+// ExpectBackground()
+type Background struct {
+	exp   *Expect
+	cases []Case
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// matcher searches Buffer for the patterns. bufLen and bufferEdits are
+	// Buffer's length and Expect's bufferEdits when it last did so it can
+	// tell if only a byte has been added since.
+	matcher     *matcher
+	bufLen      int
+	bufferEdits int
+
+	// calls are the handlers waiting to be run, wake is signalled when one
+	// is added
+	mu    sync.Mutex
+	calls []backgroundCall
+	eof   bool
+	wake  chan struct{}
+
+	done chan struct{}
+	err  error
+}
+
+// backgroundCall is a match waiting for its handler
+type backgroundCall struct {
+	n     int
+	found []byte
+}
+
+// ExpectBackground watches for the patterns of cases in the background and
+// calls the Handler of each one that matches, for instance to notice an
+// alert such as "panic:" while the rest of the program gets on with other
+// things. The patterns are strings, *regexp.Regexp or EndOfFile, whose
+// Handler is called with nil once the process closes its output.
+//
+// Output is only read once. While Expect(), or anything built on it, is
+// running the background patterns are checked after its own patterns so
+// Expect() sees everything first. Otherwise the Background reads the output
+// itself and what does not match is left in Buffer for the next Expect().
+// Either way a background match removes just the text it matched from
+// Buffer. Interact() does not check background patterns.
+//
+// Handlers run one at a time on a goroutine of their own, so they may call
+// Send() or even Expect(). A Handler that returns an error stops the
+// Background, as do ctx being done, Stop() and the process closing its
+// output. Patterns that are not allowed give a *PatternError.
+// While a Background is running only use Buffer through the methods of
+// Expect, such as Expect(), Clear() and BufStr().
+func (exp *Expect) ExpectBackground(ctx context.Context, cases ...Case) (*Background, error) {
+	for n, c := range cases {
+		switch c.Pattern.(type) {
+		case string, *regexp.Regexp:
+			continue
+		}
+		if c.Pattern != EndOfFile {
+			return nil, &PatternError{Index: n, Pattern: c.Pattern}
+		}
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	bg := &Background{
+		exp:   exp,
+		cases: cases,
+		wake:  make(chan struct{}, 1),
+		done:  make(chan struct{}),
+
+		bufLen: -1,
+	}
+	bg.matcher = newMatcher(bg.patterns())
+	bg.ctx, bg.cancel = context.WithCancel(ctx)
+
+	exp.backgroundMu.Lock()
+	exp.backgrounds = append(exp.backgrounds, bg)
+	if exp.backgroundEOF {
+		bg.ended()
+	}
+	exp.backgroundMu.Unlock()
+	go bg.run()
+	return bg, nil
+}
+
+// Stop stops watching and waits for any running handler to return. Do not
+// call Stop() from a handler, return an error instead.
+func (bg *Background) Stop() {
+	bg.cancel()
+	<-bg.done
+}
+
+// Done returns a channel that is closed once the Background has stopped
+func (bg *Background) Done() <-chan struct{} {
+	return bg.done
+}
+
+// Err returns the error from the handler that stopped the Background, if any.
+// It is only safe to call once Done() is closed.
+func (bg *Background) Err() error {
+	return bg.err
+}
+
+// run reads the output while nothing else is and runs the handlers
+func (bg *Background) run() {
+	exp := bg.exp
+	defer close(bg.done)
+	defer exp.removeBackground(bg)
+	for {
+		if !bg.runHandlers() {
+			return
+		}
+		select {
+		case <-bg.ctx.Done():
+			return
+		case <-exp.endExpectReader:
+			return
+		case <-bg.wake:
+			continue
+		case exp.readLock <- struct{}{}:
+		}
+		exp.readBackground(bg)
+		exp.unlockRead()
+	}
+}
+
+// runHandlers calls the handlers for the matches so far. It returns false if
+// the Background is to stop.
+func (bg *Background) runHandlers() bool {
+	for {
+		bg.mu.Lock()
+		if len(bg.calls) == 0 {
+			eof := bg.eof
+			bg.mu.Unlock()
+			return !eof
+		}
+		call := bg.calls[0]
+		bg.calls = bg.calls[1:]
+		bg.mu.Unlock()
+
+		if bg.ctx.Err() != nil {
+			return false
+		}
+		if handler := bg.cases[call.n].Handler; handler != nil {
+			debugf("Background handler %d for %q", call.n, call.found)
+			if err := handler(call.found); err != nil {
+				bg.err = err
+				return false
+			}
+		}
+	}
+}
+
+// add queues the handler of case n
+func (bg *Background) add(n int, found []byte) {
+	bg.mu.Lock()
+	bg.calls = append(bg.calls, backgroundCall{n: n, found: found})
+	bg.mu.Unlock()
+	select {
+	case bg.wake <- struct{}{}:
+	default:
+	}
+}
+
+// ended queues the EndOfFile handler, if any, and stops the Background once
+// the handlers have run
+func (bg *Background) ended() {
+	if n := pseudoIndex(bg.patterns(), EndOfFile); n >= 0 {
+		bg.add(n, nil)
+	}
+	bg.mu.Lock()
+	bg.eof = true
+	bg.mu.Unlock()
+	select {
+	case bg.wake <- struct{}{}:
+	default:
+	}
+}
+
+// patterns returns the Pattern of each case
+func (bg *Background) patterns() []interface{} {
+	pats := make([]interface{}, len(bg.cases))
+	for n, c := range bg.cases {
+		pats[n] = c.Pattern
+	}
+	return pats
+}
+
+// readBackground reads what output has arrived and checks it against the
+// background patterns. It returns when there is no more, when something else
+// wants to read or bg is to stop. The caller holds the read lock.
+func (exp *Expect) readBackground(bg *Background) {
+	for {
+		var boe byteIn
+		select {
+		case boe = <-exp.bytesIn:
+		case <-exp.wantRead:
+			return
+		case <-bg.wake:
+			// There are handlers for run() to call
+			return
+		case <-bg.ctx.Done():
+			return
+		case <-exp.endExpectReader:
+			return
+		}
+
+		if boe.isEOF || boe.err != nil {
+			// Leave the end for Expect() to find after whatever is in Buffer
+			debugf("Background read the end of the output")
+			exp.sendIn(boe)
+			exp.endBackgrounds()
+			return
+		}
+		if boe.isByte {
+			exp.Buffer.WriteByte(boe.b)
+			exp.matchBackground()
+			exp.discardOverMax()
+		}
+	}
+}
+
+// matchBackground checks Buffer against the patterns of every Background,
+// removing each match and queueing its handler. It returns true if anything
+// was removed. The caller holds the read lock.
+func (exp *Expect) matchBackground() bool {
+	removed := false
+	exp.backgroundMu.Lock()
+	defer exp.backgroundMu.Unlock()
+	for _, bg := range exp.backgrounds {
+		if bg.ctx.Err() != nil {
+			continue
+		}
+		bufBytes := exp.Buffer.Bytes()
+		var n, start, end int
+		bg.matcher.context = exp.bufferContext
+		if len(bufBytes) == bg.bufLen+1 && exp.bufferEdits == bg.bufferEdits {
+			n, start, end = bg.matcher.next(bufBytes)
+		} else {
+			n, start, end = bg.matcher.scan(bufBytes)
+		}
+		bg.bufLen, bg.bufferEdits = len(bufBytes), exp.bufferEdits
+		if n < 0 {
+			continue
+		}
+		found := make([]byte, end-start)
+		copy(found, bufBytes[start:end])
+		debugf("Background found %s", found)
+		newBuf := append(append([]byte{}, bufBytes[:start]...), bufBytes[end:]...)
+		exp.Buffer.Reset()
+		exp.Buffer.Write(newBuf)
+		exp.bufferEdits++
+		if start == 0 {
+			exp.bufferContext = nil
+		}
+		bg.add(n, found)
+		removed = true
+	}
+	return removed
+}
+
+// endBackgrounds tells every Background that the output has ended
+func (exp *Expect) endBackgrounds() {
+	exp.backgroundMu.Lock()
+	defer exp.backgroundMu.Unlock()
+	exp.backgroundEOF = true
+	for _, bg := range exp.backgrounds {
+		bg.ended()
+	}
+}
+
+// removeBackground forgets bg once it has stopped
+func (exp *Expect) removeBackground(bg *Background) {
+	bg.cancel()
+	exp.backgroundMu.Lock()
+	defer exp.backgroundMu.Unlock()
+	for n, b := range exp.backgrounds {
+		if b == bg {
+			exp.backgrounds = append(exp.backgrounds[:n], exp.backgrounds[n+1:]...)
+			return
+		}
+	}
+}
+
+// lockRead takes the right to read bytesIn and use Buffer, asking any
+// Background that has it to give it up
+func (exp *Expect) lockRead() {
+	select {
+	case exp.readLock <- struct{}{}:
+		return
+	default:
+	}
+	select {
+	case exp.wantRead <- struct{}{}:
+	default:
+	}
+	exp.readLock <- struct{}{}
+}
+
+// unlockRead gives up the right taken by lockRead()
+func (exp *Expect) unlockRead() {
+	<-exp.readLock
+}
@@ -0,0 +1,157 @@
+/*
+File summary: go test of ExpectBackground
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func Test_ExpectBackground(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	exp, err := NewExpect("sh", "-c", "sleep 0.1; echo working; echo 'panic: boom'; echo more; sleep 5")
+	if err != nil {
+		t.Fatalf("NewExpect failed %s", err)
+	}
+	defer exp.Kill()
+	exp.SetTimeout(5 * time.Second)
+
+	alerts := make(chan string, 10)
+	bg, err := exp.ExpectBackground(context.Background(), Case{
+		Pattern: regexp.MustCompile(`panic: (\w+)\r\n`),
+		Handler: func(found []byte) error {
+			alerts <- string(found)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("ExpectBackground failed %s", err)
+	}
+
+	// Nothing is waiting in the foreground
+	select {
+	case alert := <-alerts:
+		if alert != "panic: boom\r\n" {
+			t.Errorf("alert is %q", alert)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no alert")
+	}
+
+	// What did not match is left for Expect and what did is gone
+	for _, want := range []string{"working", "more"} {
+		if n, _, err := exp.Expect(want); n != 0 {
+			t.Errorf("Expect %s after the alert failed %d %v", want, n, err)
+		}
+	}
+	exp.SetTimeout(100 * time.Millisecond)
+	if n, _, _ := exp.Expect("panic"); n != TimedOut {
+		t.Errorf("Expect found the alert again %d", n)
+	}
+
+	bg.Stop()
+	if bg.Err() != nil {
+		t.Errorf("Stop gave %s", bg.Err())
+	}
+}
+
+func Test_ExpectBackgroundForeground(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	exp, err := NewExpect("sh", "-c", `echo start; sleep 0.2; echo ALERT; printf 'Continue? '; read a; echo "finished $a"`)
+	if err != nil {
+		t.Fatalf("NewExpect failed %s", err)
+	}
+	defer exp.Kill()
+	exp.SetTimeout(5 * time.Second)
+
+	var alerts, eofs int
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bg, err := exp.ExpectBackground(ctx,
+		Case{Pattern: "ALERT", Handler: func([]byte) error { alerts++; return nil }},
+		Case{Pattern: "Continue? ", Handler: func([]byte) error {
+			_, err := exp.Send("yes\r")
+			return err
+		}},
+		Case{Pattern: EndOfFile, Handler: func(found []byte) error { eofs++; return nil }},
+	)
+	if err != nil {
+		t.Fatalf("ExpectBackground failed %s", err)
+	}
+
+	// The foreground sees the output first, then the background, whose
+	// handler answers the question
+	if n, _, err := exp.Expect("start"); n != 0 {
+		t.Errorf("Expect start failed %d %v", n, err)
+	}
+	if n, _, err := exp.Expect("finished yes"); n != 0 {
+		t.Errorf("Expect finished failed %d %v", n, err)
+	}
+	if n, _, err := exp.Expect(EndOfFile); n != 0 {
+		t.Errorf("Expect EndOfFile failed %d %v", n, err)
+	}
+	select {
+	case <-bg.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Background did not stop at EOF")
+	}
+	if alerts != 1 || eofs != 1 {
+		t.Errorf("%d alerts and %d eofs", alerts, eofs)
+	}
+}
+
+func Test_ExpectBackgroundStops(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	if _, err := (&Expect{}).ExpectBackground(context.Background(), Case{Pattern: Timeout}); !errors.Is(err, ENotStringOrRexgexp) {
+		t.Errorf("ExpectBackground of Timeout gave %v", err)
+	}
+
+	exp, err := NewExpect("cat")
+	if err != nil {
+		t.Fatalf("NewExpect failed %s", err)
+	}
+	defer exp.Kill()
+	exp.SetTimeout(5 * time.Second)
+
+	// A handler error stops the Background
+	stop := errors.New("stop")
+	bg, _ := exp.ExpectBackground(context.Background(), Case{Pattern: "quit", Handler: func([]byte) error { return stop }})
+	exp.Send("quit\r")
+	select {
+	case <-bg.Done():
+		if bg.Err() != stop {
+			t.Errorf("Err is %v", bg.Err())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Background did not stop")
+	}
+
+	// As does cancelling its ctx, after which its patterns are left alone
+	ctx, cancel := context.WithCancel(context.Background())
+	bg, _ = exp.ExpectBackground(ctx, Case{Pattern: "hello", Handler: func([]byte) error {
+		t.Errorf("handler called after cancel")
+		return nil
+	}})
+	cancel()
+	<-bg.Done()
+	exp.Send("hello\r")
+	if n, _, err := exp.Expect("hello"); n != 0 {
+		t.Errorf("Expect hello failed %d %v", n, err)
+	}
+}
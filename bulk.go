@@ -0,0 +1,171 @@
+/*
+File summary: Flow-controlled bulk sending and bracketed paste
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"bytes"
+	"time"
+)
+
+const (
+	// PasteStart and PasteEnd wrap text sent in bracketed paste mode
+	PasteStart = "\x1b[200~"
+	PasteEnd   = "\x1b[201~"
+)
+
+// BulkOptions control SendBulk()
+type BulkOptions struct {
+	// ChunkSize is the most bytes written at once. Zero means 255, the
+	// usual MAX_CANON. Chunks are ended after a newline where possible.
+	ChunkSize int
+
+	// WaitEcho waits for and consumes the echo of each chunk, as
+	// SendAndConsumeEcho() does, before sending the next. Otherwise SendBulk
+	// waits for the command to read each chunk from the pty.
+	WaitEcho bool
+
+	// Delay is a pause after each chunk, used when neither the echo nor the
+	// pty's input queue can be watched. In canonical mode the queue cannot be
+	// watched after a chunk that ends part way through a line.
+	Delay time.Duration
+
+	// BracketedPaste wraps the text in PasteStart and PasteEnd if the command
+	// has turned on bracketed paste mode (ESC[?2004h), so editors and shells
+	// treat it as one paste rather than typed lines. WaitEcho is ignored for
+	// a bracketed paste as programs in that mode do their own echoing.
+	BracketedPaste bool
+}
+
+// BracketedPasteMode returns true if the command has turned on bracketed
+// paste mode
+func (exp *Expect) BracketedPasteMode() bool {
+	return exp.keyModes.bracketedPaste.Load()
+}
+
+// SendBulk sends s, which can be large, in chunks so that the pty's input
+// buffer does not overflow and drop bytes. After each chunk it waits for the
+// chunk's echo or for the command to read it, see BulkOptions. If opts is nil
+// the defaults are used.
+// Note that in canonical mode a single line longer than the line discipline's
+// buffer (4095 bytes on Linux) is still truncated by the kernel.
+// The timeout set by SetTimeout() applies to each wait.
+// Note: the return is the number of bytes of s sent
+func (exp *Expect) SendBulk(s string, opts *BulkOptions) (int, error) {
+	if opts == nil {
+		opts = &BulkOptions{}
+	}
+	chunkSize := opts.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = 255
+	}
+
+	bracketed := opts.BracketedPaste && exp.BracketedPasteMode()
+	if bracketed {
+		if _, err := exp.Send(PasteStart); err != nil {
+			return 0, err
+		}
+	}
+
+	sent := 0
+	for _, chunk := range splitChunks([]byte(s), chunkSize) {
+		var n int
+		var err error
+		if opts.WaitEcho && !bracketed {
+			n, err = exp.SendAndConsumeEcho(string(chunk))
+		} else {
+			n, err = exp.Write(chunk)
+			if err == nil {
+				err = exp.waitDrained(chunk, opts.Delay)
+			}
+		}
+		sent += n
+		if err != nil {
+			return sent, err
+		}
+	}
+
+	if bracketed {
+		if _, err := exp.Send(PasteEnd); err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// waitDrained waits until the command has read everything written to the
+// pty, the last of which was chunk. If that cannot be found out it sleeps for
+// delay instead.
+func (exp *Expect) waitDrained(chunk []byte, delay time.Duration) error {
+	// In canonical mode only complete lines are counted as pending input, so
+	// a chunk ending part way through a line cannot be watched
+	if t, err := exp.GetTermios(); err == nil && t.Flag(TermIcanon) {
+		last := chunk[len(chunk)-1]
+		if last != '\n' && !(last == '\r' && t.Flag(TermIcrnl)) {
+			time.Sleep(delay)
+			return nil
+		}
+	}
+
+	started := time.Now()
+	for {
+		pending, err := pendingInput(exp.File)
+		if err != nil {
+			debugf("waitDrained cannot watch input: %s", err)
+			time.Sleep(delay)
+			return nil
+		}
+		if pending == 0 {
+			time.Sleep(delay)
+			return nil
+		}
+		waitingFor := []interface{}{"pty input drained"}
+		if exp.timeout != 0 && time.Since(started) > exp.timeout {
+			return &TimeoutError{
+				Patterns: waitingFor,
+				Elapsed:  time.Since(started),
+				Tail:     errorTail([]byte(exp.BufStr())),
+			}
+		}
+		select {
+		case <-exp.Done():
+			return &EOFError{
+				Patterns: waitingFor,
+				Elapsed:  time.Since(started),
+				Tail:     errorTail([]byte(exp.BufStr())),
+			}
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+}
+
+// splitChunks splits b into chunks of at most size bytes ending each after
+// the last newline or carriage return in it where there is one, and never
+// in the middle of a UTF-8 sequence
+func splitChunks(b []byte, size int) [][]byte {
+	var chunks [][]byte
+	for len(b) > size {
+		end := bytes.LastIndexAny(b[:size], "\r\n") + 1
+		if end <= 0 {
+			end = size
+			for end > 0 && b[end]&0xc0 == 0x80 {
+				end--
+			}
+			if end == 0 {
+				end = size
+			}
+		}
+		chunks = append(chunks, b[:end])
+		b = b[end:]
+	}
+	if len(b) > 0 {
+		chunks = append(chunks, b)
+	}
+	return chunks
+}
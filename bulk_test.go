@@ -0,0 +1,118 @@
+/*
+File summary: go test of bulk sending
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_SplitChunks(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	chunks := splitChunks([]byte("abc\ndefgh\nij世界"), 6)
+	expected := []string{"abc\n", "defgh\n", "ij世", "界"}
+	if len(chunks) != len(expected) {
+		t.Errorf("got %d chunks %q not %q", len(chunks), chunks, expected)
+		return
+	}
+	for i, chunk := range chunks {
+		if string(chunk) != expected[i] {
+			t.Errorf("chunk %d is %q not %q", i, chunk, expected[i])
+		}
+	}
+}
+
+// bulkLines returns lines of text and the byte count wc will see
+func bulkLines(lines, width int) (string, int) {
+	line := strings.Repeat("x", width-1) + "\r"
+	return strings.Repeat(line, lines), lines * width
+}
+
+func Test_SendBulk(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	for _, waitEcho := range []bool{false, true} {
+		exp, err := NewExpect("wc", "-c")
+		if err != nil {
+			t.Errorf("NewExpect failed %s", err)
+			return
+		}
+		exp.SetTimeoutSecs(10) // Shouldn't happen
+
+		text, count := bulkLines(200, 100)
+		sent, err := exp.SendBulk(text, &BulkOptions{WaitEcho: waitEcho})
+		if err != nil || sent != len(text) {
+			t.Errorf("SendBulk sent %d of %d: %s", sent, len(text), err)
+		}
+		exp.SendEOF()
+
+		pat := strconv.Itoa(count)
+		n, found, err := exp.Expect(pat)
+		checkResultStr(t, pat, 0, n, found, err)
+		showWaitResult(t, exp)
+	}
+}
+
+func Test_SendBulkLongLines(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	exp, err := NewExpect("wc", "-c")
+	if err != nil {
+		t.Errorf("NewExpect failed %s", err)
+		return
+	}
+	exp.SetTimeoutSecs(10) // Shouldn't happen
+
+	// Chunks ending part way through a line cannot be watched in canonical
+	// mode so are paced by Delay
+	text, count := bulkLines(20, 1000)
+	sent, err := exp.SendBulk(text, &BulkOptions{Delay: time.Millisecond})
+	if err != nil || sent != len(text) {
+		t.Errorf("SendBulk sent %d of %d: %s", sent, len(text), err)
+	}
+	exp.SendEOF()
+
+	pat := strconv.Itoa(count)
+	n, found, err := exp.Expect(pat)
+	checkResultStr(t, pat, 0, n, found, err)
+	showWaitResult(t, exp)
+}
+
+func Test_SendBulkBracketedPaste(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	exp, err := NewExpect("sh", "-c", `printf '\033[?2004hready\n'; cat`)
+	if err != nil {
+		t.Errorf("NewExpect failed %s", err)
+		return
+	}
+	exp.SetTimeoutSecs(5) // Shouldn't happen
+	exp.Expect("ready")
+	if !exp.BracketedPasteMode() {
+		t.Errorf("bracketed paste mode not seen")
+	}
+
+	exp.SendBulk("pasted\r", &BulkOptions{BracketedPaste: true})
+
+	t.Log("cat writes the paste back, markers included")
+	pat := PasteStart + "pasted"
+	n, found, err := exp.Expect(pat)
+	checkResultStr(t, pat, 0, n, found, err)
+
+	exp.Kill()
+	showWaitResult(t, exp)
+}
@@ -0,0 +1,367 @@
+/*
+File summary: chat(8) style expect/send scripts
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var (
+	// ChatDelay is the pause for \d in a Chat() send string
+	ChatDelay = time.Second
+
+	// ChatPause is the pause for \p in a Chat() send string
+	ChatPause = 100 * time.Millisecond
+)
+
+// EChatAborted matches a *ChatError for a script that saw an ABORT string
+var EChatAborted = errors.New("chat aborted")
+
+// ChatError is returned by Chat() for the string in the script that failed
+type ChatError struct {
+	// Index is the position of the failing string in the script and Arg
+	// is the string itself
+	Index int
+	Arg   string
+
+	// Abort is the ABORT string that was seen, if that is why the script
+	// failed, when Err is EChatAborted
+	Abort string
+
+	// Err is a *TimeoutError, *EOFError, *ReadError, EChatAborted or an
+	// error in the script or from sending
+	Err error
+}
+
+func (e *ChatError) Error() string {
+	if e.Abort != "" {
+		return fmt.Sprintf("chat: script[%d] %q: aborted on %q", e.Index, e.Arg, e.Abort)
+	}
+	return fmt.Sprintf("chat: script[%d] %q: %s", e.Index, e.Arg, e.Err)
+}
+
+// Unwrap returns Err
+func (e *ChatError) Unwrap() error {
+	return e.Err
+}
+
+// Chat runs a script in the style of chat(8), as used for modems and boot
+// loaders. The script is pairs of strings, the first to expect and the second
+// to send when it is seen:
+//
+//	exp.Chat("ABORT", "BUSY", "ABORT", "NO CARRIER", "", "ATZ", "OK", "ATDT123", "CONNECT", "")
+//
+// An empty expect string waits for nothing. An expect string can have
+// alternatives as "expect-send-expect...", so "ogin:--ogin:" sends a carriage
+// return and waits again if the first "ogin:" times out. A send string is
+// followed by a carriage return, so "" sends one on its own.
+//
+// These keywords take the next string as their argument instead:
+//
+//	ABORT	fail the script if the string is seen while expecting
+//	TIMEOUT	the timeout, in seconds or as a time.Duration, for the expects
+//		that follow
+//
+// Strings may hold these escapes:
+//
+//	\b	backspace
+//	\c	at the end of a send string, do not send a carriage return
+//	\d	(send only) pause for ChatDelay
+//	\n	newline
+//	\N	null
+//	\p	(send only) pause for ChatPause
+//	\r	carriage return
+//	\s	space
+//	\t	tab
+//	\\	backslash
+//	\ddd	the byte with octal value ddd
+//	^C	the control character C
+//
+// As well as \- for a "-" and \^ for a "^". The timeout is restored when Chat
+// returns. Any failure is a *ChatError saying which string failed.
+func (exp *Expect) Chat(script ...string) error {
+	saved := exp.timeout
+	defer exp.SetTimeout(saved)
+
+	var aborts []string
+	for i := 0; i < len(script); i++ {
+		arg := script[i]
+		switch arg {
+		case "ABORT", "TIMEOUT":
+			if i+1 == len(script) {
+				return &ChatError{Index: i, Arg: arg, Err: fmt.Errorf("%s needs an argument", arg)}
+			}
+			i++
+			var err error
+			if arg == "ABORT" {
+				var abort string
+				if abort, err = chatExpectString(script[i]); err == nil {
+					aborts = append(aborts, abort)
+				}
+			} else {
+				var timeout time.Duration
+				if timeout, err = chatTimeout(script[i]); err == nil {
+					exp.SetTimeout(timeout)
+				}
+			}
+			if err != nil {
+				return &ChatError{Index: i, Arg: script[i], Err: err}
+			}
+			continue
+		}
+
+		if abort, err := exp.chatExpect(arg, aborts); err != nil {
+			return &ChatError{Index: i, Arg: arg, Abort: abort, Err: err}
+		}
+		if i+1 < len(script) {
+			i++
+			if err := exp.chatSend(script[i]); err != nil {
+				return &ChatError{Index: i, Arg: script[i], Err: err}
+			}
+		}
+	}
+	return nil
+}
+
+// SplitChat splits a chat script held in one string, such as the contents
+// of a chat(8) script file, into the strings Chat() takes. Strings are
+// separated by white space and may be quoted with ' or ". Backslashes are
+// left for Chat() to interpret except before a quote.
+func SplitChat(s string) ([]string, error) {
+	var args []string
+	for {
+		s = strings.TrimLeftFunc(s, unicode.IsSpace)
+		if s == "" {
+			return args, nil
+		}
+		var arg strings.Builder
+		var quote byte
+		i := 0
+	word:
+		for ; i < len(s); i++ {
+			c := s[i]
+			switch {
+			case c == '\\' && i+1 < len(s) && (s[i+1] == '\'' || s[i+1] == '"'):
+				i++
+				arg.WriteByte(s[i])
+			case c == '\\' && i+1 < len(s):
+				arg.WriteString(s[i : i+2])
+				i++
+			case quote != 0:
+				if c == quote {
+					quote = 0
+				} else {
+					arg.WriteByte(c)
+				}
+			case c == '\'' || c == '"':
+				quote = c
+			case unicode.IsSpace(rune(c)):
+				break word
+			default:
+				arg.WriteByte(c)
+			}
+		}
+		if quote != 0 {
+			return nil, fmt.Errorf("chat: unterminated %c in %q", quote, s)
+		}
+		args = append(args, arg.String())
+		s = s[i:]
+	}
+}
+
+// chatExpect waits for the expect string, and its alternatives, from a chat
+// script. If an abort string is seen it is returned with EChatAborted.
+func (exp *Expect) chatExpect(arg string, aborts []string) (string, error) {
+	parts := splitUnescaped(arg, '-')
+	for n := 0; n < len(parts); n += 2 {
+		want, err := chatExpectString(parts[n])
+		if err != nil {
+			return "", err
+		}
+		if want == "" {
+			return "", nil
+		}
+
+		pats := []interface{}{want}
+		for _, abort := range aborts {
+			pats = append(pats, abort)
+		}
+		pats = append(pats, EndOfFile)
+		started := time.Now()
+		found, buffered, err := exp.Expect(pats...)
+		switch {
+		case found == 0:
+			return "", nil
+		case found == len(pats)-1:
+			return "", &EOFError{Patterns: pats[:found], Elapsed: time.Since(started), Tail: errorTail(buffered)}
+		case found > 0:
+			debugf("Chat abort on %q", aborts[found-1])
+			return aborts[found-1], EChatAborted
+		case found == TimedOut && n+1 < len(parts):
+			debugf("Chat timed out on %q, trying %q", want, parts[n+1])
+			if err := exp.chatSend(parts[n+1]); err != nil {
+				return "", err
+			}
+		default:
+			return "", err
+		}
+	}
+	// The alternatives ended with a send
+	return "", nil
+}
+
+// chatSend sends a chat script send string, pausing where it asks
+func (exp *Expect) chatSend(arg string) error {
+	pieces, noCR, err := chatUnescape(arg, true)
+	if err != nil {
+		return err
+	}
+	if !noCR {
+		pieces = append(pieces, chatPiece{text: "\r"})
+	}
+	for _, p := range pieces {
+		if p.pause > 0 {
+			time.Sleep(p.pause)
+			continue
+		}
+		if _, err := exp.Send(p.text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chatExpectString is the text of a chat script expect string
+func chatExpectString(arg string) (string, error) {
+	pieces, _, err := chatUnescape(arg, false)
+	if err != nil {
+		return "", err
+	}
+	var s strings.Builder
+	for _, p := range pieces {
+		s.WriteString(p.text)
+	}
+	return s.String(), nil
+}
+
+// chatTimeout parses the argument of TIMEOUT
+func chatTimeout(arg string) (time.Duration, error) {
+	if secs, err := strconv.Atoi(arg); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, nil
+	}
+	timeout, err := time.ParseDuration(arg)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("bad TIMEOUT %q", arg)
+	}
+	return timeout, nil
+}
+
+// chatPiece is text to send or a pause
+type chatPiece struct {
+	text  string
+	pause time.Duration
+}
+
+// chatUnescape interprets the escapes in a chat script string. The pauses,
+// and \c which is returned as noCR, are only allowed if sending.
+func chatUnescape(arg string, sending bool) (pieces []chatPiece, noCR bool, err error) {
+	var text strings.Builder
+	pause := func(d time.Duration) {
+		if text.Len() > 0 {
+			pieces = append(pieces, chatPiece{text: text.String()})
+			text.Reset()
+		}
+		pieces = append(pieces, chatPiece{pause: d})
+	}
+	for i := 0; i < len(arg); i++ {
+		c := arg[i]
+		if c == '^' && i+1 < len(arg) {
+			i++
+			text.WriteByte(arg[i] & 0x1f)
+			continue
+		}
+		if c != '\\' {
+			text.WriteByte(c)
+			continue
+		}
+		if i+1 == len(arg) {
+			return nil, false, fmt.Errorf("trailing \\ in %q", arg)
+		}
+		i++
+		c = arg[i]
+		switch c {
+		case 'b':
+			text.WriteByte('\b')
+		case 'n':
+			text.WriteByte('\n')
+		case 'N':
+			text.WriteByte(0)
+		case 'r':
+			text.WriteByte('\r')
+		case 's':
+			text.WriteByte(' ')
+		case 't':
+			text.WriteByte('\t')
+		case '\\', '-', '^', '\'', '"':
+			text.WriteByte(c)
+		case 'c', 'd', 'p':
+			if !sending {
+				return nil, false, fmt.Errorf("\\%c is only allowed when sending", c)
+			}
+			switch c {
+			case 'c':
+				if i+1 != len(arg) {
+					return nil, false, fmt.Errorf("\\c is only allowed at the end of %q", arg)
+				}
+				noCR = true
+			case 'd':
+				pause(ChatDelay)
+			case 'p':
+				pause(ChatPause)
+			}
+		default:
+			if c < '0' || c > '7' {
+				return nil, false, fmt.Errorf("unknown escape \\%c in %q", c, arg)
+			}
+			n := 0
+			for j := 0; j < 3 && i < len(arg) && arg[i] >= '0' && arg[i] <= '7'; j++ {
+				n = n*8 + int(arg[i]-'0')
+				i++
+			}
+			i--
+			text.WriteByte(byte(n))
+		}
+	}
+	if text.Len() > 0 {
+		pieces = append(pieces, chatPiece{text: text.String()})
+	}
+	return pieces, noCR, nil
+}
+
+// splitUnescaped splits s at each sep that does not follow a backslash
+func splitUnescaped(s string, sep byte) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
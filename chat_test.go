@@ -0,0 +1,137 @@
+/*
+File summary: go test of Chat
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// modem answers a few AT commands
+const modem = `while read -r line; do
+case "$line" in
+ATZ) echo OK;;
+ATDT123) echo CONNECT 9600;;
+ATDT999) echo BUSY;;
+*) echo ERROR;;
+esac
+done`
+
+func Test_Chat(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	defer func(delay, pause time.Duration) {
+		ChatDelay, ChatPause = delay, pause
+	}(ChatDelay, ChatPause)
+	ChatDelay, ChatPause = 50*time.Millisecond, 10*time.Millisecond
+
+	exp, err := NewExpect("sh", "-c", modem)
+	if err != nil {
+		t.Fatalf("NewExpect failed %s", err)
+	}
+	defer exp.Kill()
+	exp.SetTimeout(5 * time.Second)
+
+	err = exp.Chat("ABORT", "BUSY", "ABORT", "NO\\sCARRIER",
+		"", "\\dAT\\pZ", "OK", "ATDT123", "CONNECT", "\\c")
+	if err != nil {
+		t.Errorf("Chat failed %s", err)
+	}
+
+	// A busy line aborts
+	err = exp.Chat("ABORT", "BUSY", "", "ATDT999", "CONNECT", "")
+	t.Logf("abort %v", err)
+	var chatErr *ChatError
+	if !errors.As(err, &chatErr) || !errors.Is(err, EChatAborted) {
+		t.Fatalf("Chat with abort is %v", err)
+	}
+	if chatErr.Index != 4 || chatErr.Arg != "CONNECT" || chatErr.Abort != "BUSY" {
+		t.Errorf("ChatError is %+v", chatErr)
+	}
+
+	// An alternative is sent after a timeout and a timeout is an error
+	err = exp.Chat("TIMEOUT", "200ms", "", "ATX", "nothing-ATZ-OK", "", "CONNECT", "")
+	t.Logf("timeout %v", err)
+	if !errors.As(err, &chatErr) || !errors.Is(err, ETimedOut) || chatErr.Index != 6 {
+		t.Errorf("Chat with timeout is %v", err)
+	}
+	if exp.timeout != 5*time.Second {
+		t.Errorf("timeout not restored, it is %s", exp.timeout)
+	}
+
+	// As is a bad script
+	err = exp.Chat("", "AT", "OK\\d")
+	if !errors.As(err, &chatErr) || chatErr.Index != 2 {
+		t.Errorf("Chat with bad expect is %v", err)
+	}
+	err = exp.Chat("TIMEOUT", "soon")
+	if !errors.As(err, &chatErr) || chatErr.Index != 1 {
+		t.Errorf("Chat with bad TIMEOUT is %v", err)
+	}
+
+	// And the end of the output
+	err = exp.Chat("", "^D", "OK", "")
+	if !errors.As(err, &chatErr) || !errors.Is(err, io.EOF) || chatErr.Index != 2 {
+		t.Errorf("Chat at EOF is %v", err)
+	}
+}
+
+func Test_ChatUnescape(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	for _, tc := range []struct {
+		arg    string
+		pieces []chatPiece
+		noCR   bool
+		bad    bool
+	}{
+		{"", nil, false, false},
+		{"ATZ", []chatPiece{{text: "ATZ"}}, false, false},
+		{`a\b\n\N\r\s\t\\\-\^b`, []chatPiece{{text: "a\b\n\x00\r \t\\-^b"}}, false, false},
+		{`\101\0102^C^`, []chatPiece{{text: "A\x082\x03^"}}, false, false},
+		{`\dAT\p`, []chatPiece{{pause: ChatDelay}, {text: "AT"}, {pause: ChatPause}}, false, false},
+		{`+++\c`, []chatPiece{{text: "+++"}}, true, false},
+		{`a\cb`, nil, false, true},
+		{`\q`, nil, false, true},
+		{`a\`, nil, false, true},
+	} {
+		pieces, noCR, err := chatUnescape(tc.arg, true)
+		if (err != nil) != tc.bad || !tc.bad && (!reflect.DeepEqual(pieces, tc.pieces) || noCR != tc.noCR) {
+			t.Errorf("chatUnescape(%q) is %+v %v %v", tc.arg, pieces, noCR, err)
+		}
+	}
+
+	if _, err := chatExpectString(`ok\p`); err == nil {
+		t.Errorf("chatExpectString allowed \\p")
+	}
+	if parts := splitUnescaped(`ogin:-\-BREAK-ogin:`, '-'); !reflect.DeepEqual(parts, []string{"ogin:", `\-BREAK`, "ogin:"}) {
+		t.Errorf("splitUnescaped is %q", parts)
+	}
+}
+
+func Test_SplitChat(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	args, err := SplitChat(`ABORT 'NO CARRIER' "" ATZ
+	OK\r "say \"hi\"" it\'s`)
+	want := []string{"ABORT", "NO CARRIER", "", "ATZ", `OK\r`, `say "hi"`, "it's"}
+	if err != nil || !reflect.DeepEqual(args, want) {
+		t.Errorf("SplitChat is %q %v", args, err)
+	}
+	if args, err := SplitChat(`"" it's`); err == nil {
+		t.Errorf("SplitChat of an unterminated quote is %q", args)
+	}
+}
@@ -0,0 +1,69 @@
+/*
+File summary: Record a session with a program as Go expect code
+Package: main
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+// Command autoexpect runs a program for you to use as normal and writes a Go
+// function that repeats what you did, see the autoexpect package:
+//
+//	autoexpect [-o file] [-package name] [-func name] [-timeout d] [-p] prog [args ...]
+//
+// The recording ends when the program exits.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/leemcloughlin/expect/autoexpect"
+)
+
+func main() {
+	output := flag.String("o", "autoexpect.go", "the Go file to write")
+	pkg := flag.String("package", "main", "the package of the Go file")
+	funcName := flag.String("func", "Run", "the name of the function")
+	timeout := flag.Duration("timeout", 10*time.Second, "the timeout for each expect")
+	pauses := flag.Bool("p", false, "pause before each send as long as you did")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [options] prog [args ...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	fmt.Printf("autoexpect started, file is %s\r\n", *output)
+	rec, err := autoexpect.Record(context.Background(), os.Stdin, os.Stdout, flag.Arg(0), flag.Args()[1:]...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "autoexpect: %s\n", err)
+		if rec == nil {
+			os.Exit(1)
+		}
+	}
+
+	src, err := rec.GoSource(autoexpect.CodeOptions{
+		Package: *pkg,
+		Func:    *funcName,
+		Timeout: *timeout,
+		Pauses:  *pauses,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "autoexpect: %s\n", err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(*output, src, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "autoexpect: %s\n", err)
+		os.Exit(1)
+	}
+	steps, _ := rec.Steps()
+	fmt.Printf("autoexpect done, file is %s (%d steps)\n", *output, len(steps))
+}
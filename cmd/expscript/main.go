@@ -0,0 +1,59 @@
+/*
+File summary: Run Tcl expect (.exp) scripts without Tcl
+Package: main
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+// Command expscript runs Tcl expect scripts with the expscript package:
+//
+//	expscript [-c commands] script.exp [args ...]
+//
+// The exit code is that given to exit in the script, or 1 on an error.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/leemcloughlin/expect/expscript"
+)
+
+func main() {
+	commands := flag.String("c", "", "commands to run before the script")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [-c commands] script.exp [args ...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 && *commands == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	in := expscript.New()
+	code, err := 0, error(nil)
+	if *commands != "" {
+		if flag.NArg() == 0 {
+			code, err = in.Run(*commands)
+		} else if _, err = in.Eval(*commands); err != nil {
+			code = 1
+		}
+	}
+	if err == nil && flag.NArg() > 0 {
+		code, err = in.RunFile(flag.Arg(0), flag.Args()[1:])
+	}
+	if err != nil {
+		var scriptErr *expscript.Error
+		if errors.As(err, &scriptErr) {
+			fmt.Fprintln(os.Stderr, scriptErr.Info)
+		} else {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,191 @@
+/*
+File summary: Run YAML or JSON send/expect scenario files
+Package: main
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+// Command goexpect runs scenario files, see the scenario package:
+//
+//	goexpect [-v] [-var name=value ...] [-parallel n] [-junit file] [-json file]
+//		[-transcripts dir] scenario.yaml ...
+//
+// Each scenario is reported as PASS or FAIL as it ends. The output of a
+// failing scenario, or of every scenario with -v, is shown. Up to -parallel
+// scenarios are run at once. -junit and -json write reports of every step for
+// CI, which link to each scenario's output when -transcripts is given. The
+// exit code is 1 if any scenario fails and 2 if a file cannot be read or
+// written.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/leemcloughlin/expect/scenario"
+)
+
+// varFlags are -var name=value flags
+type varFlags scenario.Vars
+
+func (v varFlags) String() string {
+	return ""
+}
+
+func (v varFlags) Set(s string) error {
+	name, val, ok := strings.Cut(s, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("expected name=value")
+	}
+	v[name] = val
+	return nil
+}
+
+func main() {
+	vars := varFlags{}
+	verbose := flag.Bool("v", false, "show the output of every scenario")
+	flag.Var(vars, "var", "set a scenario var, overriding the file (repeatable)")
+	parallel := flag.Int("parallel", 1, "how many scenarios to run at once")
+	junitFile := flag.String("junit", "", "write a JUnit XML report to this file")
+	jsonFile := flag.String("json", "", "write a JSON report to this file")
+	transcripts := flag.String("transcripts", "", "save each scenario's output in this directory")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [options] scenario.yaml ...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var scenarios []*scenario.Scenario
+	for _, path := range flag.Args() {
+		s, err := scenario.Load(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		if s.Vars == nil {
+			s.Vars = scenario.Vars{}
+		}
+		for name, val := range vars {
+			s.Vars[name] = val
+		}
+		scenarios = append(scenarios, s)
+	}
+
+	if *transcripts != "" {
+		if err := os.MkdirAll(*transcripts, 0755); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	paths := transcriptPaths(*transcripts, scenarios)
+
+	failed, status := 0, 0
+	results := scenario.RunAll(context.Background(), scenarios, *parallel, func(r *scenario.Result) {
+		if path := paths[r.Name]; path != "" {
+			if err := r.SaveTranscript(path); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				status = 2
+			}
+		}
+		if r.Passed() {
+			fmt.Printf("PASS %s (%s)\n", r.Name, r.Duration.Round(time.Millisecond))
+		} else {
+			failed++
+			fmt.Printf("FAIL %s (%s): %s\n", r.Name, r.Duration.Round(time.Millisecond), r.Err)
+		}
+		if *verbose || !r.Passed() {
+			printOutput(r.Output)
+		}
+	})
+
+	for file, write := range map[string]func(io.Writer, []*scenario.Result) error{
+		*junitFile: scenario.WriteJUnit,
+		*jsonFile:  scenario.WriteJSON,
+	} {
+		if file == "" {
+			continue
+		}
+		if err := writeReport(file, results, write); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 2
+		}
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d scenarios failed\n", failed, len(scenarios))
+		if status == 0 {
+			status = 1
+		}
+	}
+	os.Exit(status)
+}
+
+var unsafeChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// transcriptPaths returns a transcript file in dir for each scenario by name,
+// or nothing if dir is "". Scenarios with the same name are renamed with a
+// number added so that their results and transcripts can be told apart.
+func transcriptPaths(dir string, scenarios []*scenario.Scenario) map[string]string {
+	names := map[string]bool{}
+	for _, s := range scenarios {
+		name := s.Name
+		for n := 2; names[name]; n++ {
+			name = fmt.Sprintf("%s#%d", s.Name, n)
+		}
+		s.Name = name
+		names[name] = true
+	}
+
+	paths := map[string]string{}
+	if dir == "" {
+		return paths
+	}
+	used := map[string]bool{}
+	for _, s := range scenarios {
+		base := strings.Trim(unsafeChars.ReplaceAllString(s.Name, "_"), "._")
+		if base == "" {
+			base = "scenario"
+		}
+		path := filepath.Join(dir, base+".log")
+		for n := 2; used[path]; n++ {
+			path = filepath.Join(dir, fmt.Sprintf("%s-%d.log", base, n))
+		}
+		used[path] = true
+		paths[s.Name] = path
+	}
+	return paths
+}
+
+// writeReport writes results to file with write
+func writeReport(file string, results []*scenario.Result, write func(io.Writer, []*scenario.Result) error) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := write(f, results); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// printOutput shows a scenario's output indented under its result
+func printOutput(output string) {
+	output = strings.ReplaceAll(output, "\r\n", "\n")
+	for _, line := range strings.Split(strings.TrimRight(output, "\n"), "\n") {
+		fmt.Printf("    | %s\n", line)
+	}
+}
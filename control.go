@@ -0,0 +1,135 @@
+/*
+File summary: Control keys and signals for the spawned process
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// Control is one of the terminal's special control characters. The actual
+// character is read from the pty's termios settings when it is sent.
+type Control int
+
+const (
+	// ControlIntr is VINTR which generates SIGINT, usually ^C
+	ControlIntr Control = iota
+
+	// ControlEOF is VEOF which ends input, usually ^D
+	ControlEOF
+
+	// ControlSusp is VSUSP which generates SIGTSTP, usually ^Z
+	ControlSusp
+
+	// ControlQuit is VQUIT which generates SIGQUIT, usually ^\
+	ControlQuit
+
+	// ControlErase is VERASE which rubs out the last character, usually ^?
+	ControlErase
+
+	// ControlKill is VKILL which rubs out the line, usually ^U
+	ControlKill
+
+	// ControlWerase is VWERASE which rubs out the last word, usually ^W
+	ControlWerase
+
+	// ControlStart is VSTART which restarts output, usually ^Q
+	ControlStart
+
+	// ControlStop is VSTOP which stops output, usually ^S
+	ControlStop
+
+	// numControls is the number of Controls
+	numControls
+)
+
+// String returns the termios name of the control character
+func (ctl Control) String() string {
+	switch ctl {
+	case ControlIntr:
+		return "VINTR"
+	case ControlEOF:
+		return "VEOF"
+	case ControlSusp:
+		return "VSUSP"
+	case ControlQuit:
+		return "VQUIT"
+	case ControlErase:
+		return "VERASE"
+	case ControlKill:
+		return "VKILL"
+	case ControlWerase:
+		return "VWERASE"
+	case ControlStart:
+		return "VSTART"
+	case ControlStop:
+		return "VSTOP"
+	}
+	return fmt.Sprintf("Control(%d)", int(ctl))
+}
+
+// ControlChar returns the character the pty currently uses for ctl.
+// An error is returned if the termios settings cannot be read or ctl has been
+// disabled.
+func (exp *Expect) ControlChar(ctl Control) (byte, error) {
+	t, err := exp.GetTermios()
+	if err != nil {
+		return 0, err
+	}
+	c := t.Char(ctl)
+	if c == 0 {
+		// _POSIX_VDISABLE
+		return 0, fmt.Errorf("expect: %s is disabled on the pty", ctl)
+	}
+	return c, nil
+}
+
+// SendControl sends the pty's character for ctl, as set in its termios, so
+// that the line discipline acts on it as if typed at a real terminal
+func (exp *Expect) SendControl(ctl Control) (int, error) {
+	c, err := exp.ControlChar(ctl)
+	if err != nil {
+		return 0, err
+	}
+	debugf("SendControl %s %q", ctl, c)
+	return exp.Write([]byte{c})
+}
+
+// SendEOF is a convenience wrapper around SendControl(ControlEOF). Note that
+// in canonical mode EOF only ends input at the start of a line.
+func (exp *Expect) SendEOF() (int, error) {
+	return exp.SendControl(ControlEOF)
+}
+
+// SendIntr is a convenience wrapper around SendControl(ControlIntr)
+func (exp *Expect) SendIntr() (int, error) {
+	return exp.SendControl(ControlIntr)
+}
+
+// Signal sends sig to the foreground process group of the pty, just as the
+// terminal does for ^C, so whatever is running in the foreground of a shell
+// gets it. If the foreground process group cannot be found the command itself
+// is signalled.
+func (exp *Expect) Signal(sig os.Signal) error {
+	if s, ok := sig.(syscall.Signal); ok {
+		if pgrp, err := foregroundPgrp(exp.File); err == nil && pgrp > 0 {
+			debugf("Signal %s to process group %d", sig, pgrp)
+			return syscall.Kill(-pgrp, s)
+		}
+	}
+	return exp.SignalProcess(sig)
+}
+
+// SignalProcess sends sig to the command only, not to anything it has started
+func (exp *Expect) SignalProcess(sig os.Signal) error {
+	debugf("Signal %s to process %d", sig, exp.cmd.Process.Pid)
+	return exp.cmd.Process.Signal(sig)
+}
@@ -0,0 +1,90 @@
+/*
+File summary: go test of control keys and signals
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+func checkExitSignal(t *testing.T, exp *Expect, expected syscall.Signal) {
+	select {
+	case <-exp.Done():
+	case <-time.After(5 * time.Second):
+		t.Errorf("process did not exit")
+		exp.Kill()
+		return
+	}
+	exp.Wait()
+	if sig, ok := exp.ExitSignal(); !ok || sig != expected {
+		t.Errorf("ExitSignal() is %v, %t not %s", sig, ok, expected)
+	} else {
+		t.Logf("process ended by %s", sig)
+	}
+}
+
+func Test_ControlChar(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	exp, err := NewExpect("cat")
+	if err != nil {
+		t.Errorf("NewExpect failed %s", err)
+		return
+	}
+	defer exp.Kill()
+
+	for ctl, expected := range map[Control]byte{
+		ControlIntr: 003,
+		ControlEOF:  004,
+		ControlSusp: 032,
+		ControlQuit: 034,
+	} {
+		c, err := exp.ControlChar(ctl)
+		if err != nil {
+			t.Errorf("ControlChar(%s) failed %s", ctl, err)
+		} else if c != expected {
+			t.Errorf("ControlChar(%s) is %q not %q", ctl, c, expected)
+		}
+	}
+}
+
+func Test_SendIntr(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	exp, err := NewExpect("cat")
+	if err != nil {
+		t.Errorf("NewExpect failed %s", err)
+		return
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := exp.SendIntr(); err != nil {
+		t.Errorf("SendIntr failed %s", err)
+	}
+	checkExitSignal(t, exp, syscall.SIGINT)
+}
+
+func Test_Signal(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	exp, err := NewExpect("sleep", "60")
+	if err != nil {
+		t.Errorf("NewExpect failed %s", err)
+		return
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := exp.Signal(syscall.SIGTERM); err != nil {
+		t.Errorf("Signal failed %s", err)
+	}
+	checkExitSignal(t, exp, syscall.SIGTERM)
+}
@@ -0,0 +1,163 @@
+/*
+File summary: Sending that waits for and consumes the terminal echo
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"time"
+	"unicode/utf8"
+)
+
+// SetEcho turns the pty's ECHO flag on or off. With it off the process does
+// not see its input echoed back and nor does Expect().
+// Note that some programs, such as shells using readline, set their own
+// terminal modes and will do their own echoing regardless.
+func (exp *Expect) SetEcho(on bool) error {
+	return exp.SetTermFlag(TermEcho, on)
+}
+
+// SendAndConsumeEcho sends s and then waits until the terminal has echoed it
+// back, removing the echo from Buffer. Only input that arrives after s is
+// sent is looked at, so an earlier copy of s in Buffer is not taken for the
+// echo. Any other input is left in Buffer. What is echoed is worked out from the pty's
+// current termios settings, so for example with the usual ICRNL and ONLCR
+// flags a "\r" is echoed as "\r\n". If ECHO is off it returns as soon as s is
+// sent.
+// The timeout set by SetTimeout() applies to the wait and a *TimeoutError is
+// returned if the echo never arrives.
+// Note: the return is the number of bytes sent
+func (exp *Expect) SendAndConsumeEcho(s string) (int, error) {
+	// Work out the echo before sending in case the process changes the
+	// termios as soon as it reads the input
+	t, err := exp.GetTermios()
+	if err != nil {
+		return 0, err
+	}
+	echo := expectedEcho(t, []byte(s))
+
+	// Set aside what is already buffered while waiting for the echo and put it
+	// back in front of whatever is left afterwards
+	exp.lockRead()
+	defer exp.unlockRead()
+	context := exp.bufferContext
+	held := exp.takeBuffer()
+	if len(held) > 0 {
+		_, size := utf8.DecodeLastRune(held)
+		exp.bufferContext = held[len(held)-size:]
+	}
+	defer func() {
+		rest := exp.takeBuffer()
+		exp.Buffer.Write(held)
+		exp.Buffer.Write(rest)
+		exp.bufferContext = context
+	}()
+
+	sent, err := exp.Send(s)
+	if err != nil || len(echo) == 0 {
+		return sent, err
+	}
+
+	debugf("SendAndConsumeEcho waiting for %q", echo)
+	started := time.Now()
+	n, _, _, err := exp.expectLocked(nil, []interface{}{string(echo)}, true)
+	if n == 0 {
+		return sent, nil
+	}
+	if err == nil {
+		err = &EOFError{
+			Patterns: []interface{}{string(echo)},
+			Elapsed:  time.Since(started),
+			Tail:     errorTail(exp.Buffer.Bytes()),
+		}
+	}
+	return sent, err
+}
+
+// SendLine sends s followed by "\r", as if typed and followed by Enter, and
+// consumes the echo as SendAndConsumeEcho() does
+func (exp *Expect) SendLine(s string) (int, error) {
+	return exp.SendAndConsumeEcho(s + "\r")
+}
+
+// expectedEcho works out what a line discipline with the settings t will
+// echo when sent is written to it. It follows the Linux n_tty rules for the
+// common cases: ICRNL, INLCR and IGNCR on input, ECHOCTL for control
+// characters, ECHOE for the erase character in canonical mode and ONLCR on
+// output. Other line editing, such as the kill character, is not modelled.
+func expectedEcho(t *Termios, sent []byte) []byte {
+	if !t.Flag(TermEcho) {
+		return nil
+	}
+	canon := t.Flag(TermIcanon)
+	isig := t.Flag(TermIsig)
+	echoctl := t.Flag(TermEchoCtl)
+	onlcr := t.Flag(TermOpost) && t.Flag(TermOnlcr)
+
+	var echo []byte
+	// lineLen is the width of each echoed character on the current line so
+	// erase knows how much to rub out
+	var lineLen []int
+	for _, c := range sent {
+		switch {
+		case c == '\r' && t.Flag(TermIgncr):
+			continue
+		case c == '\r' && t.Flag(TermIcrnl):
+			c = '\n'
+		case c == '\n' && t.Flag(TermInlcr):
+			c = '\r'
+		}
+
+		special := func(ctl Control) bool {
+			return t.Char(ctl) != 0 && c == t.Char(ctl)
+		}
+		switch {
+		case canon && special(ControlEOF):
+			// EOF is never echoed
+			continue
+		case canon && special(ControlErase):
+			if len(lineLen) > 0 {
+				width := lineLen[len(lineLen)-1]
+				lineLen = lineLen[:len(lineLen)-1]
+				if t.Flag(TermEchoE) {
+					for i := 0; i < width; i++ {
+						echo = append(echo, '\b', ' ', '\b')
+					}
+				} else {
+					echo = append(echo, c)
+				}
+			}
+			continue
+		case isig && (special(ControlIntr) || special(ControlQuit) || special(ControlSusp)):
+			if echoctl {
+				echo = append(echo, '^', c^0x40)
+			}
+			lineLen = lineLen[:0]
+			continue
+		}
+
+		switch {
+		case c == '\n':
+			if onlcr {
+				echo = append(echo, '\r')
+			}
+			echo = append(echo, '\n')
+			lineLen = lineLen[:0]
+		case (c < 0x20 || c == 0x7f) && c != '\t' && echoctl:
+			echo = append(echo, '^', c^0x40)
+			lineLen = append(lineLen, 2)
+		default:
+			echo = append(echo, c)
+			// Count the start of each rune once
+			if c&0xc0 != 0x80 {
+				lineLen = append(lineLen, 1)
+			}
+		}
+	}
+	return echo
+}
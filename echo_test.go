@@ -0,0 +1,151 @@
+/*
+File summary: go test of echo-aware sending
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_ExpectedEcho(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	exp, err := NewExpect("cat")
+	if err != nil {
+		t.Errorf("NewExpect failed %s", err)
+		return
+	}
+	defer exp.Kill()
+
+	tests := []struct {
+		sent     string
+		expected string
+	}{
+		{"hello\r", "hello\r\n"},
+		{"a\nb\r", "a\r\nb\r\n"},
+		{"HELLX\x7fO\r", "HELLX\b \bO\r\n"},
+		{"\x01\t\x04", "^A\t"},
+		{"世\x7f", "世\b \b"},
+	}
+	termios, err := exp.GetTermios()
+	if err != nil {
+		t.Errorf("GetTermios failed %s", err)
+		return
+	}
+	for _, test := range tests {
+		echo := expectedEcho(termios, []byte(test.sent))
+		if string(echo) != test.expected {
+			t.Errorf("echo of %q is %q not %q", test.sent, echo, test.expected)
+		}
+	}
+}
+
+func Test_SendLine(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	exp, err := NewExpect("rev")
+	if err != nil {
+		t.Errorf("NewExpect failed %s", err)
+		return
+	}
+	exp.SetTimeoutSecs(5) // Shouldn't happen
+
+	if _, err := exp.SendLine("hello"); err != nil {
+		t.Errorf("SendLine failed %s", err)
+	}
+	n, found, err := exp.Expect("hello", "olleh")
+	checkResultStr(t, "olleh", 1, n, found, err)
+
+	exp.SendEOF()
+	showWaitResult(t, exp)
+}
+
+func Test_SendAndConsumeEchoKeepsOutput(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	t.Logf("starting %s", prog)
+	exp, err := NewExpect(prog)
+	if err != nil {
+		t.Errorf("NewExpect failed %s", err)
+		return
+	}
+	exp.SetTimeoutSecs(5) // Shouldn't happen
+	exp.Expect("Args passed:")
+
+	t.Log("the prompt arrives before the echo and must be left in Buffer")
+	if _, err := exp.SendAndConsumeEcho("1\r"); err != nil {
+		t.Errorf("SendAndConsumeEcho failed %s", err)
+	}
+	pat := "Enter test name: "
+	n, found, err := exp.Expect(pat)
+	checkResultStr(t, pat, 0, n, found, err)
+	if n, _, _ := exp.Expect("Welcome"); n != 0 {
+		t.Errorf("did not find the output after the echo")
+	}
+	if strings.Contains(exp.BufStr(), "1\r\n") {
+		t.Errorf("echo still in buffer <<%s>>", exp.BufStr())
+	}
+
+	exp.SendEOF()
+	showWaitResult(t, exp)
+}
+
+func Test_SendAndConsumeEchoIgnoresEarlierCopy(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	exp, err := NewExpect("cat")
+	if err != nil {
+		t.Errorf("NewExpect failed %s", err)
+		return
+	}
+	exp.SetTimeoutSecs(5) // Shouldn't happen
+	defer exp.Kill()
+
+	t.Log("an earlier copy of the text in Buffer is not taken for the echo")
+	earlier := "earlier abc\r\n"
+	exp.Buffer.WriteString(earlier)
+	if _, err := exp.SendAndConsumeEcho("abc\r"); err != nil {
+		t.Errorf("SendAndConsumeEcho failed %s", err)
+	}
+	if buf := exp.BufStr(); !strings.HasPrefix(buf, earlier) {
+		t.Errorf("buffer is <<%s>> not <<%s...>>", buf, earlier)
+	}
+	pat := earlier + "abc\r\n"
+	n, found, err := exp.Expect(pat)
+	checkResultStr(t, pat, 0, n, found, err)
+}
+
+func Test_SetEcho(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	exp, err := NewExpect("rev")
+	if err != nil {
+		t.Errorf("NewExpect failed %s", err)
+		return
+	}
+	exp.SetTimeoutSecs(5) // Shouldn't happen
+
+	if err := exp.SetEcho(false); err != nil {
+		t.Errorf("SetEcho failed %s", err)
+		return
+	}
+	exp.Send("hello\r")
+	n, _, err := exp.Expect("hello", "olleh")
+	if n != 1 {
+		t.Errorf("expected only olleh got %d: %s", n, err)
+	}
+	exp.SendEOF()
+	showWaitResult(t, exp)
+}
@@ -0,0 +1,175 @@
+/*
+File summary: Typed errors returned by Expect
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrorTailSize is the maximum number of bytes from the end of Buffer that
+// are saved in a TimeoutError, EOFError or ReadError
+var ErrorTailSize = 256
+
+// TimeoutError is returned by Expect() when the timeout expires before any
+// pattern matches. errors.Is(err, ETimedOut) is true for a TimeoutError.
+type TimeoutError struct {
+	// Patterns are the patterns Expect() was waiting for
+	Patterns []interface{}
+
+	// Elapsed is how long Expect() waited
+	Elapsed time.Duration
+
+	// Tail is the end of Buffer when the timeout happened
+	Tail []byte
+}
+
+func (e *TimeoutError) Error() string {
+	return describeWait("timed out", e.Patterns, e.Elapsed, e.Tail)
+}
+
+// Is reports whether target is ETimedOut
+func (e *TimeoutError) Is(target error) bool {
+	return target == ETimedOut
+}
+
+// EOFError is used when the process closes its output before a pattern that
+// was required to match does so. Expect() itself does not return it as EOF is
+// not considered an error there. errors.Is(err, io.EOF) is true for an EOFError.
+type EOFError struct {
+	// Patterns are the patterns that were being waited for
+	Patterns []interface{}
+
+	// Elapsed is how long was spent waiting
+	Elapsed time.Duration
+
+	// Tail is the end of Buffer when EOF was read
+	Tail []byte
+}
+
+func (e *EOFError) Error() string {
+	return describeWait("eof", e.Patterns, e.Elapsed, e.Tail)
+}
+
+// Is reports whether target is io.EOF
+func (e *EOFError) Is(target error) bool {
+	return target == io.EOF
+}
+
+// ReadError is returned by Expect() when reading from the pty fails for any
+// reason other than the process closing it. errors.Is(err, EReadError) is true
+// for a ReadError and Err, the underlying error, is available to errors.Is and
+// errors.As through Unwrap.
+type ReadError struct {
+	// Patterns are the patterns Expect() was waiting for
+	Patterns []interface{}
+
+	// Elapsed is how long Expect() waited
+	Elapsed time.Duration
+
+	// Tail is the end of Buffer when the read failed
+	Tail []byte
+
+	// Err is the error from the read, often an *os.PathError holding a
+	// syscall.Errno
+	Err error
+}
+
+func (e *ReadError) Error() string {
+	return describeWait(fmt.Sprintf("read error: %s", e.Err), e.Patterns, e.Elapsed, e.Tail)
+}
+
+// Is reports whether target is EReadError
+func (e *ReadError) Is(target error) bool {
+	return target == EReadError
+}
+
+// Unwrap returns the underlying read error
+func (e *ReadError) Unwrap() error {
+	return e.Err
+}
+
+// Errno returns the errno of the underlying read error or 0 if there is none
+func (e *ReadError) Errno() syscall.Errno {
+	var errno syscall.Errno
+	if errors.As(e.Err, &errno) {
+		return errno
+	}
+	return 0
+}
+
+// PatternError is returned by Expect() when passed something that is not a
+// string, *regexp.Regexp or Pseudo.
+// errors.Is(err, ENotStringOrRexgexp) is true for a PatternError.
+type PatternError struct {
+	// Index is the position of the bad argument
+	Index int
+
+	// Pattern is the bad argument
+	Pattern interface{}
+}
+
+func (e *PatternError) Error() string {
+	return fmt.Sprintf("expect: argument %d is %T: %s", e.Index, e.Pattern, ENotStringOrRexgexp)
+}
+
+// Is reports whether target is ENotStringOrRexgexp
+func (e *PatternError) Is(target error) bool {
+	return target == ENotStringOrRexgexp
+}
+
+// errorTail returns a copy of at most the last ErrorTailSize bytes of buf
+func errorTail(buf []byte) []byte {
+	if ErrorTailSize >= 0 && len(buf) > ErrorTailSize {
+		buf = buf[len(buf)-ErrorTailSize:]
+	}
+	tail := make([]byte, len(buf))
+	copy(tail, buf)
+	return tail
+}
+
+// describeWait builds the multi-line diagnostic shared by the errors above
+func describeWait(what string, patterns []interface{}, elapsed time.Duration, tail []byte) string {
+	var s strings.Builder
+	fmt.Fprintf(&s, "expect: %s after %s waiting for %s", what, elapsed.Round(time.Millisecond), describePatterns(patterns))
+	fmt.Fprintf(&s, "\nbuffer tail (%d bytes): %q", len(tail), tail)
+	return s.String()
+}
+
+// describePatterns lists patterns in a readable form such as:
+// "login:", /pass(word)?:/, EndOfFile
+func describePatterns(patterns []interface{}) string {
+	if len(patterns) == 0 {
+		return "nothing"
+	}
+	strs := make([]string, len(patterns))
+	for n, pattern := range patterns {
+		strs[n] = describePattern(pattern)
+	}
+	return strings.Join(strs, ", ")
+}
+
+// describePattern is a readable form of a single pattern
+func describePattern(pattern interface{}) string {
+	switch p := pattern.(type) {
+	case string:
+		return fmt.Sprintf("%q", p)
+	case *regexp.Regexp:
+		return "/" + p.String() + "/"
+	case Pseudo:
+		return p.String()
+	}
+	return fmt.Sprintf("%v", pattern)
+}
@@ -0,0 +1,87 @@
+/*
+File summary: go test of the typed errors
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"errors"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func Test_TimeoutError(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	var err error = &TimeoutError{
+		Patterns: []interface{}{"login:", regexp.MustCompile("pass(word)?:"), EndOfFile},
+		Elapsed:  5 * time.Second,
+		Tail:     []byte("Welcome\r\n"),
+	}
+	if !errors.Is(err, ETimedOut) {
+		t.Errorf("errors.Is(%T, ETimedOut) is false", err)
+	}
+	var te *TimeoutError
+	if !errors.As(err, &te) {
+		t.Errorf("errors.As(%T, *TimeoutError) is false", err)
+	}
+	expected := `expect: timed out after 5s waiting for "login:", /pass(word)?:/, EndOfFile` +
+		"\n" + `buffer tail (9 bytes): "Welcome\r\n"`
+	if err.Error() != expected {
+		t.Errorf("got <<%s>> not <<%s>>", err, expected)
+	} else {
+		t.Logf("diagnostic:\n%s", err)
+	}
+}
+
+func Test_ReadError(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	var err error = &ReadError{
+		Patterns: []interface{}{"login:"},
+		Err:      &os.PathError{Op: "read", Path: "/dev/ptmx", Err: syscall.EBADF},
+	}
+	if !errors.Is(err, EReadError) {
+		t.Errorf("errors.Is(%T, EReadError) is false", err)
+	}
+	if !errors.Is(err, syscall.EBADF) {
+		t.Errorf("errors.Is(%T, EBADF) is false", err)
+	}
+	if errno := err.(*ReadError).Errno(); errno != syscall.EBADF {
+		t.Errorf("Errno() is %d not EBADF", errno)
+	}
+	if !strings.Contains(err.Error(), "bad file descriptor") {
+		t.Errorf("diagnostic <<%s>> does not include the errno", err)
+	}
+}
+
+func Test_EOFAndPatternError(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	if err := error(&EOFError{}); !errors.Is(err, io.EOF) {
+		t.Errorf("errors.Is(%T, io.EOF) is false", err)
+	}
+
+	exp := &Expect{}
+	n, _, err := exp.Expect("ok", 42)
+	if n != NotStringOrRexgexp || !errors.Is(err, ENotStringOrRexgexp) {
+		t.Errorf("expected NotStringOrRexgexp got %d: %s", n, err)
+	}
+	var pe *PatternError
+	if !errors.As(err, &pe) || pe.Index != 1 {
+		t.Errorf("expected a PatternError for argument 1 got %#v", err)
+	}
+}
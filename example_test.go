@@ -30,7 +30,7 @@ func ExampleExpect() {
 	} else {
 		fmt.Println("failed ", err)
 	}
-	exp.Send(EOF)
+	exp.SendEOF()
 	// Output:
 	// found olleh
 }
@@ -106,7 +106,7 @@ func ExampleExpect_ownEcho() {
 		fmt.Println("failed ", err)
 	}
 
-	exp.Send(EOF)
+	exp.SendEOF()
 
 	i, _, _ = exp.Expect()
 	if i == NotFound {
@@ -117,3 +117,24 @@ func ExampleExpect_ownEcho() {
 	// found olleh
 	// found EOF
 }
+
+func ExampleExpect_SendLine() {
+	// Run rev, send it hello using SendLine which consumes the echo of
+	// hello so the first thing Expect sees is olleh
+	exp, err := NewExpect("rev")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "NewExpect failed %s", err)
+	}
+	exp.SetTimeoutSecs(5) // Shouldn't happen
+	exp.SendLine("hello")
+
+	i, found, err := exp.Expect("hello", "olleh")
+	if i == 1 {
+		fmt.Println("found", string(found))
+	} else {
+		fmt.Println("failed ", err)
+	}
+	exp.SendEOF()
+	// Output:
+	// found olleh
+}
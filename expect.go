@@ -29,7 +29,7 @@ line is:
 	} else {
 		fmt.Println("failed ", err)
 	}
-	exp.Send(EOF)
+	exp.SendEOF()
 
 This package has only been tested on Linux
 */
@@ -37,6 +37,7 @@ package expect
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -44,9 +45,11 @@ import (
 	"os"
 	"os/exec"
 	"regexp"
-	"runtime"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
+	"unicode/utf8"
 
 	"github.com/kr/pty"
 )
@@ -60,12 +63,70 @@ const (
 
 	// NotStringOrRexgexp is returned if a paramter is not a string or a regexp
 	NotStringOrRexgexp = -3
+
+	// Cancelled is returned by ExpectContext when the context is done (along
+	// with the context's error)
+	Cancelled = -4
+)
+
+// Pseudo is the type of the pseudo-patterns that can be passed to Expect()
+// along with strings and regexps. They match events rather than input.
+type Pseudo int
+
+const (
+	// FullBuffer matches when no other pattern matches and Expect would
+	// otherwise throw away the oldest input to keep Buffer within MatchMax.
+	// The found bytes are the discarded input. This is Tcl expect's full_buffer
+	// except that, as SetMatchMax() describes, ^ and \A then no longer match
+	// at the start of Buffer
+	FullBuffer Pseudo = iota + 1
+
+	// EndOfFile matches when the process closes its output. The found bytes
+	// are whatever was left in Buffer, which is then emptied. This is Tcl
+	// expect's eof
+	EndOfFile
+
+	// Timeout matches when the timeout set by SetTimeout() expires. The found
+	// bytes are a copy of Buffer which is left untouched so a later Expect()
+	// can still match it. This is Tcl expect's timeout
+	Timeout
 )
 
+// String returns the name of the pseudo-pattern
+func (p Pseudo) String() string {
+	switch p {
+	case FullBuffer:
+		return "FullBuffer"
+	case EndOfFile:
+		return "EndOfFile"
+	case Timeout:
+		return "Timeout"
+	}
+	return fmt.Sprintf("Pseudo(%d)", int(p))
+}
+
+// ExpContinue can be returned by a Case Handler to get ExpectCase() to carry
+// on waiting for a match, like Tcl expect's exp_continue
+var ExpContinue = errors.New("exp_continue")
+
+// Case is a pattern and the func to call when it matches. See ExpectCase()
+type Case struct {
+	// Pattern is a string, *regexp.Regexp or Pseudo, as passed to Expect()
+	Pattern interface{}
+
+	// Handler, if not nil, is called with the bytes found by Pattern
+	Handler func(found []byte) error
+}
+
 var (
-	ETimedOut           = errors.New("TimedOut")
+	// ETimedOut matches a *TimeoutError with errors.Is
+	ETimedOut = errors.New("TimedOut")
+
+	// ENotStringOrRexgexp matches a *PatternError with errors.Is
 	ENotStringOrRexgexp = errors.New("Not string or regexp")
-	EReadError          = errors.New("Read Error")
+
+	// EReadError matches a *ReadError with errors.Is
+	EReadError = errors.New("Read Error")
 
 	// Debug if true will generate vast amounts of internal logging
 	Debug = false
@@ -73,32 +134,30 @@ var (
 	// ExpectInSize is the size of the channel between the expectReader and Expect.
 	// If you overflow this than expectReader will block.
 	ExpectInSize = 20 * 1024
-
-	// EOF is the terminal EOF character - NOT guaranteed to be correct on all
-	// systems nor if the program passed to expect connects to a different
-	// platform
-	EOF = "\004"
 )
 
-func init() {
-	// No good way to discover EOF so have to take a best guess
-	// Default (above) to control-D
-	if runtime.GOOS == "windows" {
-		EOF = "\032" // control-Z
-	}
-}
-
 // Remember: Expect.Close() will not end the process
-// you have to send it an EOF
+// you have to send it an EOF, with SendEOF(), or call Shutdown()
 
 type Expect struct {
 	// *os.File is an anonymous field for the pty connected to the command.
 	// This allows you to treat *Expect as a *os.File
 	*os.File
 
-	cmd    *exec.Cmd
-	cmdIn  io.Reader // Internally I always read from cmdIn not File so cmdOut works
-	cmdOut io.Writer
+	cmd *exec.Cmd
+
+	// outputs are the writers sent a copy of the output, changed under
+	// outputMu. cmdOut is the one set by SetCmdOut(). outputEnded is set
+	// once the expectReader has ended.
+	outputMu    sync.Mutex
+	outputs     atomic.Pointer[[]*outputWriter]
+	cmdOut      io.Writer
+	outputEnded bool
+
+	// sendHooks and receiveHooks are added by OnSend() and OnReceive()
+	hooksMu      sync.Mutex
+	sendHooks    []*hook
+	receiveHooks []*hook
 
 	timeout time.Duration
 
@@ -110,17 +169,85 @@ type Expect struct {
 	// expertReader reads from Cmd and sends to Expect over this Chan
 	bytesIn chan byteIn
 
-	// Send true to this chan to get the expectReader goroutine to end
-	endExpectReader chan bool
+	// matchMax is the maximum size of Buffer, zero is unlimited.
+	// See SetMatchMax()
+	matchMax int
+
+	// fullBufferHandler is called with any input discarded to keep Buffer
+	// within matchMax
+	fullBufferHandler func(discarded []byte)
+
+	// bufferContext is the last rune of the input discarded from the front
+	// of Buffer, or nil if Buffer starts where the last match ended
+	bufferContext []byte
+
+	// Close this chan, with stopReader(), to get the expectReader goroutine
+	// to end
+	endExpectReader chan struct{}
+	endOnce         sync.Once
+
+	// expectReader closes this when it ends
+	readerDone chan struct{}
+
+	// shutdownGrace is how long Shutdown() waits at each step
+	shutdownGrace time.Duration
+
+	// keyModes tracks the terminal modes that change what SendKeys() sends
+	keyModes keyModes
+
+	// shellMarks, if set by EnableShellMarks(), parses OSC 133 marks
+	shellMarks atomic.Pointer[ShellMarks]
+
+	// termResponder, if set by SetTermResponder(), answers terminal queries
+	// found by termQueries
+	termResponder atomic.Pointer[TermResponder]
+	termQueries   termQueries
+
+	// subs are the Chunks() subscribers, changed under subscribersMu.
+	// subscribersEnded is set once the expectReader has ended.
+	subscribersMu    sync.Mutex
+	subs             atomic.Pointer[[]*subscriber]
+	subscribersEnded bool
 
-	// Is the expectReader running?
-	expectReaderRunning bool
+	// readLock is held by whatever is reading bytesIn and using Buffer,
+	// Expect(), Interact() or a Background. wantRead asks a Background to
+	// give it up. See lockRead()
+	readLock chan struct{}
+	wantRead chan struct{}
+
+	// backgrounds are the running ExpectBackground()s. backgroundEOF is set
+	// once the end of the output has been read.
+	backgroundMu  sync.Mutex
+	backgrounds   []*Background
+	backgroundEOF bool
+
+	// bufferEdits counts the changes to Buffer other than adding to its
+	// end, so a Background knows when its matcher has to search all of it
+	bufferEdits int
 
 	// On EOF being read from Cmd this is set (and ExpectReader is ended)
 	Eof bool
 
-	// Result is filled in asynchronously after the cmd exits
+	// Result is filled in asynchronously after the cmd exits. It is only
+	// safe to read once Done() is closed or Wait() has returned.
 	Result ExpectWaitResult
+
+	// reap is true if NewExpect started the expectReaper goroutine
+	reap bool
+
+	// done is closed when the cmd has exited. See Done()
+	done chan struct{}
+
+	// waited is closed once Result has been filled in
+	waited chan struct{}
+
+	// waitOnce makes sure the cmd is only reaped once
+	waitOnce sync.Once
+
+	// reaped is set, under reapMu, once the cmd has been reaped by Wait()
+	// or expectReaper. reapMu is held while signalling its session.
+	reapMu sync.Mutex
+	reaped bool
 }
 
 type ExpectWaitResult struct {
@@ -144,9 +271,10 @@ func debugf(format string, args ...interface{}) {
 // Note that in order to be non-blocking while reading from the pty this sets
 // the non-blocking flag and looks for EAGAIN on reads failing.  This has only
 // been tested on Linux systems.
-// On prog exiting or being killed Result is filled in shortly after.
+// On prog exiting or being killed Result is filled in shortly after, see
+// Wait() and Done().
 func NewExpect(prog string, arg ...string) (*Expect, error) {
-	return newExpectCommon(true, prog, arg...)
+	return newExpectCommon(true, exec.Command(prog, arg...))
 }
 
 // NewExpectProc is similar to NewExpect except the created cmd is returned.
@@ -155,32 +283,45 @@ func NewExpect(prog string, arg ...string) (*Expect, error) {
 // In the event of an error starting the cmd it will be killed but not reaped.
 // However the cmd ends it is important that the caller reap the process
 // by calling cmd.Process.Wait() otherwise it can use up a process slot in
-// the operating system.
-// Note that Result is not filled in.
+// the operating system. Alternatively call Wait() which reaps the cmd and
+// fills in Result but do not do both.
+// Done() works as for NewExpect but Result is not filled in until Wait() is
+// called.
 func NewExpectProc(prog string, arg ...string) (*Expect, *exec.Cmd, error) {
-	exp, err := newExpectCommon(false, prog, arg...)
+	exp, err := newExpectCommon(false, exec.Command(prog, arg...))
+	if err != nil {
+		return nil, nil, err
+	}
 	return exp, exp.cmd, err
 }
 
-func newExpectCommon(reap bool, prog string, arg ...string) (*Expect, error) {
-	name := "NewExpect"
+// newExpectCommon starts cmd, which must not have been started, in a pty
+func newExpectCommon(reap bool, cmd *exec.Cmd) (*Expect, error) {
+	name := "NewExpectProc"
 	if reap {
-		name = "MewExpectProc"
+		name = "NewExpect"
 	}
 
 	var err error
 	exp := new(Expect)
-	exp.cmd = exec.Command(prog, arg...)
+	exp.cmd = cmd
 	exp.File, err = pty.Start(exp.cmd)
 
-	if reap && exp.cmd.Process != nil {
-		go exp.expectReaper()
+	exp.reap = reap
+	exp.done = make(chan struct{})
+	exp.waited = make(chan struct{})
+	if exp.cmd.Process != nil {
+		if reap {
+			go exp.waitOnce.Do(exp.expectReaper)
+		} else {
+			go exp.expectExitWatcher()
+		}
 	}
 
 	if err != nil {
 		if exp.cmd.Process != nil {
 			if err2 := exp.cmd.Process.Kill(); err2 != nil {
-				debugf("%s cannot kill %s on error: %s", name, prog, err)
+				debugf("%s cannot kill %s on error: %s", name, cmd.Path, err)
 			}
 		}
 		return nil, err
@@ -191,41 +332,127 @@ func newExpectCommon(reap bool, prog string, arg ...string) (*Expect, error) {
 	err = syscall.SetNonblock(fd, true)
 	if err != nil {
 		if err2 := exp.cmd.Process.Kill(); err2 != nil {
-			debugf("%s cannot kill %s on error: %s", name, prog, err)
+			debugf("%s cannot kill %s on error: %s", name, cmd.Path, err)
 		}
 		return nil, err
 	}
 
-	exp.SetCmdOut(nil)
-
 	exp.Buffer = new(bytes.Buffer)
 
 	exp.bytesIn = make(chan byteIn, ExpectInSize)
-	exp.endExpectReader = make(chan bool, 2) // should be 1 but just in case have extra space
-	exp.expectReaderRunning = true
+	exp.endExpectReader = make(chan struct{})
+	exp.readerDone = make(chan struct{})
+	exp.readLock = make(chan struct{}, 1)
+	exp.wantRead = make(chan struct{}, 1)
+	exp.shutdownGrace = DefaultShutdownGrace
+	exp.SetTermResponder(InitialTermResponder)
 	go exp.expectReader()
 
 	return exp, err
 }
 
 // expectReaper reaps the process if it ends for any reason and saves the
-// Wait() result
+// Wait() result. It must only be called through waitOnce.
 func (exp *Expect) expectReaper() {
-	exp.Result.ProcessState, exp.Result.Error = exp.cmd.Process.Wait()
+	// Wait for the exit without reaping so that reapMu is only held while
+	// reaping, which is then immediate
+	exited := waitExited(exp.cmd.Process.Pid) == nil
+	if exited {
+		exp.reapMu.Lock()
+	}
+	state, err := exp.cmd.Process.Wait()
+	if !exited {
+		exp.reapMu.Lock()
+	}
+	exp.reaped = true
+	exp.reapMu.Unlock()
+	exp.Result.ProcessState, exp.Result.Error = state, err
 	exp.Result.IsValid = true
+	close(exp.waited)
+	if exp.reap {
+		close(exp.done)
+	}
+}
+
+// expectExitWatcher closes done when the process exits without reaping it so
+// the caller of NewExpectProc can still do so
+func (exp *Expect) expectExitWatcher() {
+	if err := waitExited(exp.cmd.Process.Pid); err != nil {
+		debugf("expectExitWatcher cannot wait for %d: %s", exp.cmd.Process.Pid, err)
+		// Fall back to waiting for Wait() to be called
+		<-exp.waited
+	}
+	close(exp.done)
+}
+
+// Done returns a channel that is closed when the cmd exits. For NewExpect
+// Result is filled in before Done is closed.
+func (exp *Expect) Done() <-chan struct{} {
+	return exp.done
+}
+
+// Wait waits for the cmd to exit and returns its state, as saved in Result.
+// It is safe to call Wait more than once and from more than one goroutine.
+// For NewExpectProc the first call to Wait reaps the cmd.
+func (exp *Expect) Wait() (*os.ProcessState, error) {
+	if !exp.reap {
+		exp.waitOnce.Do(exp.expectReaper)
+	}
+	<-exp.waited
+	return exp.Result.ProcessState, exp.Result.Error
+}
+
+// Exited returns true if the cmd has exited and has been reaped, after which
+// ExitCode() and ExitSignal() are valid
+func (exp *Expect) Exited() bool {
+	select {
+	case <-exp.waited:
+		return exp.Result.ProcessState != nil
+	default:
+		return false
+	}
+}
+
+// ExitCode returns the exit code of the cmd or -1 if it has not exited, has
+// not been reaped or was killed by a signal
+func (exp *Expect) ExitCode() int {
+	if !exp.Exited() {
+		return -1
+	}
+	return exp.Result.ProcessState.ExitCode()
+}
+
+// ExitSignal returns the signal that killed the cmd and true or, if the cmd
+// has not exited, has not been reaped or was not killed by a signal, false
+func (exp *Expect) ExitSignal() (os.Signal, bool) {
+	if !exp.Exited() {
+		return nil, false
+	}
+	status, ok := exp.Result.ProcessState.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return nil, false
+	}
+	return status.Signal(), true
 }
 
 // SetCmdOut if a non-nil io.Writer is passed it will be sent a copy of everything
-// read by Expect() from the pty.
+// read from the pty, replacing the writer passed before. Passing nil removes it.
+// The writer uses Backpressure and is one of those added by AddOutputWriter()
+// which are not changed.
 // Note that if you bypass expect and read directly from the *Expect this is
 // will not be used
 func (exp *Expect) SetCmdOut(cmdOut io.Writer) {
+	exp.outputMu.Lock()
+	old := exp.cmdOut
+	exp.outputMu.Unlock()
+	if old != nil {
+		exp.RemoveOutputWriter(old)
+	}
 	if cmdOut != nil {
+		exp.AddOutputWriter(cmdOut, nil)
+		exp.outputMu.Lock()
 		exp.cmdOut = cmdOut
-		exp.cmdIn = io.TeeReader(exp.File, cmdOut)
-	} else {
-		exp.cmdIn = exp.File
-		exp.cmdOut = nil
+		exp.outputMu.Unlock()
 	}
 }
 
@@ -240,14 +467,75 @@ func (exp *Expect) SetTimeoutSecs(timeout int) {
 	exp.SetTimeout(time.Duration(timeout) * time.Second)
 }
 
+// SetMatchMax sets the maximum number of bytes Expect() will hold in Buffer
+// while waiting for a match, like Tcl expect's match_max.
+// When more input arrives the oldest half of Buffer is discarded, so Buffer
+// is full again only after another max/2 bytes. Patterns are always checked
+// before anything is discarded so a match of up to max/2 bytes is never lost
+// because it straddles the discard point.
+// Unlike Tcl expect a regexp still sees the discarded input as being there.
+// Once anything has been discarded ^ and \A never match at the start of
+// Buffer, and neither does (?m)^ unless the discarded input ended with a
+// newline. To get the input before a match use ExpectBefore() rather than a
+// regexp anchored to the start of Buffer.
+// The default value is zero which lets Buffer grow without limit.
+func (exp *Expect) SetMatchMax(max int) {
+	if max < 0 {
+		max = 0
+	}
+	exp.matchMax = max
+}
+
+// MatchMax returns the maximum size of Buffer set by SetMatchMax()
+func (exp *Expect) MatchMax() int {
+	return exp.matchMax
+}
+
+// SetFullBufferHandler sets a func that is called with the input discarded
+// whenever Buffer grows beyond MatchMax. It is called before Expect() returns
+// for a FullBuffer pattern. Pass nil to remove the handler.
+func (exp *Expect) SetFullBufferHandler(handler func(discarded []byte)) {
+	exp.fullBufferHandler = handler
+}
+
 // Expect keeps reading input till either a timeout occurs (if set), one of the
 // strings/regexps passed matches the input, end of input occurs or an error.
 // If a string/regexp match occurs the index of the successful argument and the matching bytes
 // are returned. Otherwise an error value and error are returned.
+// The errors are a *TimeoutError, *ReadError or *PatternError which carry the
+// patterns and the end of Buffer to help diagnose what went wrong.
 // Note: on EOF the return value will be NotFound and the error will be nil as
 // EOF is not considered an error. This is the only time those values will be returned.
-// See also Expecti()
+// As well as strings and regexps the pseudo-patterns FullBuffer, EndOfFile and
+// Timeout may be passed. When one of these fires its index is returned, with a
+// nil error, instead of the NotFound or TimedOut values above.
+// See also Expecti(), ExpectCase() and SetMatchMax()
 func (exp *Expect) Expect(reOrStrs ...interface{}) (int, []byte, error) {
+	return exp.expect(nil, reOrStrs)
+}
+
+// ExpectBefore is Expect() that also returns the input before the match, which
+// Expect() throws away. Together before and found are what Tcl expect puts in
+// expect_out(buffer). before is nil unless a string or regexp matched.
+func (exp *Expect) ExpectBefore(reOrStrs ...interface{}) (n int, before, found []byte, err error) {
+	return exp.expectBefore(nil, reOrStrs)
+}
+
+// ExpectContext is Expect() that also gives up when ctx is done, returning
+// Cancelled and ctx.Err(). The timeout set by SetTimeout() still applies.
+func (exp *Expect) ExpectContext(ctx context.Context, reOrStrs ...interface{}) (int, []byte, error) {
+	return exp.expect(ctx, reOrStrs)
+}
+
+// expect is Expect(). If ctx is not nil it is watched as for ExpectContext().
+func (exp *Expect) expect(ctx context.Context, reOrStrs []interface{}) (int, []byte, error) {
+	n, _, found, err := exp.expectBefore(ctx, reOrStrs)
+	return n, found, err
+}
+
+// expectBefore is expect() that also returns the input before a match of a
+// string or regexp
+func (exp *Expect) expectBefore(ctx context.Context, reOrStrs []interface{}) (int, []byte, []byte, error) {
 	// Check the args
 	for n, reOrStr := range reOrStrs {
 		switch reOrStr.(type) {
@@ -255,47 +543,97 @@ func (exp *Expect) Expect(reOrStrs ...interface{}) (int, []byte, error) {
 			continue
 		case *regexp.Regexp:
 			continue
+		case Pseudo:
+			continue
 		default:
 			debugf("Expect non string/regexp passed as arg %d", n)
-			return NotStringOrRexgexp, nil, ENotStringOrRexgexp
+			return NotStringOrRexgexp, nil, nil, &PatternError{Index: n, Pattern: reOrStr}
 		}
 	}
 
+	exp.lockRead()
+	defer exp.unlockRead()
+	return exp.expectLocked(ctx, reOrStrs, false)
+}
+
+// expectLocked is expectBefore() for a caller that holds the read lock and
+// has checked reOrStrs. If keepBefore is true then only the match is removed
+// from Buffer, the input before it is kept.
+func (exp *Expect) expectLocked(ctx context.Context, reOrStrs []interface{}, keepBefore bool) (int, []byte, []byte, error) {
+	eofIndex := pseudoIndex(reOrStrs, EndOfFile)
+	timeoutIndex := pseudoIndex(reOrStrs, Timeout)
+	m := newMatcher(reOrStrs)
+
 	if exp.Eof {
 		debugf("already at EOF")
-		return NotFound, nil, nil
+		if eofIndex >= 0 {
+			return eofIndex, nil, exp.takeBuffer(), nil
+		}
+		return NotFound, nil, nil, nil
 	}
 
+	started := time.Now()
 	timedOut := make(<-chan time.Time)
 
 	if exp.timeout != 0 {
 		timedOut = time.After(exp.timeout)
 	}
 
-	if len(exp.Buffer.Bytes()) > 0 {
-		// Fake byte-less input to get the for/select loop to process any pending buffered
-		// input
-		exp.bytesIn <- byteIn{}
+	var cancelled <-chan struct{}
+	if ctx != nil {
+		cancelled = ctx.Done()
+	}
+
+	if exp.Buffer.Len() > 0 {
+		// Search what is already buffered before any new input, which also
+		// gets the matcher ready for the bytes that follow
+		m.context = exp.bufferContext
+		if n, start, end := m.scan(exp.Buffer.Bytes()); n >= 0 {
+			before, found := exp.takeMatch(start, end, keepBefore)
+			return n, before, found, nil
+		}
 	}
 
 	for {
 		select {
+		case <-cancelled:
+			debugf("Expect cancelled")
+			return Cancelled, nil, nil, ctx.Err()
 		case <-timedOut:
 			debugf("Expect timedOut")
-			return TimedOut, nil, ETimedOut
+			if timeoutIndex >= 0 {
+				buffered := make([]byte, exp.Buffer.Len())
+				copy(buffered, exp.Buffer.Bytes())
+				return timeoutIndex, nil, buffered, nil
+			}
+			return TimedOut, nil, nil, &TimeoutError{
+				Patterns: reOrStrs,
+				Elapsed:  time.Since(started),
+				Tail:     errorTail(exp.Buffer.Bytes()),
+			}
 		case boe, ok := <-exp.bytesIn:
 			if !ok {
 				debugf("Expect read error")
-				exp.expectReaderRunning = false
 				exp.Eof = true
-				return NotFound, nil, EReadError
+				exp.endBackgrounds()
+				return NotFound, nil, nil, exp.readError(reOrStrs, started, nil)
+			}
+
+			if boe.err != nil {
+				debugf("Expect read error %s", boe.err)
+				exp.Eof = true
+				exp.endBackgrounds()
+				return NotFound, nil, nil, exp.readError(reOrStrs, started, boe.err)
 			}
 
 			if boe.isEOF {
 				debugf("Expect eof")
-				exp.expectReaderRunning = false
 				exp.Eof = true
-				return NotFound, nil, nil
+				exp.endBackgrounds()
+				if eofIndex >= 0 {
+					return eofIndex, nil, exp.takeBuffer(), nil
+				}
+				return NotFound, nil, nil, nil
 			}
 
 			if boe.isByte {
@@ -303,122 +641,291 @@ func (exp *Expect) Expect(reOrStrs ...interface{}) (int, []byte, error) {
 				debugf("Expect got new byte %c", b)
 				if err := exp.Buffer.WriteByte(b); err != nil {
 					debugf("Expect failed to add to buffer: %s", err)
-					return NotFound, nil, EReadError
+					return NotFound, nil, nil, exp.readError(reOrStrs, started, err)
 				}
 			}
 
 			bufBytes := exp.Buffer.Bytes()
-			debugf("Expect buffer now:<<%s>>", string(bufBytes))
+			debugf("Expect buffer now:<<%s>>", bufBytes)
 			debugf("Expect check for regexps")
-			for n, reOrStr := range reOrStrs {
-				var start, end int
-				switch rs := reOrStr.(type) {
-				case string:
-					debugf("string passed: %s", rs)
-					start = bytes.Index(bufBytes, []byte(rs))
-					if start < 0 {
-						continue
-					}
-					end = start + len(rs)
-					debugf("string found")
-				case *regexp.Regexp:
-					debugf("re passed: %s", rs)
-					loc := rs.FindIndex(bufBytes)
-					if loc == nil {
-						continue
-					}
-					start, end = loc[0], loc[1]
-					debugf("re found")
+			m.context = exp.bufferContext
+			n, start, end := m.next(bufBytes)
+			if n >= 0 {
+				before, found := exp.takeMatch(start, end, keepBefore)
+				return n, before, found, nil
+			}
+
+			if exp.matchBackground() {
+				m.rescan = true
+			}
+
+			// Only now nothing has matched is it safe to discard old input
+			if discarded := exp.discardOverMax(); discarded != nil {
+				m.rescan = true
+				if n := pseudoIndex(reOrStrs, FullBuffer); n >= 0 {
+					debugf("Expect full buffer")
+					return n, nil, discarded, nil
 				}
+			}
+		}
+	}
+}
+
+// takeMatch removes the match at start to end from Buffer, and everything
+// before it unless keepBefore is set, returning copies of what was before the
+// match, nil if it was kept, and of the match
+func (exp *Expect) takeMatch(start, end int, keepBefore bool) ([]byte, []byte) {
+	bufBytes := exp.Buffer.Bytes()
+	var before []byte
+	if !keepBefore {
+		before = append([]byte{}, bufBytes[:start]...)
+	}
+	// dont just assign a slice as I'm about to change the contents
+	// of bytes and the slice will end up referencing the new data
+	//found := bytes[start:end]
+	found := make([]byte, end-start)
+	copy(found, bufBytes[start:end])
+	debugf("Expect found %s (start %d, end %d)", string(found), start, end)
+
+	debugf("Expect reset buffer to the remaining input following the match")
+	debugf("Expect buffer before reset:<<%s>>", string(exp.Buffer.Bytes()))
+	newBuf := bufBytes[end:]
+	if keepBefore {
+		newBuf = append(append([]byte{}, bufBytes[:start]...), newBuf...)
+	}
+	debugf("Expect remaining:<<%s>>", string(newBuf))
+	exp.Buffer.Reset()
+	exp.Buffer.Write(newBuf)
+	exp.bufferEdits++
+	if !keepBefore || start == 0 {
+		exp.bufferContext = nil
+	}
+	debugf("Expect buffer after reset:<<%s>>", string(exp.Buffer.Bytes()))
+	return before, found
+}
+
+// ExpectCase is Expect() in the style of a Tcl expect command with a body for
+// each pattern. When a pattern matches its Handler is called with the found
+// bytes. If the Handler returns ExpContinue then ExpectCase waits for another
+// match, otherwise the index of the Case, the found bytes and the Handler's
+// error are returned. Timeouts and errors are returned as for Expect().
+func (exp *Expect) ExpectCase(cases ...Case) (int, []byte, error) {
+	reOrStrs := make([]interface{}, len(cases))
+	for n, c := range cases {
+		reOrStrs[n] = c.Pattern
+	}
+	for {
+		n, found, err := exp.Expect(reOrStrs...)
+		if n < 0 || cases[n].Handler == nil {
+			return n, found, err
+		}
+		err = cases[n].Handler(found)
+		if err == ExpContinue {
+			debugf("ExpectCase continue")
+			continue
+		}
+		return n, found, err
+	}
+}
 
-				// dont just assign a slice as I'm about to change the contents
-				// of bytes and the slice will end up referencing the new data
-				//found := bytes[start:end]
-				found := make([]byte, end-start)
-				copy(found, bufBytes[start:end])
-				debugf("Expect found %s (start %d, end %d)", string(found), start, end)
-
-				debugf("Expect reset buffer to the remaining input following the match")
-				debugf("Expect buffer before reset:<<%s>>", string(exp.Buffer.Bytes()))
-				newBuf := bufBytes[end:]
-				debugf("Expect remaining:<<%s>>", string(newBuf))
-				exp.Buffer.Reset()
-				exp.Buffer.Write(newBuf)
-				debugf("Expect buffer after reset:<<%s>>", string(exp.Buffer.Bytes()))
-
-				return n, found, nil
+// pseudoIndex returns the index of p in reOrStrs or -1 if not present
+func pseudoIndex(reOrStrs []interface{}, p Pseudo) int {
+	for n, reOrStr := range reOrStrs {
+		if reOrStr == p {
+			return n
+		}
+	}
+	return -1
+}
+
+// readError builds the *ReadError for Expect()
+func (exp *Expect) readError(reOrStrs []interface{}, started time.Time, err error) error {
+	return &ReadError{
+		Patterns: reOrStrs,
+		Elapsed:  time.Since(started),
+		Tail:     errorTail(exp.Buffer.Bytes()),
+		Err:      err,
+	}
+}
+
+// takeBuffer empties Buffer returning what it held
+func (exp *Expect) takeBuffer() []byte {
+	buffered := make([]byte, exp.Buffer.Len())
+	copy(buffered, exp.Buffer.Bytes())
+	exp.Buffer.Reset()
+	exp.bufferEdits++
+	exp.bufferContext = nil
+	return buffered
+}
+
+// matchPatterns returns the index of the first of reOrStrs to match buf and
+// the start and end of the match in buf. If nothing matches the index is
+// NotFound. Pseudo-patterns never match here.
+func matchPatterns(reOrStrs []interface{}, buf []byte) (int, int, int) {
+	for n, reOrStr := range reOrStrs {
+		switch rs := reOrStr.(type) {
+		case string:
+			debugf("string passed: %s", rs)
+			start := bytes.Index(buf, []byte(rs))
+			if start < 0 {
+				continue
 			}
+			debugf("string found")
+			return n, start, start + len(rs)
+		case *regexp.Regexp:
+			debugf("re passed: %s", rs)
+			loc := rs.FindIndex(buf)
+			if loc == nil {
+				continue
+			}
+			debugf("re found")
+			return n, loc[0], loc[1]
 		}
 	}
+	return NotFound, 0, 0
+}
 
-	// I can never get here...
-	return NotFound, nil, nil
+// discardOverMax removes the oldest half of Buffer once it is larger than
+// matchMax. The discarded bytes are returned, or nil if nothing was
+// discarded. The cut is moved forward to the start of a rune so that a
+// multi-byte character is never left split at the front of Buffer.
+func (exp *Expect) discardOverMax() []byte {
+	if exp.matchMax <= 0 || exp.Buffer.Len() <= exp.matchMax {
+		return nil
+	}
+	bufBytes := exp.Buffer.Bytes()
+	cut := len(bufBytes) - exp.matchMax/2
+	for cut < len(bufBytes) && !utf8.RuneStart(bufBytes[cut]) {
+		cut++
+	}
+	discarded := make([]byte, cut)
+	copy(discarded, exp.Buffer.Next(cut))
+	exp.bufferEdits++
+	_, size := utf8.DecodeLastRune(discarded)
+	exp.bufferContext = append([]byte{}, discarded[len(discarded)-size:]...)
+	debugf("Expect discarded:<<%s>>", string(discarded))
+	if exp.fullBufferHandler != nil {
+		exp.fullBufferHandler(discarded)
+	}
+	return discarded
 }
 
 // byteOrEof is used between Expect and readToChan.
-// If isEOF is false and isByte is false then there is no input. This is used
-// to get Expect() to process left over buffer input.
+// If err is not nil the read failed for a reason other than the end of input.
 type byteIn struct {
 	isEOF  bool
 	isByte bool
 	b      byte
+	err    error
 }
 
 // expectReader reads from the pty and sends either a byte or eof to Expect.
-// if anything appears on endExpectReader this goroutine ends
+// if endExpectReader is closed this goroutine ends
 func (exp *Expect) expectReader() {
 	debugf("expectReader starting")
-	buf := make([]byte, 1)
+	defer close(exp.readerDone)
+	defer exp.endSubscribers()
+	defer exp.endOutputWriters()
+	defer func() {
+		if m := exp.shellMarks.Load(); m != nil {
+			m.Close()
+		}
+	}()
+	buf := make([]byte, 4096)
 	for {
 		select {
 		case <-exp.endExpectReader:
 			debugf("expectReader ending")
 			return
 		default:
-			n, err := exp.cmdIn.Read(buf)
-			debugf("expectReader read %d, %c, %s", n, buf[0], err)
+			n, err := exp.File.Read(buf)
+			debugf("expectReader read %d, %q, %v", n, buf[:max(n, 0)], err)
 			if err != nil {
 				if unixIsEAGAIN(err) {
 					debugf("expectReader EAGAIN")
 					time.Sleep(100 * time.Millisecond) // reduce busy looping
 					continue
 				}
+				if unixIsEOF(err) {
+					debugf("expectReader ending eof")
+					exp.sendIn(byteIn{isEOF: true})
+					return
+				}
 				debugf("expectReader ending read error")
-				exp.bytesIn <- byteIn{isEOF: true}
+				exp.sendIn(byteIn{err: err})
 				return
 			}
 			if n == 0 {
 				// Not EAGAIN but no input
 				debugf("expectReader ending nothing read")
-				exp.bytesIn <- byteIn{isEOF: true}
+				exp.sendIn(byteIn{isEOF: true})
 				return
 			}
 			if n < 0 {
 				continue
 			}
-			exp.bytesIn <- byteIn{isByte: true, b: buf[0]}
+			for _, b := range buf[:n] {
+				exp.keyModes.scan(b)
+				exp.answerQuery(b)
+			}
+			if m := exp.shellMarks.Load(); m != nil {
+				m.Write(buf[:n])
+			}
+			exp.writeOutput(buf[:n])
+			exp.publish(buf[:n])
+			for _, b := range buf[:n] {
+				if !exp.sendIn(byteIn{isByte: true, b: b}) {
+					debugf("expectReader ending")
+					return
+				}
+			}
 		}
 	}
 }
 
+// sendIn passes boe on to Expect. If bytesIn is full it blocks until there is
+// space or endExpectReader is closed in which case it returns false.
+func (exp *Expect) sendIn(boe byteIn) bool {
+	select {
+	case exp.bytesIn <- boe:
+		return true
+	case <-exp.endExpectReader:
+		return false
+	}
+}
+
+// stopReader ends the expectReader goroutine. It is safe to call more than
+// once.
+func (exp *Expect) stopReader() {
+	exp.endOnce.Do(func() {
+		close(exp.endExpectReader)
+	})
+}
+
 // Clear out any unprocessed input
 func (exp *Expect) Clear() {
+	exp.lockRead()
+	defer exp.unlockRead()
 	exp.Buffer.Reset()
+	exp.bufferEdits++
+	exp.bufferContext = nil
 }
 
 // BufStr is the buffer of expect read data as a string
 func (exp *Expect) BufStr() string {
+	exp.lockRead()
+	defer exp.unlockRead()
 	return string(exp.Buffer.Bytes())
 }
 
-// Kill the command. Using an Expect after a Kill is undefined
+// Kill the command and everything else running in its session. Once the
+// command has been reaped only the command itself is killed, see Shutdown().
+// Using an Expect after a Kill is undefined
 func (exp *Expect) Kill() error {
 	exp.Buffer.Reset()
+	exp.signalSession(syscall.SIGKILL)
 	exp.Close()
-	if exp.expectReaderRunning {
-		exp.endExpectReader <- true
-		exp.expectReaderRunning = false
-	}
+	exp.stopReader()
 	return exp.cmd.Process.Kill()
 }
 
@@ -456,11 +963,20 @@ func (exp *Expect) Expecti(reOrStrs ...interface{}) int {
 }
 
 // Expectp is a convenience wrapper around Expect() that panics on no match
-// (so will panic on eof)
+// (so will panic on eof). The panic value is the error from Expect() or an
+// *EOFError
 func (exp *Expect) Expectp(reOrStrs ...interface{}) int {
-	i, _, _ := exp.Expect(reOrStrs...)
+	started := time.Now()
+	i, _, err := exp.Expect(reOrStrs...)
 	if i < 0 {
-		panic(fmt.Sprintf("%s", reOrStrs...))
+		if err == nil {
+			err = &EOFError{
+				Patterns: reOrStrs,
+				Elapsed:  time.Since(started),
+				Tail:     errorTail(exp.Buffer.Bytes()),
+			}
+		}
+		panic(err)
 	}
 	return i
 }
@@ -476,6 +992,13 @@ func (exp *Expect) LogUser(on bool) {
 	}
 }
 
+// unixIsEOF is true for the errors that mean the other end of the pty has
+// been closed. On Linux reading the master side of a pty gives EIO once the
+// slave side is closed.
+func unixIsEOF(err error) bool {
+	return err == io.EOF || errors.Is(err, syscall.EIO)
+}
+
 // Copied from the non-exported func in src/crypto/rand/eagain.go
 
 func unixIsEAGAIN(err error) bool {
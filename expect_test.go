@@ -14,6 +14,7 @@ package expect
 
 import (
 	"bytes"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
@@ -83,28 +84,17 @@ func checkResultRe(t *testing.T, pat string, re *regexp.Regexp, i int, n int, fo
 }
 
 func showWaitResult(t *testing.T, exp *Expect) {
-	valid := false
-	// Wait a little bit for Result to be filled in
-	time.Sleep(time.Millisecond * 10)
-	// Try a few fimes to see if its valid (maybe I should have used a channel
-	// but checking for all the errors is painful for code I expect to be used
-	// so rarely)
-	for i := 1; i <= 3; i++ {
-		if exp.Result.IsValid {
-			valid = true
-			break
-		}
-		t.Logf("pause %d for Wait() result", i)
-		time.Sleep(time.Second)
-	}
-	if !valid {
+	select {
+	case <-exp.Done():
+	case <-time.After(3 * time.Second):
 		t.Logf("Wait() result never went valid")
 		return
 	}
-	if exp.Result.Error != nil {
-		t.Logf("Wait() result: %s, %s", exp.Result.ProcessState, exp.Result.Error)
+	ps, err := exp.Wait()
+	if err != nil {
+		t.Logf("Wait() result: %s, %s", ps, err)
 	} else {
-		t.Logf("Wait() result: %s", exp.Result.ProcessState)
+		t.Logf("Wait() result: %s", ps)
 	}
 }
 
@@ -207,7 +197,7 @@ func Test_ExpectCmdOutAndClear(t *testing.T) {
 	exp.SetCmdOut(buf)
 
 	exp.Send("1\r")
-	exp.Send(EOF)
+	exp.SendEOF()
 
 	// This will grab everything (till eof) and will copy it to buf
 	exp.Expect()
@@ -227,7 +217,7 @@ func Test_ExpectCmdOutAndClear(t *testing.T) {
 	exp.Clear()
 	bufs = exp.BufStr()
 	if bufs != "" {
-		t.Errorf("buf wrong contains <<%s>> should be empty", bufs, expected)
+		t.Errorf("buf wrong contains <<%s>> should be empty", bufs)
 		for i, c := range bufs {
 			t.Errorf("c[%d] %c %d", i, c, int(c))
 		}
@@ -254,8 +244,8 @@ func Test_ExpectMatchTimeout(t *testing.T) {
 	n, found, err := exp.Expect("no way")
 	if n == 0 {
 		t.Errorf("found something when I shouldn't: %s", string(found))
-	} else if err == ETimedOut {
-		t.Logf("timed out as expected")
+	} else if errors.Is(err, ETimedOut) {
+		t.Logf("timed out as expected: %s", err)
 	} else {
 		t.Logf("found unexpected error: %s", err)
 	}
@@ -282,7 +272,7 @@ func Test_ExpectMatch1s(t *testing.T) {
 	n, found, err := exp.Expect(pat)
 	checkResultStr(t, pat, 0, n, found, err)
 
-	exp.Send(EOF)
+	exp.SendEOF()
 	showWaitResult(t, exp)
 }
 
@@ -305,7 +295,7 @@ func Test_ExpectMatch1re(t *testing.T) {
 	n, found, err := exp.Expect(re)
 	checkResultRe(t, pat, re, 0, n, found, err)
 
-	exp.Send(EOF)
+	exp.SendEOF()
 	showWaitResult(t, exp)
 }
 
@@ -331,7 +321,7 @@ func Test_ExpectiMatch1(t *testing.T) {
 		t.Log("OK")
 	}
 
-	exp.Send(EOF)
+	exp.SendEOF()
 	showWaitResult(t, exp)
 }
 
@@ -363,7 +353,7 @@ func Test_ExpectMatch2re(t *testing.T) {
 	n, found, err = exp.Expect(re, re2)
 	checkResultRe(t, pat2, re2, 1, n, found, err)
 
-	exp.Send(EOF)
+	exp.SendEOF()
 	showWaitResult(t, exp)
 }
 
@@ -393,7 +383,7 @@ func Test_ExpectMatch2s(t *testing.T) {
 	n, found, err = exp.Expect(pat, pat2)
 	checkResultStr(t, pat2, 1, n, found, err)
 
-	exp.Send(EOF)
+	exp.SendEOF()
 	showWaitResult(t, exp)
 }
 
@@ -426,7 +416,7 @@ func Test_ExpectMatch2reEOI(t *testing.T) {
 	n, found, err = exp.Expect(re, re2)
 	checkResultRe(t, pat2, re2, 1, n, found, err)
 
-	exp.Send(EOF)
+	exp.SendEOF()
 	showWaitResult(t, exp)
 }
 
@@ -456,7 +446,7 @@ func Test_ExpectMatch2sEOI(t *testing.T) {
 	n, found, err = exp.Expect(pat, pat2)
 	checkResultStr(t, pat2, 1, n, found, err)
 
-	exp.Send(EOF)
+	exp.SendEOF()
 	showWaitResult(t, exp)
 }
 
@@ -483,7 +473,7 @@ func Test_ExpectMatchFind2nd(t *testing.T) {
 	debugf("n %d, found %s, err %s", n, string(found), err)
 	checkResultStr(t, pat2, 1, n, found, err)
 
-	exp.Send(EOF)
+	exp.SendEOF()
 	showWaitResult(t, exp)
 }
 
@@ -515,7 +505,7 @@ func Test_ExpectMatchSplitRe(t *testing.T) {
 	n, found, err = exp.Expect(re)
 	checkResultRe(t, pat2, re, 0, n, found, err)
 
-	exp.Send(EOF)
+	exp.SendEOF()
 	showWaitResult(t, exp)
 }
 
@@ -545,7 +535,7 @@ func Test_ExpectMatchSplitS(t *testing.T) {
 	n, found, err = exp.Expect(pat2)
 	checkResultStr(t, pat2, 0, n, found, err)
 
-	exp.Send(EOF)
+	exp.SendEOF()
 	showWaitResult(t, exp)
 }
 
@@ -573,7 +563,7 @@ func Test_ExpectSendDelay(t *testing.T) {
 	n, found, err := exp.Expect(pat)
 	checkResultStr(t, pat, 0, n, found, err)
 
-	exp.Send(EOF)
+	exp.SendEOF()
 	showWaitResult(t, exp)
 }
 
@@ -597,7 +587,7 @@ func Test_ExpectUTFs(t *testing.T) {
 	n, found, err := exp.Expect(pat)
 	checkResultStr(t, pat, 0, n, found, err)
 
-	exp.Send(EOF)
+	exp.SendEOF()
 	showWaitResult(t, exp)
 }
 
@@ -622,6 +612,312 @@ func Test_ExpectUTFre(t *testing.T) {
 	n, found, err := exp.Expect(re)
 	checkResultRe(t, "世界", re, 0, n, found, err)
 
-	exp.Send(EOF)
+	exp.SendEOF()
+	showWaitResult(t, exp)
+}
+
+func Test_ExpectMatchMax(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	t.Logf("starting %s", prog)
+	exp, err := NewExpect(prog)
+	if err != nil {
+		t.Errorf("NewExpect failed %s", err)
+		return
+	}
+	exp.SetTimeoutSecs(10)
+	exp.Expect("Enter test name:")
+
+	max := 64
+	exp.SetMatchMax(max)
+	discarded := 0
+	exp.SetFullBufferHandler(func(b []byte) {
+		discarded += len(b)
+	})
+
+	t.Log("sending 5\\r, the test program will send back 100 lines")
+	exp.Send("5\r")
+
+	pat := "line 100 of chatty output"
+	n, found, err := exp.Expect(pat)
+	checkResultStr(t, pat, 0, n, found, err)
+	if discarded == 0 {
+		t.Errorf("expected the full buffer handler to discard input")
+	} else {
+		t.Logf("discarded %d bytes", discarded)
+	}
+	if exp.Buffer.Len() > max {
+		t.Errorf("buffer is %d bytes, more than %d", exp.Buffer.Len(), max)
+	}
+
+	exp.SendEOF()
+	showWaitResult(t, exp)
+}
+
+func Test_ExpectFullBuffer(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	t.Logf("starting %s", prog)
+	exp, err := NewExpect(prog)
+	if err != nil {
+		t.Errorf("NewExpect failed %s", err)
+		return
+	}
+	exp.SetTimeoutSecs(10)
+	exp.Expect("Enter test name:")
+	exp.SetMatchMax(32)
+
+	t.Log("sending 5\\r, the test program will send back 100 lines")
+	exp.Send("5\r")
+
+	n, found, err := exp.Expect("DONT FIND THIS", FullBuffer)
+	if n != 1 {
+		t.Errorf("expected FullBuffer (1) got %d: %s", n, err)
+	} else if len(found) == 0 {
+		t.Errorf("FullBuffer returned no discarded input")
+	} else {
+		t.Logf("FullBuffer discarded <<%s>>", string(found))
+	}
+
+	exp.SendEOF()
 	showWaitResult(t, exp)
 }
+
+// queuedExpect returns an Expect without a process whose Buffer holds
+// buffered and which has queued waiting to be read
+func queuedExpect(buffered, queued string) *Expect {
+	exp := &Expect{
+		Buffer:   bytes.NewBufferString(buffered),
+		bytesIn:  make(chan byteIn, len(queued)),
+		readLock: make(chan struct{}, 1),
+		wantRead: make(chan struct{}, 1),
+		timeout:  time.Second,
+	}
+	for _, b := range []byte(queued) {
+		exp.bytesIn <- byteIn{isByte: true, b: b}
+	}
+	return exp
+}
+
+func Test_ExpectFullBufferOnce(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	exp := queuedExpect("", "abcdefghijklmnopqrst")
+	exp.SetMatchMax(8)
+	exp.SetTimeout(100 * time.Millisecond)
+	var discarded []string
+	for {
+		n, found, _ := exp.Expect("DONT FIND THIS", FullBuffer)
+		if n != 1 {
+			break
+		}
+		discarded = append(discarded, string(found))
+	}
+	if strings.Join(discarded, " ") != "abcde fghij klmno" || exp.Buffer.String() != "pqrst" {
+		t.Errorf("discarded %q leaving %q", discarded, exp.Buffer.String())
+	}
+}
+
+func Test_ExpectAcrossDiscard(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	for _, tc := range []struct {
+		input string
+		re    string
+		found string
+	}{
+		// The match starts in what is discarded when the 8 arrives
+		{"0123456789x", `\d{4}x`, "6789x"},
+		// ^ and \A do not match at the new start of Buffer
+		{"0123456789x", `^5|\A5|(?m)^5|x`, "x"},
+		// \b sees the discarded input before the new start
+		{"0123456789x", `\b5|x`, "x"},
+		{"0123 5678x", `\b5678x`, "5678x"},
+		{"0123\n5678x", `(?m)^5678x`, "5678x"},
+	} {
+		exp := queuedExpect("", tc.input)
+		exp.SetMatchMax(8)
+		var discarded string
+		exp.SetFullBufferHandler(func(b []byte) {
+			discarded += string(b)
+		})
+		n, found, err := exp.Expect(regexp.MustCompile(tc.re))
+		if n != 0 || string(found) != tc.found || discarded == "" {
+			t.Errorf("%q in %q found %d %q %v after discarding %q, expected %q",
+				tc.re, tc.input, n, found, err, discarded, tc.found)
+		}
+	}
+}
+
+func Test_ExpectEndOfFile(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	t.Logf("starting %s", prog)
+	exp, err := NewExpect(prog)
+	if err != nil {
+		t.Errorf("NewExpect failed %s", err)
+		return
+	}
+	exp.SetTimeoutSecs(10) // Shouldn't happen
+
+	t.Log("sending 1\\r + eof")
+	exp.Send("1\r")
+	exp.SendEOF()
+
+	n, found, err := exp.Expect("DONT FIND THIS", EndOfFile)
+	if n != 1 {
+		t.Errorf("expected EndOfFile (1) got %d: %s", n, err)
+	} else if !strings.Contains(string(found), "Welcome to the first test") {
+		t.Errorf("EndOfFile found <<%s>> not the remaining input", string(found))
+	} else {
+		t.Log("EndOfFile returned the remaining input")
+	}
+
+	n, _, _ = exp.Expect(EndOfFile)
+	if n != 0 {
+		t.Errorf("expected EndOfFile (0) when already at EOF got %d", n)
+	}
+	showWaitResult(t, exp)
+}
+
+func Test_ExpectTimeout(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	t.Logf("starting %s", prog)
+	exp, err := NewExpect(prog)
+	if err != nil {
+		t.Errorf("NewExpect failed %s", err)
+		return
+	}
+
+	t.Log("setting 1 sec timer and sending nothing so will hang forcing timeout")
+	exp.SetTimeoutSecs(1)
+
+	n, found, err := exp.Expect("no way", Timeout)
+	if n != 1 || err != nil {
+		t.Errorf("expected Timeout (1) got %d: %s", n, err)
+	} else if !strings.Contains(string(found), "Enter test name:") {
+		t.Errorf("Timeout found <<%s>> not the buffered input", string(found))
+	} else {
+		t.Log("timed out as expected")
+	}
+
+	pat := "Enter test name:"
+	n, found, err = exp.Expect(pat)
+	checkResultStr(t, pat, 0, n, found, err)
+
+	exp.Kill()
+	showWaitResult(t, exp)
+}
+
+func Test_ExpectCase(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	t.Logf("starting %s", prog)
+	exp, err := NewExpect(prog)
+	if err != nil {
+		t.Errorf("NewExpect failed %s", err)
+		return
+	}
+	exp.SetTimeoutSecs(10) // Shouldn't happen
+	exp.Expect("Enter test name:")
+
+	t.Log("sending 2\\r + eof")
+	exp.Send("2\r")
+	exp.SendEOF()
+
+	lines := 0
+	n, _, err := exp.ExpectCase(
+		Case{Pattern: regexp.MustCompile("[^\r\n]*\r\n"), Handler: func(found []byte) error {
+			t.Logf("line %d: %q", lines, string(found))
+			lines++
+			return ExpContinue
+		}},
+		Case{Pattern: EndOfFile},
+	)
+	if n != 1 || err != nil {
+		t.Errorf("expected EndOfFile (1) got %d: %s", n, err)
+	}
+	if lines < 3 {
+		t.Errorf("expected at least 3 lines got %d", lines)
+	}
+	showWaitResult(t, exp)
+}
+
+func Test_ExpectWait(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	t.Logf("starting %s", prog)
+	exp, err := NewExpect(prog)
+	if err != nil {
+		t.Errorf("NewExpect failed %s", err)
+		return
+	}
+	if exp.ExitCode() != -1 {
+		t.Errorf("ExitCode() is %d before the process exited", exp.ExitCode())
+	}
+	exp.SetTimeoutSecs(10) // Shouldn't happen
+	exp.Send("0\r")
+
+	ps, err := exp.Wait()
+	if err != nil {
+		t.Errorf("Wait() failed %s", err)
+		return
+	}
+	t.Logf("Wait() result: %s", ps)
+	if exp.ExitCode() != 0 {
+		t.Errorf("ExitCode() is %d not 0", exp.ExitCode())
+	}
+	if sig, ok := exp.ExitSignal(); ok {
+		t.Errorf("ExitSignal() is %s after a normal exit", sig)
+	}
+}
+
+func Test_ExpectProcDoneAndKill(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	t.Logf("starting %s", prog)
+	exp, _, err := NewExpectProc(prog)
+	if err != nil {
+		t.Errorf("NewExpectProc failed %s", err)
+		return
+	}
+	select {
+	case <-exp.Done():
+		t.Errorf("Done() closed before the process exited")
+	default:
+	}
+
+	t.Log("OK killing processes")
+	exp.Kill()
+	select {
+	case <-exp.Done():
+		t.Log("Done() closed")
+	case <-time.After(5 * time.Second):
+		t.Errorf("Done() never closed")
+		return
+	}
+	if exp.Exited() {
+		t.Errorf("Exited() is true before Wait() reaped the process")
+	}
+
+	// Kill closes the pty first so the process may see SIGHUP before SIGKILL
+	exp.Wait()
+	if sig, ok := exp.ExitSignal(); !ok {
+		t.Errorf("ExitSignal() is false after being killed")
+	} else {
+		t.Logf("ExitSignal() is %s", sig)
+	}
+	if exp.ExitCode() != -1 {
+		t.Errorf("ExitCode() is %d after being killed", exp.ExitCode())
+	}
+}
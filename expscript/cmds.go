@@ -0,0 +1,1178 @@
+/*
+File summary: Core Tcl commands
+Package: expscript
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expscript
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// coreCommands are the Tcl commands
+var coreCommands map[string]command
+
+func init() {
+	coreCommands = map[string]command{
+		"after":    cmdAfter,
+		"append":   cmdAppend,
+		"array":    cmdArray,
+		"break":    cmdBreak,
+		"catch":    cmdCatch,
+		"clock":    cmdClock,
+		"concat":   cmdConcat,
+		"continue": cmdContinue,
+		"error":    cmdError,
+		"eval":     cmdEval,
+		"exit":     cmdExit,
+		"expr":     cmdExpr,
+		"file":     cmdFile,
+		"for":      cmdFor,
+		"foreach":  cmdForeach,
+		"format":   cmdFormat,
+		"gets":     cmdGets,
+		"global":   cmdGlobal,
+		"if":       cmdIf,
+		"incr":     cmdIncr,
+		"info":     cmdInfo,
+		"join":     cmdJoin,
+		"lappend":  cmdLappend,
+		"lindex":   cmdLindex,
+		"list":     cmdList,
+		"llength":  cmdLlength,
+		"lrange":   cmdLrange,
+		"lsearch":  cmdLsearch,
+		"package":  cmdPackage,
+		"proc":     cmdProc,
+		"puts":     cmdPuts,
+		"regexp":   cmdRegexp,
+		"regsub":   cmdRegsub,
+		"return":   cmdReturn,
+		"set":      cmdSet,
+		"source":   cmdSource,
+		"split":    cmdSplit,
+		"string":   cmdString,
+		"switch":   cmdSwitch,
+		"unset":    cmdUnset,
+		"while":    cmdWhile,
+	}
+}
+
+// wrongArgs is the usual error for a bad argument count
+func wrongArgs(usage string) error {
+	return errorf("wrong # args: should be \"%s\"", usage)
+}
+
+func cmdSet(in *Interp, args []string) (string, error) {
+	switch len(args) {
+	case 2:
+		return in.getVar(args[1])
+	case 3:
+		return in.setVar(args[1], args[2])
+	}
+	return "", wrongArgs("set varName ?newValue?")
+}
+
+func cmdUnset(in *Interp, args []string) (string, error) {
+	nocomplain := false
+	for _, name := range args[1:] {
+		switch name {
+		case "-nocomplain":
+			nocomplain = true
+			continue
+		case "--":
+			continue
+		}
+		if err := in.unsetVar(name); err != nil && !nocomplain {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+func cmdIncr(in *Interp, args []string) (string, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return "", wrongArgs("incr varName ?increment?")
+	}
+	by := int64(1)
+	if len(args) == 3 {
+		n, err := strconv.ParseInt(args[2], 0, 64)
+		if err != nil {
+			return "", errorf("expected integer but got %q", args[2])
+		}
+		by = n
+	}
+	cur := int64(0)
+	if in.varExists(args[1]) {
+		v, err := in.getVar(args[1])
+		if err != nil {
+			return "", err
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(v), 0, 64)
+		if err != nil {
+			return "", errorf("expected integer but got %q", v)
+		}
+		cur = n
+	}
+	return in.setVar(args[1], strconv.FormatInt(cur+by, 10))
+}
+
+func cmdAppend(in *Interp, args []string) (string, error) {
+	if len(args) < 2 {
+		return "", wrongArgs("append varName ?value ...?")
+	}
+	cur := ""
+	if in.varExists(args[1]) {
+		v, err := in.getVar(args[1])
+		if err != nil {
+			return "", err
+		}
+		cur = v
+	}
+	return in.setVar(args[1], cur+strings.Join(args[2:], ""))
+}
+
+func cmdGlobal(in *Interp, args []string) (string, error) {
+	if in.frame == in.globals {
+		return "", nil
+	}
+	for _, name := range args[1:] {
+		name = strings.TrimPrefix(name, "::")
+		g, ok := in.globals.vars[name]
+		if !ok {
+			g = &variable{}
+			in.globals.vars[name] = g
+		}
+		in.frame.vars[name] = &variable{link: g}
+	}
+	return "", nil
+}
+
+func cmdPuts(in *Interp, args []string) (string, error) {
+	args = args[1:]
+	newline := "\n"
+	if len(args) > 0 && args[0] == "-nonewline" {
+		newline = ""
+		args = args[1:]
+	}
+	w := in.Stdout
+	switch len(args) {
+	case 1:
+	case 2:
+		switch args[0] {
+		case "stdout":
+		case "stderr":
+			w = in.Stderr
+		default:
+			return "", errorf("puts to channel %q is not supported by expscript", args[0])
+		}
+		args = args[1:]
+	default:
+		return "", wrongArgs("puts ?-nonewline? ?channelId? string")
+	}
+	_, err := io.WriteString(w, args[0]+newline)
+	return "", err
+}
+
+func cmdGets(in *Interp, args []string) (string, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return "", wrongArgs("gets channelId ?varName?")
+	}
+	if args[1] != "stdin" {
+		return "", errorf("gets from channel %q is not supported by expscript", args[1])
+	}
+	line, err := in.readLine()
+	n := len(line)
+	if err != nil {
+		if err != io.EOF {
+			return "", err
+		}
+		if line == "" {
+			n = -1
+		}
+	}
+	if len(args) == 3 {
+		if _, err := in.setVar(args[2], line); err != nil {
+			return "", err
+		}
+		return strconv.Itoa(n), nil
+	}
+	return line, nil
+}
+
+// readLine reads a line from Stdin without the newline
+func (in *Interp) readLine() (string, error) {
+	br, ok := in.Stdin.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(in.Stdin)
+		in.Stdin = br
+	}
+	line, err := br.ReadString('\n')
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+func cmdExpr(in *Interp, args []string) (string, error) {
+	if len(args) < 2 {
+		return "", wrongArgs("expr arg ?arg ...?")
+	}
+	return in.expr(strings.Join(args[1:], " "))
+}
+
+func cmdIf(in *Interp, args []string) (string, error) {
+	i := 1
+	for {
+		if i >= len(args) {
+			return "", wrongArgs("if expr1 ?then? body1 elseif expr2 ?then? body2 elseif ... ?else? ?bodyN?")
+		}
+		cond, err := in.exprBool(args[i])
+		if err != nil {
+			return "", err
+		}
+		i++
+		if i < len(args) && args[i] == "then" {
+			i++
+		}
+		if i >= len(args) {
+			return "", errorf("wrong # args: no script following %q argument", args[i-1])
+		}
+		if cond {
+			return in.Eval(args[i])
+		}
+		i++
+		if i >= len(args) {
+			return "", nil
+		}
+		switch args[i] {
+		case "elseif":
+			i++
+		case "else":
+			if i+1 >= len(args) {
+				return "", errorf("wrong # args: no script following \"else\" argument")
+			}
+			return in.Eval(args[i+1])
+		default:
+			return in.Eval(args[i])
+		}
+	}
+}
+
+// loopBody runs a loop body and says whether the loop should end. A break
+// or continue is handled here, any other error is returned.
+func (in *Interp) loopBody(body string) (bool, error) {
+	_, err := in.Eval(body)
+	var f *flow
+	if errors.As(err, &f) {
+		switch f.kind {
+		case flowBreak:
+			return true, nil
+		case flowContinue:
+			return false, nil
+		}
+	}
+	return err != nil, err
+}
+
+func cmdWhile(in *Interp, args []string) (string, error) {
+	if len(args) != 3 {
+		return "", wrongArgs("while test command")
+	}
+	for {
+		cond, err := in.exprBool(args[1])
+		if err != nil || !cond {
+			return "", err
+		}
+		if done, err := in.loopBody(args[2]); done {
+			return "", err
+		}
+	}
+}
+
+func cmdFor(in *Interp, args []string) (string, error) {
+	if len(args) != 5 {
+		return "", wrongArgs("for start test next command")
+	}
+	if _, err := in.Eval(args[1]); err != nil {
+		return "", err
+	}
+	for {
+		cond, err := in.exprBool(args[2])
+		if err != nil || !cond {
+			return "", err
+		}
+		if done, err := in.loopBody(args[4]); done {
+			return "", err
+		}
+		if _, err := in.Eval(args[3]); err != nil {
+			return "", err
+		}
+	}
+}
+
+func cmdForeach(in *Interp, args []string) (string, error) {
+	if len(args) != 4 {
+		return "", errorf("foreach with more than one list is not supported by expscript")
+	}
+	vars, err := parseList(args[1])
+	if err != nil {
+		return "", err
+	}
+	if len(vars) == 0 {
+		return "", errorf("foreach varlist is empty")
+	}
+	list, err := parseList(args[2])
+	if err != nil {
+		return "", err
+	}
+	for i := 0; i < len(list); i += len(vars) {
+		for j, name := range vars {
+			val := ""
+			if i+j < len(list) {
+				val = list[i+j]
+			}
+			if _, err := in.setVar(name, val); err != nil {
+				return "", err
+			}
+		}
+		if done, err := in.loopBody(args[3]); done {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+func cmdSwitch(in *Interp, args []string) (string, error) {
+	mode := "-exact"
+	i := 1
+	for ; i < len(args) && strings.HasPrefix(args[i], "-"); i++ {
+		if args[i] == "--" {
+			i++
+			break
+		}
+		switch args[i] {
+		case "-exact", "-glob", "-regexp":
+			mode = args[i]
+		default:
+			return "", errorf("switch option %q is not supported by expscript", args[i])
+		}
+	}
+	if i >= len(args) {
+		return "", wrongArgs("switch ?options? string pattern body ?pattern body ...?")
+	}
+	value := args[i]
+	clauses := args[i+1:]
+	if len(clauses) == 1 {
+		var err error
+		clauses, err = parseList(clauses[0])
+		if err != nil {
+			return "", err
+		}
+	}
+	if len(clauses)%2 != 0 {
+		return "", errorf("extra switch pattern with no body")
+	}
+	for c := 0; c < len(clauses); c += 2 {
+		pattern := clauses[c]
+		matched := false
+		switch {
+		case pattern == "default" && c == len(clauses)-2:
+			matched = true
+		case mode == "-exact":
+			matched = pattern == value
+		case mode == "-glob":
+			matched = globMatch(pattern, value, false)
+		default:
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return "", errorf("couldn't compile regular expression pattern: %s", err)
+			}
+			matched = re.MatchString(value)
+		}
+		if !matched {
+			continue
+		}
+		// A body of - falls through to the next
+		for c+1 < len(clauses) && clauses[c+1] == "-" {
+			c += 2
+		}
+		if c+1 >= len(clauses) {
+			return "", errorf("no body specified for pattern %q", pattern)
+		}
+		return in.Eval(clauses[c+1])
+	}
+	return "", nil
+}
+
+func cmdProc(in *Interp, args []string) (string, error) {
+	if len(args) != 4 {
+		return "", wrongArgs("proc name args body")
+	}
+	params, err := parseList(args[2])
+	if err != nil {
+		return "", err
+	}
+	p := &proc{body: args[3], defaults: map[string]*string{}}
+	for _, param := range params {
+		parts, err := parseList(param)
+		if err != nil {
+			return "", err
+		}
+		switch len(parts) {
+		case 1:
+		case 2:
+			def := parts[1]
+			p.defaults[parts[0]] = &def
+		default:
+			return "", errorf("too many fields in argument specifier %q", param)
+		}
+		p.params = append(p.params, parts[0])
+	}
+	in.procs[strings.TrimPrefix(args[1], "::")] = p
+	return "", nil
+}
+
+func cmdReturn(in *Interp, args []string) (string, error) {
+	switch len(args) {
+	case 1:
+		return "", &flow{kind: flowReturn}
+	case 2:
+		return "", &flow{kind: flowReturn, value: args[1]}
+	}
+	return "", errorf("return options are not supported by expscript")
+}
+
+func cmdBreak(in *Interp, args []string) (string, error) {
+	return "", &flow{kind: flowBreak}
+}
+
+func cmdContinue(in *Interp, args []string) (string, error) {
+	return "", &flow{kind: flowContinue}
+}
+
+func cmdExit(in *Interp, args []string) (string, error) {
+	code := 0
+	if len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			return "", errorf("expected integer but got %q", args[1])
+		}
+		code = n
+	}
+	return "", &ExitError{Code: code}
+}
+
+func cmdError(in *Interp, args []string) (string, error) {
+	if len(args) < 2 {
+		return "", wrongArgs("error message ?info? ?code?")
+	}
+	return "", errorf("%s", args[1])
+}
+
+func cmdCatch(in *Interp, args []string) (string, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return "", wrongArgs("catch script ?resultVarName?")
+	}
+	result, err := in.Eval(args[1])
+	code := 0
+	var f *flow
+	var exit *ExitError
+	switch {
+	case err == nil:
+	case errors.As(err, &exit):
+		// exit is not caught
+		return "", err
+	case errors.As(err, &f):
+		code = map[int]int{flowReturn: 2, flowBreak: 3, flowContinue: 4}[f.kind]
+		if f.kind == flowExpContinue {
+			return "", err
+		}
+		result = f.value
+	default:
+		code = 1
+		result = err.Error()
+	}
+	if len(args) == 3 {
+		if _, err := in.setVar(args[2], result); err != nil {
+			return "", err
+		}
+	}
+	return strconv.Itoa(code), nil
+}
+
+func cmdEval(in *Interp, args []string) (string, error) {
+	if len(args) < 2 {
+		return "", wrongArgs("eval arg ?arg ...?")
+	}
+	return in.Eval(concat(args[1:]))
+}
+
+func cmdSource(in *Interp, args []string) (string, error) {
+	if len(args) != 2 {
+		return "", wrongArgs("source fileName")
+	}
+	script, err := os.ReadFile(args[1])
+	if err != nil {
+		return "", errorf("couldn't read file %q: %s", args[1], err)
+	}
+	return in.Eval(string(script))
+}
+
+func cmdInfo(in *Interp, args []string) (string, error) {
+	if len(args) < 2 {
+		return "", wrongArgs("info subcommand ?arg ...?")
+	}
+	switch args[1] {
+	case "exists":
+		if len(args) != 3 {
+			return "", wrongArgs("info exists varName")
+		}
+		return formatBool(in.varExists(args[2])), nil
+	case "procs":
+		var names []string
+		for name := range in.procs {
+			if len(args) < 3 || globMatch(args[2], name, false) {
+				names = append(names, name)
+			}
+		}
+		return formatList(names), nil
+	case "script":
+		v, _ := in.expectVar("argv0")
+		return v, nil
+	}
+	return "", errorf("info %s is not supported by expscript", args[1])
+}
+
+func cmdArray(in *Interp, args []string) (string, error) {
+	if len(args) < 3 {
+		return "", wrongArgs("array subcommand arrayName ?arg ...?")
+	}
+	name := args[2]
+	switch args[1] {
+	case "exists":
+		v := in.lookup(name, false)
+		return formatBool(v != nil && v.array != nil), nil
+	case "names":
+		return formatList(in.arrayNames(name)), nil
+	case "size":
+		return strconv.Itoa(len(in.arrayNames(name))), nil
+	case "get":
+		var elems []string
+		for _, k := range in.arrayNames(name) {
+			v, _ := in.getVar(name + "(" + k + ")")
+			elems = append(elems, k, v)
+		}
+		return formatList(elems), nil
+	case "set":
+		if len(args) != 4 {
+			return "", wrongArgs("array set arrayName list")
+		}
+		elems, err := parseList(args[3])
+		if err != nil {
+			return "", err
+		}
+		if len(elems)%2 != 0 {
+			return "", errorf("list must have an even number of elements")
+		}
+		v := in.lookup(name, true)
+		if v.array == nil {
+			v.array = map[string]string{}
+		}
+		for i := 0; i < len(elems); i += 2 {
+			v.array[elems[i]] = elems[i+1]
+		}
+		return "", nil
+	case "unset":
+		return "", in.unsetVar(name)
+	}
+	return "", errorf("array %s is not supported by expscript", args[1])
+}
+
+func cmdList(in *Interp, args []string) (string, error) {
+	return formatList(args[1:]), nil
+}
+
+func cmdLlength(in *Interp, args []string) (string, error) {
+	if len(args) != 2 {
+		return "", wrongArgs("llength list")
+	}
+	list, err := parseList(args[1])
+	return strconv.Itoa(len(list)), err
+}
+
+// listIndex converts a list index, which may be "end" or "end-N"
+func listIndex(s string, length int) (int, error) {
+	if s == "end" {
+		return length - 1, nil
+	}
+	if strings.HasPrefix(s, "end-") {
+		n, err := strconv.Atoi(s[4:])
+		if err != nil {
+			return 0, errorf("bad index %q", s)
+		}
+		return length - 1 - n, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, errorf("bad index %q: must be integer?[+-]integer? or end?[+-]integer?", s)
+	}
+	return n, nil
+}
+
+func cmdLindex(in *Interp, args []string) (string, error) {
+	if len(args) < 2 {
+		return "", wrongArgs("lindex list ?index ...?")
+	}
+	v := args[1]
+	for _, idx := range args[2:] {
+		list, err := parseList(v)
+		if err != nil {
+			return "", err
+		}
+		i, err := listIndex(idx, len(list))
+		if err != nil {
+			return "", err
+		}
+		if i < 0 || i >= len(list) {
+			return "", nil
+		}
+		v = list[i]
+	}
+	return v, nil
+}
+
+func cmdLrange(in *Interp, args []string) (string, error) {
+	if len(args) != 4 {
+		return "", wrongArgs("lrange list first last")
+	}
+	list, err := parseList(args[1])
+	if err != nil {
+		return "", err
+	}
+	first, err := listIndex(args[2], len(list))
+	if err != nil {
+		return "", err
+	}
+	last, err := listIndex(args[3], len(list))
+	if err != nil {
+		return "", err
+	}
+	if first < 0 {
+		first = 0
+	}
+	if last >= len(list) {
+		last = len(list) - 1
+	}
+	if first > last {
+		return "", nil
+	}
+	return formatList(list[first : last+1]), nil
+}
+
+func cmdLappend(in *Interp, args []string) (string, error) {
+	if len(args) < 2 {
+		return "", wrongArgs("lappend varName ?value ...?")
+	}
+	var list []string
+	if in.varExists(args[1]) {
+		v, err := in.getVar(args[1])
+		if err != nil {
+			return "", err
+		}
+		if list, err = parseList(v); err != nil {
+			return "", err
+		}
+	}
+	return in.setVar(args[1], formatList(append(list, args[2:]...)))
+}
+
+func cmdLsearch(in *Interp, args []string) (string, error) {
+	mode := "-glob"
+	i := 1
+	for ; i < len(args)-2; i++ {
+		switch args[i] {
+		case "-exact", "-glob", "-regexp":
+			mode = args[i]
+		default:
+			return "", errorf("lsearch option %q is not supported by expscript", args[i])
+		}
+	}
+	if len(args)-i != 2 {
+		return "", wrongArgs("lsearch ?options? list pattern")
+	}
+	list, err := parseList(args[i])
+	if err != nil {
+		return "", err
+	}
+	pattern := args[i+1]
+	for n, elem := range list {
+		var found bool
+		switch mode {
+		case "-exact":
+			found = elem == pattern
+		case "-glob":
+			found = globMatch(pattern, elem, false)
+		default:
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return "", errorf("couldn't compile regular expression pattern: %s", err)
+			}
+			found = re.MatchString(elem)
+		}
+		if found {
+			return strconv.Itoa(n), nil
+		}
+	}
+	return "-1", nil
+}
+
+func cmdJoin(in *Interp, args []string) (string, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return "", wrongArgs("join list ?joinString?")
+	}
+	list, err := parseList(args[1])
+	if err != nil {
+		return "", err
+	}
+	sep := " "
+	if len(args) == 3 {
+		sep = args[2]
+	}
+	return strings.Join(list, sep), nil
+}
+
+func cmdSplit(in *Interp, args []string) (string, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return "", wrongArgs("split string ?splitChars?")
+	}
+	chars := " \t\n\r"
+	if len(args) == 3 {
+		chars = args[2]
+	}
+	if chars == "" {
+		var elems []string
+		for _, r := range args[1] {
+			elems = append(elems, string(r))
+		}
+		return formatList(elems), nil
+	}
+	var elems []string
+	start := 0
+	for i, r := range args[1] {
+		if strings.ContainsRune(chars, r) {
+			elems = append(elems, args[1][start:i])
+			start = i + len(string(r))
+		}
+	}
+	elems = append(elems, args[1][start:])
+	return formatList(elems), nil
+}
+
+// concat joins args as the concat command does, trimming each
+func concat(args []string) string {
+	var parts []string
+	for _, a := range args {
+		if a = strings.TrimSpace(a); a != "" {
+			parts = append(parts, a)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func cmdConcat(in *Interp, args []string) (string, error) {
+	return concat(args[1:]), nil
+}
+
+func cmdString(in *Interp, args []string) (string, error) {
+	if len(args) < 3 {
+		return "", wrongArgs("string subcommand ?arg ...?")
+	}
+	s := args[2]
+	switch args[1] {
+	case "length":
+		return strconv.Itoa(len([]rune(s))), nil
+	case "tolower":
+		return strings.ToLower(s), nil
+	case "toupper":
+		return strings.ToUpper(s), nil
+	case "trim", "trimleft", "trimright":
+		cut := " \t\n\r"
+		if len(args) > 3 {
+			cut = args[3]
+		}
+		switch args[1] {
+		case "trimleft":
+			return strings.TrimLeft(s, cut), nil
+		case "trimright":
+			return strings.TrimRight(s, cut), nil
+		}
+		return strings.Trim(s, cut), nil
+	case "equal", "compare":
+		nocase := false
+		rest := args[2:]
+		if len(rest) == 3 && rest[0] == "-nocase" {
+			nocase = true
+			rest = rest[1:]
+		}
+		if len(rest) != 2 {
+			return "", wrongArgs("string " + args[1] + " ?-nocase? string1 string2")
+		}
+		a, b := rest[0], rest[1]
+		if nocase {
+			a, b = strings.ToLower(a), strings.ToLower(b)
+		}
+		if args[1] == "equal" {
+			return formatBool(a == b), nil
+		}
+		return strconv.Itoa(strings.Compare(a, b)), nil
+	case "match":
+		nocase := false
+		rest := args[2:]
+		if len(rest) == 3 && rest[0] == "-nocase" {
+			nocase = true
+			rest = rest[1:]
+		}
+		if len(rest) != 2 {
+			return "", wrongArgs("string match ?-nocase? pattern string")
+		}
+		return formatBool(globMatch(rest[0], rest[1], nocase)), nil
+	case "first", "last":
+		if len(args) != 4 {
+			return "", wrongArgs("string " + args[1] + " needleString haystackString")
+		}
+		i := strings.Index(args[3], s)
+		if args[1] == "last" {
+			i = strings.LastIndex(args[3], s)
+		}
+		if i >= 0 {
+			i = len([]rune(args[3][:i]))
+		}
+		return strconv.Itoa(i), nil
+	case "index", "range":
+		r := []rune(s)
+		first, err := listIndex(args[3], len(r))
+		if err != nil {
+			return "", err
+		}
+		if args[1] == "index" {
+			if first < 0 || first >= len(r) {
+				return "", nil
+			}
+			return string(r[first]), nil
+		}
+		if len(args) != 5 {
+			return "", wrongArgs("string range string first last")
+		}
+		last, err := listIndex(args[4], len(r))
+		if err != nil {
+			return "", err
+		}
+		if first < 0 {
+			first = 0
+		}
+		if last >= len(r) {
+			last = len(r) - 1
+		}
+		if first > last {
+			return "", nil
+		}
+		return string(r[first : last+1]), nil
+	case "repeat":
+		if len(args) != 4 {
+			return "", wrongArgs("string repeat string count")
+		}
+		n, err := strconv.Atoi(args[3])
+		if err != nil || n < 0 {
+			return "", errorf("expected integer but got %q", args[3])
+		}
+		return strings.Repeat(s, n), nil
+	case "map":
+		if len(args) != 4 {
+			return "", errorf("string map options are not supported by expscript")
+		}
+		pairs, err := parseList(args[2])
+		if err != nil {
+			return "", err
+		}
+		if len(pairs)%2 != 0 {
+			return "", errorf("char map list unbalanced")
+		}
+		return strings.NewReplacer(pairs...).Replace(args[3]), nil
+	}
+	return "", errorf("string %s is not supported by expscript", args[1])
+}
+
+// regexpOptions reads the leading options of regexp and regsub
+func regexpOptions(args []string, allowed ...string) (map[string]bool, []string, error) {
+	opts := map[string]bool{}
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		if args[0] == "--" {
+			return opts, args[1:], nil
+		}
+		ok := false
+		for _, a := range allowed {
+			ok = ok || args[0] == a
+		}
+		if !ok {
+			return nil, nil, errorf("option %q is not supported by expscript", args[0])
+		}
+		opts[args[0]] = true
+		args = args[1:]
+	}
+	return opts, args, nil
+}
+
+// compileRegexp compiles a Tcl regexp with Go's syntax, which covers the
+// usual uses
+func compileRegexp(pattern string, nocase bool) (*regexp.Regexp, error) {
+	if nocase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, errorf("couldn't compile regular expression pattern: %s", err)
+	}
+	return re, nil
+}
+
+func cmdRegexp(in *Interp, args []string) (string, error) {
+	opts, rest, err := regexpOptions(args[1:], "-nocase")
+	if err != nil {
+		return "", err
+	}
+	if len(rest) < 2 {
+		return "", wrongArgs("regexp ?-nocase? exp string ?matchVar? ?subMatchVar ...?")
+	}
+	re, err := compileRegexp(rest[0], opts["-nocase"])
+	if err != nil {
+		return "", err
+	}
+	m := re.FindStringSubmatch(rest[1])
+	if m == nil {
+		return "0", nil
+	}
+	for i, name := range rest[2:] {
+		val := ""
+		if i < len(m) {
+			val = m[i]
+		}
+		if _, err := in.setVar(name, val); err != nil {
+			return "", err
+		}
+	}
+	return "1", nil
+}
+
+func cmdRegsub(in *Interp, args []string) (string, error) {
+	opts, rest, err := regexpOptions(args[1:], "-nocase", "-all")
+	if err != nil {
+		return "", err
+	}
+	if len(rest) != 3 && len(rest) != 4 {
+		return "", wrongArgs("regsub ?-all? ?-nocase? exp string subSpec ?varName?")
+	}
+	re, err := compileRegexp(rest[0], opts["-nocase"])
+	if err != nil {
+		return "", err
+	}
+	// Tcl's & and \N become Go's ${0} and ${N}
+	var spec strings.Builder
+	for i := 0; i < len(rest[2]); i++ {
+		c := rest[2][i]
+		switch {
+		case c == '&':
+			spec.WriteString("${0}")
+		case c == '\\' && i+1 < len(rest[2]) && isDigit(rest[2][i+1]):
+			spec.WriteString("${" + rest[2][i+1:i+2] + "}")
+			i++
+		case c == '\\' && i+1 < len(rest[2]):
+			spec.WriteByte(rest[2][i+1])
+			i++
+		case c == '$':
+			spec.WriteString("$$")
+		default:
+			spec.WriteByte(c)
+		}
+	}
+	limit := 1
+	if opts["-all"] {
+		limit = -1
+	}
+	matches := re.FindAllStringSubmatchIndex(rest[1], limit)
+	var out []byte
+	last := 0
+	for _, m := range matches {
+		out = append(out, rest[1][last:m[0]]...)
+		out = re.ExpandString(out, spec.String(), rest[1], m)
+		last = m[1]
+	}
+	result := string(append(out, rest[1][last:]...))
+	if len(rest) == 4 {
+		if _, err := in.setVar(rest[3], result); err != nil {
+			return "", err
+		}
+		return strconv.Itoa(len(matches)), nil
+	}
+	return result, nil
+}
+
+func cmdFormat(in *Interp, args []string) (string, error) {
+	if len(args) < 2 {
+		return "", wrongArgs("format formatString ?arg ...?")
+	}
+	var vals []interface{}
+	f := args[1]
+	n := 0
+	for i := 0; i < len(f); i++ {
+		if f[i] != '%' {
+			continue
+		}
+		j := i + 1
+		for j < len(f) && strings.IndexByte("-+ #0123456789.", f[j]) >= 0 {
+			j++
+		}
+		if j >= len(f) {
+			return "", errorf("format string ended in middle of field specifier")
+		}
+		verb := f[j]
+		i = j
+		if verb == '%' {
+			continue
+		}
+		if 2+n >= len(args) {
+			return "", errorf("not enough arguments for all format specifiers")
+		}
+		arg := args[2+n]
+		n++
+		switch verb {
+		case 'd', 'i', 'x', 'X', 'o', 'c':
+			num, ok := parseNumber(arg)
+			if !ok {
+				return "", errorf("expected integer but got %q", arg)
+			}
+			vals = append(vals, int64(num.float()))
+		case 'f', 'e', 'E', 'g', 'G':
+			num, ok := parseNumber(arg)
+			if !ok {
+				return "", errorf("expected floating-point number but got %q", arg)
+			}
+			vals = append(vals, num.float())
+		case 's':
+			vals = append(vals, arg)
+		default:
+			return "", errorf("format %%%c is not supported by expscript", verb)
+		}
+	}
+	return fmt.Sprintf(strings.ReplaceAll(f, "%i", "%d"), vals...), nil
+}
+
+func cmdAfter(in *Interp, args []string) (string, error) {
+	if len(args) != 2 {
+		return "", errorf("after with a script is not supported by expscript")
+	}
+	ms, err := strconv.Atoi(args[1])
+	if err != nil {
+		return "", errorf("expected integer but got %q", args[1])
+	}
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+	return "", nil
+}
+
+func cmdClock(in *Interp, args []string) (string, error) {
+	if len(args) < 2 {
+		return "", wrongArgs("clock subcommand ?arg ...?")
+	}
+	switch args[1] {
+	case "seconds":
+		return strconv.FormatInt(time.Now().Unix(), 10), nil
+	case "milliseconds":
+		return strconv.FormatInt(time.Now().UnixMilli(), 10), nil
+	}
+	return "", errorf("clock %s is not supported by expscript", args[1])
+}
+
+func cmdFile(in *Interp, args []string) (string, error) {
+	if len(args) != 3 {
+		return "", errorf("file %s is not supported by expscript", strings.Join(args[1:], " "))
+	}
+	switch args[1] {
+	case "exists":
+		_, err := os.Stat(args[2])
+		return formatBool(err == nil), nil
+	case "tail":
+		return path.Base(args[2]), nil
+	case "dirname":
+		return path.Dir(args[2]), nil
+	}
+	return "", errorf("file %s is not supported by expscript", args[1])
+}
+
+func cmdPackage(in *Interp, args []string) (string, error) {
+	if len(args) >= 3 && args[1] == "require" {
+		switch args[len(args)-1] {
+		case "Expect":
+			return "5.45", nil
+		case "Tcl":
+			return "8.6", nil
+		}
+		return "", errorf("package %q is not available in expscript", args[len(args)-1])
+	}
+	return "", errorf("package %s is not supported by expscript", strings.Join(args[1:], " "))
+}
+
+// globMatch matches s against a Tcl glob pattern: * ? [chars] and \x
+func globMatch(pattern, s string, nocase bool) bool {
+	if nocase {
+		pattern, s = strings.ToLower(pattern), strings.ToLower(s)
+	}
+	re, err := regexp.Compile("^(?s:" + globToRegexp(pattern) + ")$")
+	if err != nil {
+		return false
+	}
+	return re.MatchString(s)
+}
+
+// globToRegexp converts a glob pattern to an unanchored regexp
+func globToRegexp(pattern string) string {
+	var sb strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				sb.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+			}
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				sb.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			sb.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			_, size := utf8.DecodeRuneInString(pattern[i:])
+			sb.WriteString(regexp.QuoteMeta(pattern[i : i+size]))
+			i += size - 1
+		}
+	}
+	return sb.String()
+}
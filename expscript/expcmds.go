@@ -0,0 +1,597 @@
+/*
+File summary: The expect commands: spawn, expect, send, interact and friends
+Package: expscript
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expscript
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/leemcloughlin/expect"
+)
+
+// expectCommands are the commands expect adds to Tcl
+var expectCommands map[string]command
+
+func init() {
+	expectCommands = map[string]command{
+		"close":        cmdClose,
+		"exp_continue": cmdExpContinue,
+		"exp_internal": cmdExpInternal,
+		"exp_pid":      cmdExpPid,
+		"exp_send":     cmdSend,
+		"expect":       cmdExpect,
+		"interact":     cmdInteract,
+		"log_user":     cmdLogUser,
+		"match_max":    cmdMatchMax,
+		"send":         cmdSend,
+		"send_error":   cmdSendUser,
+		"send_user":    cmdSendUser,
+		"sleep":        cmdSleep,
+		"spawn":        cmdSpawn,
+		"wait":         cmdWait,
+	}
+}
+
+// SpawnShutdownTimeout is how long Run() waits for each process the script
+// left running to end once the script has finished
+var SpawnShutdownTimeout = 5 * time.Second
+
+// closeAll ends every spawned process and reaps it
+func (in *Interp) closeAll() {
+	for id, sp := range in.spawns {
+		in.shutdown(sp)
+		sp.exp.Wait()
+		delete(in.spawns, id)
+	}
+}
+
+// shutdown ends sp, stopping its reader before its pty is closed, unless
+// close already has
+func (in *Interp) shutdown(sp *spawned) error {
+	if sp.closed {
+		return nil
+	}
+	sp.closed = true
+	ctx, cancel := context.WithTimeout(context.Background(), SpawnShutdownTimeout)
+	defer cancel()
+	sp.exp.SetShutdownGrace(SpawnShutdownTimeout / 4)
+	return sp.exp.Shutdown(ctx)
+}
+
+// spawnOption takes a leading "-i spawn_id" from args and returns the
+// process it names, or the current one from the spawn_id variable
+func (in *Interp) spawnOption(args []string) (*spawned, []string, error) {
+	id := ""
+	if len(args) >= 2 && args[0] == "-i" {
+		id = args[1]
+		args = args[2:]
+	} else {
+		v, ok := in.expectVar("spawn_id")
+		if !ok {
+			return nil, args, errorf("no spawned process (spawn_id not set)")
+		}
+		id = v
+	}
+	sp, ok := in.spawns[id]
+	if !ok || sp.closed {
+		return nil, args, errorf("spawn id %q not open", id)
+	}
+	return sp, args, nil
+}
+
+func cmdSpawn(in *Interp, args []string) (string, error) {
+	args = args[1:]
+	noecho := false
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		switch args[0] {
+		case "-noecho":
+			noecho = true
+		case "--":
+			args = args[1:]
+			goto done
+		default:
+			return "", errorf("spawn option %q is not supported by expscript", args[0])
+		}
+		args = args[1:]
+	}
+done:
+	if len(args) == 0 {
+		return "", wrongArgs("spawn ?-noecho? program ?args ...?")
+	}
+
+	exp, cmd, err := expect.NewExpectProc(args[0], args[1:]...)
+	if err != nil {
+		return "", errorf("couldn't execute %q: %s", args[0], err)
+	}
+	if in.logUser {
+		exp.SetCmdOut(in.Stdout)
+	}
+	if !noecho && in.logUser {
+		fmt.Fprintf(in.Stdout, "spawn %s\r\n", strings.Join(args, " "))
+	}
+
+	in.spawnNext++
+	id := fmt.Sprintf("exp%d", in.spawnNext+3)
+	in.spawns[id] = &spawned{exp: exp, pid: cmd.Process.Pid}
+	if _, err := in.setVar("spawn_id", id); err != nil {
+		return "", err
+	}
+	return strconv.Itoa(cmd.Process.Pid), nil
+}
+
+func cmdSend(in *Interp, args []string) (string, error) {
+	sp, rest, err := in.spawnOption(args[1:])
+	if err != nil {
+		return "", err
+	}
+	slow, human := false, false
+	for len(rest) > 1 && strings.HasPrefix(rest[0], "-") {
+		switch rest[0] {
+		case "-s":
+			slow = true
+		case "-h":
+			human = true
+		case "--":
+		default:
+			return "", errorf("send option %q is not supported by expscript", rest[0])
+		}
+		done := rest[0] == "--"
+		rest = rest[1:]
+		if done {
+			break
+		}
+	}
+	if len(rest) != 1 {
+		return "", wrongArgs(args[0] + " ?-i spawn_id? ?-s? ?-h? ?--? string")
+	}
+	s := rest[0]
+
+	switch {
+	case human:
+		h, err := in.sendHuman()
+		if err != nil {
+			return "", err
+		}
+		_, err = sp.exp.SendHuman(h, s)
+		return "", err
+	case slow:
+		delay, err := in.sendSlow()
+		if err != nil {
+			return "", err
+		}
+		_, err = sp.exp.SendSlow(delay, s)
+		return "", err
+	}
+	_, err = sp.exp.Send(s)
+	return "", err
+}
+
+// sendSlow returns the delay per character from the send_slow variable,
+// a list of the number of characters and the seconds between each batch
+func (in *Interp) sendSlow() (time.Duration, error) {
+	v, _ := in.expectVar("send_slow")
+	parts, err := parseList(v)
+	if err != nil || len(parts) != 2 {
+		return 0, errorf("send -s needs send_slow set to {count seconds}")
+	}
+	n, err1 := strconv.Atoi(parts[0])
+	secs, err2 := strconv.ParseFloat(parts[1], 64)
+	if err1 != nil || err2 != nil || n <= 0 {
+		return 0, errorf("bad send_slow %q", v)
+	}
+	return time.Duration(secs / float64(n) * float64(time.Second)), nil
+}
+
+// sendHuman returns the timing from the send_human variable, a list of
+// average, word end, variability, min and max as for expect.HumanTiming
+func (in *Interp) sendHuman() (*expect.HumanTiming, error) {
+	v, _ := in.expectVar("send_human")
+	parts, err := parseList(v)
+	if err != nil || len(parts) != 5 {
+		return nil, errorf("send -h needs send_human set to {average wordend variability min max}")
+	}
+	var f [5]float64
+	for i, p := range parts {
+		if f[i], err = strconv.ParseFloat(p, 64); err != nil {
+			return nil, errorf("bad send_human %q", v)
+		}
+	}
+	secs := func(s float64) time.Duration { return time.Duration(s * float64(time.Second)) }
+	return &expect.HumanTiming{
+		Average:     secs(f[0]),
+		WordEnd:     secs(f[1]),
+		Variability: f[2],
+		Min:         secs(f[3]),
+		Max:         secs(f[4]),
+	}, nil
+}
+
+func cmdSendUser(in *Interp, args []string) (string, error) {
+	rest := args[1:]
+	if len(rest) == 2 && rest[0] == "--" {
+		rest = rest[1:]
+	}
+	if len(rest) != 1 {
+		return "", wrongArgs(args[0] + " ?--? string")
+	}
+	w := in.Stdout
+	if args[0] == "send_error" {
+		w = in.Stderr
+	}
+	_, err := io.WriteString(w, rest[0])
+	return "", err
+}
+
+// clause is one pattern and body of an expect command
+type clause struct {
+	// pattern is a *regexp.Regexp, from expectPattern, or an
+	// expect.Pseudo for timeout and eof
+	pattern interface{}
+
+	// isDefault clauses match both timeout and eof
+	isDefault bool
+
+	body string
+}
+
+// expectPattern compiles an expect pattern into a regexp
+func expectPattern(kind, pattern string, nocase bool) (*regexp.Regexp, error) {
+	switch kind {
+	case "-ex":
+		pattern = regexp.QuoteMeta(pattern)
+	case "-gl":
+		anchorStart := strings.HasPrefix(pattern, "^")
+		anchorEnd := strings.HasSuffix(pattern, "$") && !strings.HasSuffix(pattern, `\$`)
+		if anchorStart {
+			pattern = pattern[1:]
+		}
+		if anchorEnd {
+			pattern = pattern[:len(pattern)-1]
+		}
+		pattern = "(?s:" + globToRegexp(pattern) + ")"
+		if anchorStart {
+			pattern = "^" + pattern
+		}
+		if anchorEnd {
+			pattern += "$"
+		}
+	}
+	if nocase {
+		pattern = "(?i:" + pattern + ")"
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, errorf("couldn't compile regular expression pattern: %s", err)
+	}
+	return re, nil
+}
+
+// parseClauses turns the arguments of expect into clauses. It returns the
+// -i and -timeout options too.
+func parseClauses(args []string) ([]clause, string, string, error) {
+	if len(args) == 1 && strings.Contains(args[0], "\n") {
+		list, err := parseList(args[0])
+		if err != nil {
+			return nil, "", "", err
+		}
+		args = list
+	}
+
+	var clauses []clause
+	id, timeout := "", ""
+	kind, nocase := "-gl", false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		var c clause
+		switch arg {
+		case "-re", "-ex", "-gl":
+			kind = arg
+			continue
+		case "-nocase":
+			nocase = true
+			continue
+		case "-i", "-timeout":
+			if i+1 >= len(args) {
+				return nil, "", "", errorf("expect %s needs a value", arg)
+			}
+			i++
+			if arg == "-i" {
+				id = args[i]
+			} else {
+				timeout = args[i]
+			}
+			continue
+		case "--":
+			if i+1 >= len(args) {
+				return nil, "", "", errorf("expect -- needs a pattern")
+			}
+			i++
+			arg = args[i]
+		case "timeout":
+			c.pattern = expect.Timeout
+		case "eof":
+			c.pattern = expect.EndOfFile
+		case "default":
+			c.isDefault = true
+		case "full_buffer", "null":
+			return nil, "", "", errorf("expect %s is not supported by expscript", arg)
+		default:
+			if strings.HasPrefix(arg, "-") && kind == "-gl" && len(arg) > 1 && !strings.ContainsAny(arg, " *?") {
+				return nil, "", "", errorf("expect option %q is not supported by expscript", arg)
+			}
+		}
+		if c.pattern == nil && !c.isDefault {
+			re, err := expectPattern(kind, arg, nocase)
+			if err != nil {
+				return nil, "", "", err
+			}
+			c.pattern = re
+		}
+		if i+1 < len(args) {
+			i++
+			c.body = args[i]
+		}
+		clauses = append(clauses, c)
+		kind, nocase = "-gl", false
+	}
+	return clauses, id, timeout, nil
+}
+
+func cmdExpect(in *Interp, args []string) (string, error) {
+	clauses, id, timeoutOpt, err := parseClauses(args[1:])
+	if err != nil {
+		return "", err
+	}
+	var sp *spawned
+	if id != "" {
+		sp, _, err = in.spawnOption([]string{"-i", id})
+	} else {
+		sp, _, err = in.spawnOption(nil)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if timeoutOpt == "" {
+		timeoutOpt, _ = in.expectVar("timeout")
+	}
+	secs, err := strconv.ParseFloat(timeoutOpt, 64)
+	if err != nil {
+		return "", errorf("expected number for timeout but got %q", timeoutOpt)
+	}
+	switch {
+	case secs < 0:
+		sp.exp.SetTimeout(0) // forever
+	case secs == 0:
+		sp.exp.SetTimeout(time.Millisecond)
+	default:
+		sp.exp.SetTimeout(time.Duration(secs * float64(time.Second)))
+	}
+
+	// The clause patterns followed by timeout and eof which are always
+	// looked for so they can be handled even without a clause
+	var pats []interface{}
+	never := regexp.MustCompile(`$.^`)
+	for _, c := range clauses {
+		if re, ok := c.pattern.(*regexp.Regexp); ok {
+			pats = append(pats, re)
+		} else {
+			// timeout, eof and default are handled below
+			pats = append(pats, never)
+		}
+	}
+	timeoutIndex := len(pats)
+	eofIndex := timeoutIndex + 1
+	pats = append(pats, expect.Timeout, expect.EndOfFile)
+
+	for {
+		n, before, found, err := sp.exp.ExpectBefore(pats...)
+		if n < 0 {
+			if err == nil {
+				return "", nil
+			}
+			return "", errorf("expect failed: %s", err)
+		}
+
+		var body *clause
+		switch n {
+		case timeoutIndex, eofIndex:
+			if n == eofIndex {
+				in.setVar("expect_out(buffer)", string(found))
+			}
+			for i := range clauses {
+				if clauses[i].isDefault || (n == timeoutIndex && clauses[i].pattern == expect.Timeout) ||
+					(n == eofIndex && clauses[i].pattern == expect.EndOfFile) {
+					body = &clauses[i]
+					break
+				}
+			}
+		default:
+			body = &clauses[n]
+			in.setExpectOut(body.pattern.(*regexp.Regexp), before, found)
+		}
+		if body == nil {
+			return "", nil
+		}
+
+		result, err := in.Eval(body.body)
+		var f *flow
+		if errors.As(err, &f) && f.kind == flowExpContinue {
+			continue
+		}
+		return result, err
+	}
+}
+
+// setExpectOut fills in the expect_out array for a match of re, found, and
+// the input before it
+func (in *Interp) setExpectOut(re *regexp.Regexp, before, found []byte) {
+	in.setVar("expect_out(buffer)", string(before)+string(found))
+	in.setVar("expect_out(0,string)", string(found))
+	m := re.FindSubmatchIndex(found)
+	for g := 1; 2*g+1 < len(m); g++ {
+		val := ""
+		if m[2*g] >= 0 {
+			val = string(found[m[2*g]:m[2*g+1]])
+		}
+		in.setVar(fmt.Sprintf("expect_out(%d,string)", g), val)
+	}
+	if sp, ok := in.expectVar("spawn_id"); ok {
+		in.setVar("expect_out(spawn_id)", sp)
+	}
+}
+
+func cmdExpContinue(in *Interp, args []string) (string, error) {
+	if len(args) > 1 {
+		return "", errorf("exp_continue options are not supported by expscript")
+	}
+	return "", &flow{kind: flowExpContinue}
+}
+
+func cmdInteract(in *Interp, args []string) (string, error) {
+	sp, rest, err := in.spawnOption(args[1:])
+	if err != nil {
+		return "", err
+	}
+	if len(rest) > 0 {
+		return "", errorf("interact patterns and options are not supported by expscript")
+	}
+
+	// With log_user on the output is already copied to Stdout as it is read
+	out := in.Stdout
+	if in.logUser {
+		out = io.Discard
+	}
+	if err := sp.exp.Interact(context.Background(), in.Stdin, out); err != nil {
+		return "", errorf("interact failed: %s", err)
+	}
+	return "", nil
+}
+
+func cmdClose(in *Interp, args []string) (string, error) {
+	sp, _, err := in.spawnOption(args[1:])
+	if err != nil {
+		return "", err
+	}
+	return "", in.shutdown(sp)
+}
+
+func cmdWait(in *Interp, args []string) (string, error) {
+	rest := args[1:]
+	id := ""
+	if len(rest) >= 2 && rest[0] == "-i" {
+		id = rest[1]
+	} else {
+		id, _ = in.expectVar("spawn_id")
+	}
+	sp, ok := in.spawns[id]
+	if !ok {
+		return "", errorf("spawn id %q not open", id)
+	}
+	sp.exp.Wait()
+	delete(in.spawns, id)
+	in.shutdown(sp)
+
+	result := []string{strconv.Itoa(sp.pid), id, "0"}
+	if sig, ok := sp.exp.ExitSignal(); ok {
+		result = append(result, "0", "CHILDKILLED", signalName(sig), sig.String())
+	} else {
+		result = append(result, strconv.Itoa(sp.exp.ExitCode()))
+	}
+	return formatList(result), nil
+}
+
+// signalName returns the SIG name Tcl uses for sig
+func signalName(sig interface{}) string {
+	names := map[syscall.Signal]string{
+		syscall.SIGHUP: "SIGHUP", syscall.SIGINT: "SIGINT", syscall.SIGQUIT: "SIGQUIT",
+		syscall.SIGKILL: "SIGKILL", syscall.SIGTERM: "SIGTERM", syscall.SIGPIPE: "SIGPIPE",
+		syscall.SIGSEGV: "SIGSEGV", syscall.SIGABRT: "SIGABRT", syscall.SIGALRM: "SIGALRM",
+	}
+	if s, ok := sig.(syscall.Signal); ok {
+		if name, ok := names[s]; ok {
+			return name
+		}
+		return fmt.Sprintf("SIG%d", int(s))
+	}
+	return fmt.Sprint(sig)
+}
+
+func cmdLogUser(in *Interp, args []string) (string, error) {
+	switch len(args) {
+	case 1:
+		return formatBool(in.logUser), nil
+	case 2:
+		on, err := toBool(args[1])
+		if err != nil {
+			return "", err
+		}
+		in.logUser = on
+		for _, sp := range in.spawns {
+			if on {
+				sp.exp.SetCmdOut(in.Stdout)
+			} else {
+				sp.exp.SetCmdOut(nil)
+			}
+		}
+		return "", nil
+	}
+	return "", errorf("log_user options are not supported by expscript")
+}
+
+func cmdMatchMax(in *Interp, args []string) (string, error) {
+	sp, rest, err := in.spawnOption(args[1:])
+	if err != nil {
+		return "", err
+	}
+	switch len(rest) {
+	case 0:
+		return strconv.Itoa(sp.exp.MatchMax()), nil
+	case 1:
+		n, err := strconv.Atoi(rest[0])
+		if err != nil {
+			return "", errorf("match_max -d and other options are not supported by expscript")
+		}
+		sp.exp.SetMatchMax(n)
+		return "", nil
+	}
+	return "", wrongArgs("match_max ?-i spawn_id? ?size?")
+}
+
+func cmdExpInternal(in *Interp, args []string) (string, error) {
+	return "", nil
+}
+
+func cmdExpPid(in *Interp, args []string) (string, error) {
+	sp, _, err := in.spawnOption(args[1:])
+	if err != nil {
+		return "", err
+	}
+	return strconv.Itoa(sp.pid), nil
+}
+
+func cmdSleep(in *Interp, args []string) (string, error) {
+	if len(args) != 2 {
+		return "", wrongArgs("sleep seconds")
+	}
+	secs, err := strconv.ParseFloat(args[1], 64)
+	if err != nil {
+		return "", errorf("expected number but got %q", args[1])
+	}
+	time.Sleep(time.Duration(secs * float64(time.Second)))
+	return "", nil
+}
@@ -0,0 +1,671 @@
+/*
+File summary: Tcl expressions for expr, if, while and for
+Package: expscript
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expscript
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// exprNode is a parsed expression. Operands are only substituted when the
+// node is evaluated so && || and ?: do not run the side they skip.
+type exprNode interface {
+	eval(in *Interp) (string, error)
+}
+
+type exprLiteral string
+
+type exprSubst struct {
+	// src is a $variable, [command] or "quoted string" to substitute
+	src string
+}
+
+type exprUnary struct {
+	op string
+	x  exprNode
+}
+
+type exprBinary struct {
+	op   string
+	x, y exprNode
+}
+
+type exprTernary struct {
+	cond, x, y exprNode
+}
+
+type exprCall struct {
+	fn   string
+	args []exprNode
+}
+
+// expr evaluates the expression s
+func (in *Interp) expr(s string) (string, error) {
+	p := &exprParser{src: s}
+	node, err := p.parse(0)
+	if err != nil {
+		return "", err
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return "", errorf("syntax error in expression %q: unexpected %q", s, p.src[p.pos:])
+	}
+	return node.eval(in)
+}
+
+// exprBool evaluates the expression s as a condition
+func (in *Interp) exprBool(s string) (bool, error) {
+	v, err := in.expr(s)
+	if err != nil {
+		return false, err
+	}
+	return toBool(v)
+}
+
+type exprParser struct {
+	src string
+	pos int
+}
+
+// binaryPrec is the precedence of each binary operator, higher binds tighter
+var binaryPrec = map[string]int{
+	"||": 1, "&&": 2, "|": 3, "^": 4, "&": 5,
+	"==": 6, "!=": 6, "eq": 6, "ne": 6, "in": 6, "ni": 6,
+	"<": 7, ">": 7, "<=": 7, ">=": 7,
+	"<<": 8, ">>": 8,
+	"+": 9, "-": 9,
+	"*": 10, "/": 10, "%": 10,
+	"**": 11,
+}
+
+// parse parses operators with precedence of at least minPrec
+func (p *exprParser) parse(minPrec int) (exprNode, error) {
+	x, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		p.skipSpace()
+		if minPrec == 0 && p.peek("?") {
+			p.pos++
+			y, err := p.parse(0)
+			if err != nil {
+				return nil, err
+			}
+			p.skipSpace()
+			if !p.peek(":") {
+				return nil, errorf("syntax error in expression %q: missing : after ?", p.src)
+			}
+			p.pos++
+			z, err := p.parse(0)
+			if err != nil {
+				return nil, err
+			}
+			x = &exprTernary{cond: x, x: y, y: z}
+			continue
+		}
+		op := p.binaryOp()
+		prec, ok := binaryPrec[op]
+		if !ok || prec < minPrec {
+			return x, nil
+		}
+		p.pos += len(op)
+		next := prec + 1
+		if op == "**" {
+			next = prec // right associative
+		}
+		y, err := p.parse(next)
+		if err != nil {
+			return nil, err
+		}
+		x = &exprBinary{op: op, x: x, y: y}
+	}
+}
+
+// binaryOp returns the binary operator at pos, if any
+func (p *exprParser) binaryOp() string {
+	for _, op := range []string{"**", "||", "&&", "==", "!=", "<=", ">=", "<<", ">>"} {
+		if p.peek(op) {
+			return op
+		}
+	}
+	for _, op := range []string{"eq", "ne", "in", "ni"} {
+		if p.peek(op) && (p.pos+2 >= len(p.src) || !isNameChar(p.src[p.pos+2])) {
+			return op
+		}
+	}
+	if p.pos < len(p.src) && strings.IndexByte("|^&<>+-*/%", p.src[p.pos]) >= 0 {
+		return p.src[p.pos : p.pos+1]
+	}
+	return ""
+}
+
+// unary parses a unary operator or an operand
+func (p *exprParser) unary() (exprNode, error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return nil, errorf("syntax error in expression %q: missing operand", p.src)
+	}
+	switch c := p.src[p.pos]; c {
+	case '-', '+', '!', '~':
+		p.pos++
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &exprUnary{op: string(c), x: x}, nil
+	case '(':
+		p.pos++
+		x, err := p.parse(0)
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if !p.peek(")") {
+			return nil, errorf("syntax error in expression %q: missing )", p.src)
+		}
+		p.pos++
+		return x, nil
+	case '$':
+		start := p.pos
+		p.pos++
+		if p.peek("{") {
+			end := strings.IndexByte(p.src[p.pos:], '}')
+			if end < 0 {
+				return nil, errorf("missing close-brace for variable name")
+			}
+			p.pos += end + 1
+		} else {
+			for p.pos < len(p.src) && (isNameChar(p.src[p.pos]) || p.peek("::")) {
+				if p.peek("::") {
+					p.pos++
+				}
+				p.pos++
+			}
+			if p.peek("(") {
+				end := strings.IndexByte(p.src[p.pos:], ')')
+				if end < 0 {
+					return nil, errorf("missing )")
+				}
+				p.pos += end + 1
+			}
+		}
+		return &exprSubst{src: p.src[start:p.pos]}, nil
+	case '[':
+		start := p.pos
+		depth := 0
+		for ; p.pos < len(p.src); p.pos++ {
+			switch p.src[p.pos] {
+			case '\\':
+				p.pos++
+			case '[':
+				depth++
+			case ']':
+				depth--
+			}
+			if depth == 0 {
+				break
+			}
+		}
+		if p.pos >= len(p.src) {
+			return nil, errorf("missing close-bracket")
+		}
+		p.pos++
+		return &exprSubst{src: p.src[start:p.pos]}, nil
+	case '"':
+		start := p.pos
+		p.pos++
+		for p.pos < len(p.src) && p.src[p.pos] != '"' {
+			if p.src[p.pos] == '\\' {
+				p.pos++
+			}
+			p.pos++
+		}
+		if p.pos >= len(p.src) {
+			return nil, errorf("missing \"")
+		}
+		p.pos++
+		return &exprSubst{src: p.src[start+1 : p.pos-1]}, nil
+	case '{':
+		s, end, err := braced(p.src, p.pos)
+		if err != nil {
+			return nil, err
+		}
+		p.pos = end
+		return exprLiteral(s), nil
+	}
+
+	start := p.pos
+	if isDigit(p.src[p.pos]) || p.src[p.pos] == '.' {
+		for p.pos < len(p.src) && (isNameChar(p.src[p.pos]) || p.src[p.pos] == '.' ||
+			((p.src[p.pos] == '-' || p.src[p.pos] == '+') && (p.src[p.pos-1] == 'e' || p.src[p.pos-1] == 'E'))) {
+			p.pos++
+		}
+		num := p.src[start:p.pos]
+		if _, ok := parseNumber(num); !ok {
+			return nil, errorf("syntax error in expression %q: bad number %q", p.src, num)
+		}
+		return exprLiteral(num), nil
+	}
+	for p.pos < len(p.src) && isNameChar(p.src[p.pos]) {
+		p.pos++
+	}
+	name := p.src[start:p.pos]
+	if name == "" {
+		return nil, errorf("syntax error in expression %q: unexpected %q", p.src, p.src[start:start+1])
+	}
+	p.skipSpace()
+	if p.peek("(") {
+		p.pos++
+		var args []exprNode
+		p.skipSpace()
+		if p.peek(")") {
+			p.pos++
+			return &exprCall{fn: name, args: args}, nil
+		}
+		for {
+			arg, err := p.parse(0)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			p.skipSpace()
+			if p.peek(",") {
+				p.pos++
+				continue
+			}
+			if p.peek(")") {
+				p.pos++
+				return &exprCall{fn: name, args: args}, nil
+			}
+			return nil, errorf("syntax error in expression %q: missing ) after function arguments", p.src)
+		}
+	}
+	if _, err := toBool(name); err == nil {
+		return exprLiteral(name), nil
+	}
+	return nil, errorf("invalid bareword %q in expression %q", name, p.src)
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.src) && (isSpace(p.src[p.pos]) || p.src[p.pos] == '\n') {
+		p.pos++
+	}
+}
+
+func (p *exprParser) peek(s string) bool {
+	return strings.HasPrefix(p.src[p.pos:], s)
+}
+
+func (e exprLiteral) eval(in *Interp) (string, error) {
+	return string(e), nil
+}
+
+func (e *exprSubst) eval(in *Interp) (string, error) {
+	p := &parser{src: e.src}
+	return p.substitute(in, func(c byte) bool { return false })
+}
+
+func (e *exprUnary) eval(in *Interp) (string, error) {
+	v, err := e.x.eval(in)
+	if err != nil {
+		return "", err
+	}
+	if e.op == "!" {
+		b, err := toBool(v)
+		if err != nil {
+			return "", err
+		}
+		return formatBool(!b), nil
+	}
+	n, ok := parseNumber(v)
+	if !ok {
+		return "", errorf("can't use non-numeric string %q as operand of %q", v, e.op)
+	}
+	switch e.op {
+	case "-":
+		if n.isInt {
+			return strconv.FormatInt(-n.i, 10), nil
+		}
+		return formatFloat(-n.f), nil
+	case "~":
+		if !n.isInt {
+			return "", errorf("can't use floating-point value as operand of \"~\"")
+		}
+		return strconv.FormatInt(^n.i, 10), nil
+	}
+	return n.String(), nil
+}
+
+func (e *exprTernary) eval(in *Interp) (string, error) {
+	c, err := e.cond.eval(in)
+	if err != nil {
+		return "", err
+	}
+	b, err := toBool(c)
+	if err != nil {
+		return "", err
+	}
+	if b {
+		return e.x.eval(in)
+	}
+	return e.y.eval(in)
+}
+
+func (e *exprBinary) eval(in *Interp) (string, error) {
+	x, err := e.x.eval(in)
+	if err != nil {
+		return "", err
+	}
+	if e.op == "&&" || e.op == "||" {
+		b, err := toBool(x)
+		if err != nil {
+			return "", err
+		}
+		if b == (e.op == "||") {
+			return formatBool(b), nil
+		}
+		y, err := e.y.eval(in)
+		if err != nil {
+			return "", err
+		}
+		b, err = toBool(y)
+		if err != nil {
+			return "", err
+		}
+		return formatBool(b), nil
+	}
+	y, err := e.y.eval(in)
+	if err != nil {
+		return "", err
+	}
+
+	switch e.op {
+	case "eq":
+		return formatBool(x == y), nil
+	case "ne":
+		return formatBool(x != y), nil
+	case "in", "ni":
+		list, err := parseList(y)
+		if err != nil {
+			return "", err
+		}
+		found := false
+		for _, elem := range list {
+			if elem == x {
+				found = true
+				break
+			}
+		}
+		return formatBool(found == (e.op == "in")), nil
+	}
+
+	nx, okx := parseNumber(x)
+	ny, oky := parseNumber(y)
+	switch e.op {
+	case "==", "!=", "<", ">", "<=", ">=":
+		var cmp int
+		if okx && oky {
+			cmp = compareNumbers(nx, ny)
+		} else {
+			cmp = strings.Compare(x, y)
+		}
+		var r bool
+		switch e.op {
+		case "==":
+			r = cmp == 0
+		case "!=":
+			r = cmp != 0
+		case "<":
+			r = cmp < 0
+		case ">":
+			r = cmp > 0
+		case "<=":
+			r = cmp <= 0
+		case ">=":
+			r = cmp >= 0
+		}
+		return formatBool(r), nil
+	}
+
+	if !okx {
+		return "", errorf("can't use non-numeric string %q as operand of %q", x, e.op)
+	}
+	if !oky {
+		return "", errorf("can't use non-numeric string %q as operand of %q", y, e.op)
+	}
+	if nx.isInt && ny.isInt {
+		a, b := nx.i, ny.i
+		switch e.op {
+		case "+":
+			return strconv.FormatInt(a+b, 10), nil
+		case "-":
+			return strconv.FormatInt(a-b, 10), nil
+		case "*":
+			return strconv.FormatInt(a*b, 10), nil
+		case "/", "%":
+			if b == 0 {
+				return "", errorf("divide by zero")
+			}
+			// Tcl rounds towards negative infinity
+			q, r := a/b, a%b
+			if r != 0 && (r < 0) != (b < 0) {
+				q--
+				r += b
+			}
+			if e.op == "/" {
+				return strconv.FormatInt(q, 10), nil
+			}
+			return strconv.FormatInt(r, 10), nil
+		case "**":
+			if b < 0 {
+				return formatFloat(math.Pow(float64(a), float64(b))), nil
+			}
+			r := int64(1)
+			for ; b > 0; b-- {
+				r *= a
+			}
+			return strconv.FormatInt(r, 10), nil
+		case "&":
+			return strconv.FormatInt(a&b, 10), nil
+		case "|":
+			return strconv.FormatInt(a|b, 10), nil
+		case "^":
+			return strconv.FormatInt(a^b, 10), nil
+		case "<<":
+			return strconv.FormatInt(a<<uint(b), 10), nil
+		case ">>":
+			return strconv.FormatInt(a>>uint(b), 10), nil
+		}
+	}
+	a, b := nx.float(), ny.float()
+	switch e.op {
+	case "+":
+		return formatFloat(a + b), nil
+	case "-":
+		return formatFloat(a - b), nil
+	case "*":
+		return formatFloat(a * b), nil
+	case "/":
+		if b == 0 {
+			return "", errorf("divide by zero")
+		}
+		return formatFloat(a / b), nil
+	case "**":
+		return formatFloat(math.Pow(a, b)), nil
+	}
+	return "", errorf("can't use floating-point value as operand of %q", e.op)
+}
+
+func (e *exprCall) eval(in *Interp) (string, error) {
+	var args []number
+	for _, a := range e.args {
+		v, err := a.eval(in)
+		if err != nil {
+			return "", err
+		}
+		n, ok := parseNumber(v)
+		if !ok {
+			return "", errorf("expected number but got %q", v)
+		}
+		args = append(args, n)
+	}
+	one := func() (number, error) {
+		if len(args) != 1 {
+			return number{}, errorf("wrong # args for math function %q", e.fn)
+		}
+		return args[0], nil
+	}
+	switch e.fn {
+	case "abs":
+		n, err := one()
+		if err != nil {
+			return "", err
+		}
+		if n.isInt {
+			if n.i < 0 {
+				n.i = -n.i
+			}
+			return n.String(), nil
+		}
+		return formatFloat(math.Abs(n.f)), nil
+	case "int", "round", "ceil", "floor", "double", "sqrt":
+		n, err := one()
+		if err != nil {
+			return "", err
+		}
+		f := n.float()
+		switch e.fn {
+		case "int":
+			return strconv.FormatInt(int64(f), 10), nil
+		case "round":
+			return strconv.FormatInt(int64(math.Round(f)), 10), nil
+		case "ceil":
+			return formatFloat(math.Ceil(f)), nil
+		case "floor":
+			return formatFloat(math.Floor(f)), nil
+		case "sqrt":
+			return formatFloat(math.Sqrt(f)), nil
+		}
+		return formatFloat(f), nil
+	case "min", "max":
+		if len(args) == 0 {
+			return "", errorf("too few arguments to math function %q", e.fn)
+		}
+		best := args[0]
+		for _, n := range args[1:] {
+			c := compareNumbers(n, best)
+			if (e.fn == "min" && c < 0) || (e.fn == "max" && c > 0) {
+				best = n
+			}
+		}
+		return best.String(), nil
+	}
+	return "", errorf("unknown math function %q", e.fn)
+}
+
+// number is an integer or floating point value
+type number struct {
+	isInt bool
+	i     int64
+	f     float64
+}
+
+func (n number) float() float64 {
+	if n.isInt {
+		return float64(n.i)
+	}
+	return n.f
+}
+
+func (n number) String() string {
+	if n.isInt {
+		return strconv.FormatInt(n.i, 10)
+	}
+	return formatFloat(n.f)
+}
+
+// parseNumber parses s as a Tcl integer (decimal, 0x hex, 0o or 0b) or
+// floating point number
+func parseNumber(s string) (number, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return number{}, false
+	}
+	if i, err := strconv.ParseInt(s, 0, 64); err == nil {
+		return number{isInt: true, i: i}, true
+	}
+	if strings.ContainsAny(s, "xXbBoO_") {
+		return number{}, false
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return number{f: f}, true
+	}
+	return number{}, false
+}
+
+func compareNumbers(a, b number) int {
+	if a.isInt && b.isInt {
+		switch {
+		case a.i < b.i:
+			return -1
+		case a.i > b.i:
+			return 1
+		}
+		return 0
+	}
+	x, y := a.float(), b.float()
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// formatFloat formats f as Tcl does, always with a decimal point or exponent
+func formatFloat(f float64) string {
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return strings.Replace(strings.ToLower(s), "+inf", "inf", 1)
+	}
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
+
+func formatBool(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+// toBool converts a Tcl boolean, a number or one of true, false, yes, no,
+// on or off
+func toBool(s string) (bool, error) {
+	if n, ok := parseNumber(s); ok {
+		return n.float() != 0, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "true", "yes", "on":
+		return true, nil
+	case "false", "no", "off":
+		return false, nil
+	}
+	return false, errorf("expected boolean value but got %q", s)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
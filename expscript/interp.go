@@ -0,0 +1,461 @@
+/*
+File summary: Interpreter for a practical subset of Tcl and expect scripts
+Package: expscript
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+/*
+Package expscript runs Tcl expect (.exp) scripts without Tcl, using
+github.com/leemcloughlin/expect.
+
+It covers the parts of Tcl and expect that most scripts use: variables and
+arrays, set, if, while, for, foreach, switch, proc, expr, catch, string and
+list commands, and spawn, expect (with -re, -ex and -gl patterns, timeout,
+eof and default clauses and exp_continue), send, interact, close, wait, the
+expect_out array and exit codes. Anything else is an error naming the
+unsupported command or option rather than being silently ignored.
+
+	in := expscript.New()
+	code, err := in.RunFile("login.exp", []string{"host"})
+*/
+package expscript
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/leemcloughlin/expect"
+)
+
+// Error is a script error. Info holds a trace of the commands that were
+// running, like Tcl's errorInfo.
+type Error struct {
+	Msg  string
+	Info string
+}
+
+func (e *Error) Error() string {
+	return e.Msg
+}
+
+// errorf returns a new *Error
+func errorf(format string, args ...interface{}) error {
+	msg := fmt.Sprintf(format, args...)
+	return &Error{Msg: msg, Info: msg}
+}
+
+// traceError adds cmd to the trace of err if it is an *Error
+func traceError(err error, cmd string) error {
+	var e *Error
+	if !errors.As(err, &e) {
+		return err
+	}
+	cmd = strings.TrimSpace(cmd)
+	if len(cmd) > 150 {
+		cmd = cmd[:150] + "..."
+	}
+	e.Info += "\n    while executing\n\"" + cmd + "\""
+	return err
+}
+
+// ExitError is returned by Run() when the script calls exit
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit %d", e.Code)
+}
+
+// flow is returned as an error by break, continue, return and exp_continue
+// to unwind to the command that handles it
+type flow struct {
+	kind  int
+	value string
+}
+
+const (
+	flowBreak = iota
+	flowContinue
+	flowReturn
+	flowExpContinue
+)
+
+func (f *flow) Error() string {
+	switch f.kind {
+	case flowBreak:
+		return `invoked "break" outside of a loop`
+	case flowContinue:
+		return `invoked "continue" outside of a loop`
+	case flowExpContinue:
+		return `invoked "exp_continue" outside of expect`
+	}
+	return `invoked "return" outside of a proc`
+}
+
+// variable is a scalar or an array. A variable made by global is a link to
+// the global variable.
+type variable struct {
+	value string
+	array map[string]string
+	link  *variable
+}
+
+// frame holds the variables of a proc call or the globals
+type frame struct {
+	vars map[string]*variable
+}
+
+// proc is a procedure defined by the script
+type proc struct {
+	params   []string
+	defaults map[string]*string
+	body     string
+}
+
+// command is a command implemented in Go
+type command func(in *Interp, args []string) (string, error)
+
+// Interp runs scripts. Create one with New().
+type Interp struct {
+	// Stdin, Stdout and Stderr are used by interact, send_user, puts and the
+	// like. New() sets them to the os ones.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	globals *frame
+	frame   *frame
+	depth   int
+
+	procs    map[string]*proc
+	commands map[string]command
+
+	// spawns are the processes started by spawn, by spawn_id
+	spawns    map[string]*spawned
+	spawnNext int
+	logUser   bool
+}
+
+// maxDepth limits proc recursion
+const maxDepth = 1000
+
+// New returns an interpreter with the standard variables set, including a
+// timeout of 10 seconds as expect has
+func New() *Interp {
+	in := &Interp{
+		Stdin:    os.Stdin,
+		Stdout:   os.Stdout,
+		Stderr:   os.Stderr,
+		globals:  &frame{vars: map[string]*variable{}},
+		procs:    map[string]*proc{},
+		commands: map[string]command{},
+		spawns:   map[string]*spawned{},
+		logUser:  true,
+	}
+	in.frame = in.globals
+	for name, cmd := range coreCommands {
+		in.commands[name] = cmd
+	}
+	for name, cmd := range expectCommands {
+		in.commands[name] = cmd
+	}
+	in.setVar("timeout", "10")
+	in.setVar("argv", "")
+	in.setVar("argc", "0")
+	in.setVar("argv0", "")
+	return in
+}
+
+// Run runs script and returns the exit code: that given to exit, 0 if the
+// script ends normally or 1 on an error, which is also returned.
+// Processes the script spawned and did not close are ended.
+func (in *Interp) Run(script string) (int, error) {
+	defer in.closeAll()
+	p := &parser{src: script}
+	_, err := p.script(in)
+	var exit *ExitError
+	var f *flow
+	switch {
+	case err == nil:
+		return 0, nil
+	case errors.As(err, &exit):
+		return exit.Code, nil
+	case errors.As(err, &f) && f.kind == flowReturn:
+		return 0, nil
+	}
+	return 1, err
+}
+
+// RunFile runs the script in path with argv set to args
+func (in *Interp) RunFile(path string, args []string) (int, error) {
+	script, err := os.ReadFile(path)
+	if err != nil {
+		return 1, err
+	}
+	in.setVar("argv0", path)
+	in.setVar("argv", formatList(args))
+	in.setVar("argc", fmt.Sprint(len(args)))
+	return in.Run(skipShebang(string(script)))
+}
+
+// skipShebang blanks a #! line, which may be continued with a backslash
+// as in "exec tclsh "$0" ${1+"$@"}" tricks, so line numbers stay the same
+func skipShebang(script string) string {
+	if !strings.HasPrefix(script, "#!") {
+		return script
+	}
+	end := strings.IndexByte(script, '\n')
+	if end < 0 {
+		return ""
+	}
+	return strings.Repeat(" ", end) + script[end:]
+}
+
+// Eval runs script in the current frame and returns its result
+func (in *Interp) Eval(script string) (string, error) {
+	p := &parser{src: script}
+	return p.script(in)
+}
+
+// Register adds or replaces a command implemented in Go
+func (in *Interp) Register(name string, cmd func(in *Interp, args []string) (string, error)) {
+	in.commands[name] = cmd
+}
+
+// call runs the command named by words[0]
+func (in *Interp) call(words []string) (string, error) {
+	name := words[0]
+	if p, ok := in.procs[name]; ok {
+		return in.callProc(name, p, words[1:])
+	}
+	if cmd, ok := in.commands[name]; ok {
+		return cmd(in, words)
+	}
+	if msg, ok := unsupported[name]; ok {
+		return "", errorf("%q is not supported by expscript: %s", name, msg)
+	}
+	return "", errorf("invalid command name %q", name)
+}
+
+// callProc runs a proc in a new frame
+func (in *Interp) callProc(name string, p *proc, args []string) (string, error) {
+	if in.depth >= maxDepth {
+		return "", errorf("too many nested calls to %s (infinite loop?)", name)
+	}
+	f := &frame{vars: map[string]*variable{}}
+	for i, param := range p.params {
+		switch {
+		case param == "args" && i == len(p.params)-1:
+			rest := []string{}
+			if i < len(args) {
+				rest = args[i:]
+			}
+			f.vars[param] = &variable{value: formatList(rest)}
+			args = args[:min(i, len(args))]
+		case i < len(args):
+			f.vars[param] = &variable{value: args[i]}
+		case p.defaults[param] != nil:
+			f.vars[param] = &variable{value: *p.defaults[param]}
+		default:
+			return "", errorf("wrong # args: should be \"%s %s\"", name, strings.Join(p.params, " "))
+		}
+	}
+	if len(args) > len(p.params) {
+		return "", errorf("wrong # args: should be \"%s %s\"", name, strings.Join(p.params, " "))
+	}
+
+	saved := in.frame
+	in.frame = f
+	in.depth++
+	defer func() {
+		in.frame = saved
+		in.depth--
+	}()
+
+	result, err := in.Eval(p.body)
+	var fl *flow
+	if errors.As(err, &fl) && fl.kind == flowReturn {
+		return fl.value, nil
+	}
+	return result, err
+}
+
+// splitVarName splits "name(index)" into name and index
+func splitVarName(name string) (string, string, bool) {
+	if open := strings.IndexByte(name, '('); open > 0 && strings.HasSuffix(name, ")") {
+		return name[:open], name[open+1 : len(name)-1], true
+	}
+	return name, "", false
+}
+
+// lookup finds a variable in the current frame, following global links.
+// If create is set a missing variable is created.
+func (in *Interp) lookup(name string, create bool) *variable {
+	name = strings.TrimPrefix(name, "::")
+	vars := in.frame.vars
+	if strings.Contains(name, "::") {
+		return nil
+	}
+	v, ok := vars[name]
+	if !ok {
+		if !create {
+			return nil
+		}
+		v = &variable{}
+		vars[name] = v
+	}
+	for v.link != nil {
+		v = v.link
+	}
+	return v
+}
+
+// getVar returns the value of a variable, which may be an array element
+func (in *Interp) getVar(name string) (string, error) {
+	base, index, isElem := splitVarName(name)
+	v := in.lookup(base, false)
+	if v == nil {
+		return "", errorf("can't read %q: no such variable", name)
+	}
+	if isElem {
+		if v.array == nil {
+			return "", errorf("can't read %q: variable isn't array", name)
+		}
+		val, ok := v.array[index]
+		if !ok {
+			return "", errorf("can't read %q: no such element in array", name)
+		}
+		return val, nil
+	}
+	if v.array != nil {
+		return "", errorf("can't read %q: variable is array", name)
+	}
+	return v.value, nil
+}
+
+// setVar sets a variable, which may be an array element
+func (in *Interp) setVar(name, value string) (string, error) {
+	base, index, isElem := splitVarName(name)
+	v := in.lookup(base, true)
+	if isElem {
+		if v.array == nil {
+			if v.value != "" {
+				return "", errorf("can't set %q: variable isn't array", name)
+			}
+			v.array = map[string]string{}
+		}
+		v.array[index] = value
+		return value, nil
+	}
+	if v.array != nil {
+		return "", errorf("can't set %q: variable is array", name)
+	}
+	v.value = value
+	return value, nil
+}
+
+// unsetVar removes a variable or array element
+func (in *Interp) unsetVar(name string) error {
+	base, index, isElem := splitVarName(name)
+	v := in.lookup(base, false)
+	if v == nil {
+		return errorf("can't unset %q: no such variable", name)
+	}
+	if isElem {
+		if _, ok := v.array[index]; !ok {
+			return errorf("can't unset %q: no such element in array", name)
+		}
+		delete(v.array, index)
+		return nil
+	}
+	delete(in.frame.vars, strings.TrimPrefix(base, "::"))
+	return nil
+}
+
+// varExists is true if the variable or array element exists
+func (in *Interp) varExists(name string) bool {
+	base, index, isElem := splitVarName(name)
+	v := in.lookup(base, false)
+	if v == nil {
+		return false
+	}
+	if isElem {
+		_, ok := v.array[index]
+		return ok
+	}
+	return true
+}
+
+// arrayNames returns the sorted indexes of an array
+func (in *Interp) arrayNames(name string) []string {
+	v := in.lookup(name, false)
+	if v == nil {
+		return nil
+	}
+	names := make([]string, 0, len(v.array))
+	for k := range v.array {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// expectVar returns a variable used by expect, such as timeout or spawn_id,
+// from the current frame or else the globals, as expect does
+func (in *Interp) expectVar(name string) (string, bool) {
+	if v := in.lookup(name, false); v != nil && v.array == nil {
+		return v.value, true
+	}
+	if v, ok := in.globals.vars[name]; ok {
+		for v.link != nil {
+			v = v.link
+		}
+		return v.value, v.array == nil
+	}
+	return "", false
+}
+
+// unsupported explains commands that scripts use but expscript does not
+// implement
+var unsupported = map[string]string{
+	"namespace":   "namespaces are not implemented",
+	"upvar":       "use global or pass values instead",
+	"uplevel":     "use global or pass values instead",
+	"trace":       "variable traces are not implemented",
+	"exec":        "use spawn instead",
+	"open":        "file I/O is not implemented",
+	"socket":      "sockets are not implemented",
+	"vwait":       "the event loop is not implemented",
+	"fileevent":   "the event loop is not implemented",
+	"expect_user": "read from stdin with gets stdin instead",
+	"expect_tty":  "the controlling terminal is not supported",
+	"send_tty":    "the controlling terminal is not supported",
+	"expect_before": "expect_before and expect_after are not implemented, " +
+		"put the clauses in each expect",
+	"expect_after": "expect_before and expect_after are not implemented, " +
+		"put the clauses in each expect",
+	"expect_background": "background expects are not implemented",
+	"fork":              "fork is not implemented",
+	"disconnect":        "disconnect is not implemented",
+	"trap":              "signal traps are not implemented",
+	"package":           "packages are not implemented",
+	"interp":            "sub-interpreters are not implemented",
+}
+
+// spawned is a process started by spawn
+type spawned struct {
+	exp *expect.Expect
+	pid int
+
+	// closed is set once close has shut the process down, after which only
+	// wait can use it
+	closed bool
+}
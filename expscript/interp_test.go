@@ -0,0 +1,347 @@
+/*
+File summary: go test of the expscript interpreter
+Package: expscript
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expscript
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// run runs script and returns its output, exit code and error
+func run(script string) (string, int, error) {
+	in := New()
+	var out bytes.Buffer
+	in.Stdout = &out
+	in.Stderr = &out
+	in.Stdin = strings.NewReader("")
+	code, err := in.Run(script)
+	return out.String(), code, err
+}
+
+func Test_Tcl(t *testing.T) {
+	for _, tc := range []struct {
+		script string
+		output string
+	}{
+		{`puts hello`, "hello\n"},
+		{`set a 1; set b "a is $a"; puts $b`, "a is 1\n"},
+		{`set a {no $subst [here]}; puts $a`, "no $subst [here]\n"},
+		{`puts [expr {1 + 2 * 3}]`, "7\n"},
+		{`puts [expr {(1 + 2) * 3 / 2.0}]`, "4.5\n"},
+		{`puts [expr {-7 / 2}][expr {-7 % 2}]`, "-41\n"},
+		{`set x 5; puts [expr {$x > 3 && $x < 10 ? "mid" : "out"}]`, "mid\n"},
+		{`puts [expr {"abc" eq "abc"}][expr {"a" < "b"}][expr {10 == 10.0}]`, "111\n"},
+		{`puts [expr {abs(-3) + int(2.7) + max(1, 5, 2)}]`, "10\n"},
+		{`set a(x) 1; set a(y) 2; set k y; puts "$a(x) $a($k) [array size a]"`, "1 2 2\n"},
+		{`puts -nonewline "a\tb\x41é\n"`, "a\tbAé\n"},
+		{"set l [list a {b c} \"d e\" {}]\nputs $l\nputs [llength $l]", "a {b c} {d e} {}\n4\n"},
+		{`puts [lindex {a {b c} d} 1] ; puts [lindex {a b c} end]`, "b c\nc\n"},
+		{`puts [lrange {a b c d} 1 end-1]`, "b c\n"},
+		{`set l {}; lappend l x; lappend l "y z"; puts $l`, "x {y z}\n"},
+		{`puts [join [split "a,b,c" ,] -]`, "a-b-c\n"},
+		{`puts [string toupper abc][string length héllo][string range abcdef 1 3]`, "ABC5bcd\n"},
+		{`puts [string match "*.exp" test.exp][string first b abc][string trim "  x  "]`, "11x\n"},
+		{`puts [string map {a 1 b 2} abcab]`, "12c12\n"},
+		{`set i 0; while {$i < 5} {incr i}; puts $i`, "5\n"},
+		{`for {set i 0} {$i < 10} {incr i} {if {$i == 3} continue; if {$i == 5} break; puts $i}`, "0\n1\n2\n4\n"},
+		{`foreach x {a b c} {puts -nonewline $x}; puts ""`, "abc\n"},
+		{`foreach {k v} {a 1 b 2} {puts "$k=$v"}`, "a=1\nb=2\n"},
+		{`if {0} {puts a} elseif {1} {puts b} else {puts c}`, "b\n"},
+		{`if 0 then {puts a} else {puts c}`, "c\n"},
+		{`proc add {a {b 10}} {return [expr {$a + $b}]}; puts [add 1][add 1 2]`, "113\n"},
+		{`proc f {args} {llength $args}; puts [f 1 2 3]`, "3\n"},
+		{`proc p {a {b 2} args} {list $a $b $args}; puts [p 1]; puts [p 1 3 4 5]`, "1 2 {}\n1 3 {4 5}\n"},
+		{`set g 1; proc f {} {global g; incr g}; f; f; puts $g`, "3\n"},
+		{`proc fact {n} {if {$n <= 1} {return 1}; expr {$n * [fact [expr {$n-1}]]}}; puts [fact 10]`, "3628800\n"},
+		{`puts [catch {error oops} msg]$msg`, "1oops\n"},
+		{`puts [catch {set nosuch} msg]; puts $msg`, "1\ncan't read \"nosuch\": no such variable\n"},
+		{`switch -glob -- foo.txt {*.c {puts c} *.txt - *.md {puts text} default {puts other}}`, "text\n"},
+		{"switch x {\n  a {puts a}\n  default {puts d}\n}", "d\n"},
+		{`if {[regexp {(\d+)-(\d+)} "ab 12-34" all a b]} {puts "$all $a $b"}`, "12-34 12 34\n"},
+		{`regsub -all {o} "foo boo" 0 r; puts $r`, "f00 b00\n"},
+		{`puts [regsub {(\w+) (\w+)} "hello world" {\2 \1}]`, "world hello\n"},
+		{`puts [format "%s=%03d %.2f" x 7 3.14159]`, "x=007 3.14\n"},
+		{"# a comment\nputs a ;# another\nputs b", "a\nb\n"},
+		{"puts [concat a \\\n   b]", "a b\n"},
+		{`set s "x"; append s y z; puts $s`, "xyz\n"},
+		{`puts [info exists nosuch][info exists argv]`, "01\n"},
+		{`eval puts {"evaluated"}`, "evaluated\n"},
+		{`package require Expect; puts ok`, "ok\n"},
+		{`set x [set y 3]; puts "[expr {$x * $y}]"`, "9\n"},
+		{`puts "nested [string toupper [lindex {a b} 1]] done"`, "nested B done\n"},
+	} {
+		output, code, err := run(tc.script)
+		if err != nil || code != 0 {
+			t.Errorf("%q failed %d %v output %q", tc.script, code, err, output)
+			continue
+		}
+		if output != tc.output {
+			t.Errorf("%q output is %q not %q", tc.script, output, tc.output)
+		}
+	}
+}
+
+func Test_TclErrors(t *testing.T) {
+	for _, tc := range []struct {
+		script string
+		msg    string
+	}{
+		{`nosuchcommand`, `invalid command name "nosuchcommand"`},
+		{`namespace eval x {}`, `"namespace" is not supported by expscript`},
+		{`upvar 1 x y`, `"upvar" is not supported by expscript`},
+		{`puts {*}$list`, `argument expansion {*} is not supported`},
+		{`set a {unclosed`, `missing close-brace`},
+		{`puts "unclosed`, `missing "`},
+		{`expr {1 +}`, `missing operand`},
+		{`spawn -open x`, `spawn option "-open" is not supported`},
+		{`send hello`, `no spawned process`},
+		{`proc f {x} {}; f`, `wrong # args`},
+		{`interact`, `no spawned process`},
+	} {
+		_, code, err := run(tc.script)
+		if err == nil || code != 1 {
+			t.Errorf("%q did not fail: %d %v", tc.script, code, err)
+			continue
+		}
+		if !strings.Contains(err.Error(), tc.msg) {
+			t.Errorf("%q error is %q, expected it to contain %q", tc.script, err, tc.msg)
+		}
+	}
+
+	// The trace shows where the error happened
+	_, _, err := run("proc f {} {\n  nosuch 1\n}\nf")
+	var e *Error
+	if !errors.As(err, &e) || !strings.Contains(e.Info, "while executing\n\"nosuch 1\"") ||
+		!strings.Contains(e.Info, "\"f\"") {
+		t.Errorf("error info is %q", e.Info)
+	}
+}
+
+// Test_TclUnclosedBracket checks nothing in an unclosed [ is run
+func Test_TclUnclosedBracket(t *testing.T) {
+	in := New()
+	var out bytes.Buffer
+	in.Stdout = &out
+	in.Stderr = &out
+	in.Stdin = strings.NewReader("")
+	sent := 0
+	send := in.commands["send"]
+	in.Register("send", func(in *Interp, args []string) (string, error) {
+		sent++
+		return send(in, args)
+	})
+	_, err := in.Run("log_user 0\nspawn -noecho cat\nset x [send hello; puts ran\nsend again")
+	if err == nil || !strings.Contains(err.Error(), "missing close-bracket") {
+		t.Errorf("error is %v", err)
+	}
+	if sent != 0 || out.Len() != 0 {
+		t.Errorf("sent %d times and output %q", sent, out.String())
+	}
+}
+
+func Test_TclExit(t *testing.T) {
+	for script, expected := range map[string]int{
+		`exit`:                     0,
+		`exit 3`:                   3,
+		`proc f {} {exit 4}; f`:    4,
+		`catch {exit 5}; exit 6`:   5,
+		`puts done`:                0,
+		`error failed`:             1,
+		`while 1 {if 1 {exit 7}}`:  7,
+		`foreach x {1} {exit $x}`:  1,
+		`return; exit 9`:           0,
+		`set x 2; exit [incr x 6]`: 8,
+	} {
+		_, code, _ := run(script)
+		if code != expected {
+			t.Errorf("%q exit code is %d not %d", script, code, expected)
+		}
+	}
+}
+
+func Test_Expect(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		script string
+		output string
+		code   int
+	}{
+		{"glob", `
+log_user 0
+spawn cat
+send "hello world\r"
+expect "*world"
+puts "buffer=[string trim $expect_out(buffer)]"
+expect "world*"
+exit 0
+`, "buffer=hello world\n", 0},
+		{"re groups", `
+log_user 0
+spawn -noecho sh -c "echo version 1.23; sleep 5"
+expect -re {version (\d+)\.(\d+)\r\n} {
+	puts "major $expect_out(1,string) minor $expect_out(2,string)"
+	puts "matched [string trim $expect_out(0,string)]"
+}
+`, "major 1 minor 23\nmatched version 1.23\n", 0},
+		{"exact", `
+log_user 0
+spawn sh -c {echo 'a*b [x]'; sleep 5}
+expect -ex {a*b [x]} {puts found} timeout {puts timeout}
+`, "found\n", 0},
+		{"clauses and exp_continue", `
+log_user 0
+set timeout 5
+spawn sh -c "echo one; echo two; echo three; sleep 5"
+set seen {}
+expect {
+	-re {(one|two)\r\n} {
+		lappend seen $expect_out(1,string)
+		exp_continue
+	}
+	"three" {
+		lappend seen three
+	}
+	timeout {
+		puts timeout
+		exit 2
+	}
+}
+puts $seen
+`, "one two three\n", 0},
+		{"timeout", `
+log_user 0
+set timeout 0.2
+spawn sleep 5
+expect {
+	"never" {exit 1}
+	timeout {puts "timed out"; exit 3}
+}
+`, "timed out\n", 3},
+		{"eof", `
+log_user 0
+spawn sh -c "echo bye"
+expect {
+	"never" {exit 1}
+	eof {puts "eof [string trim $expect_out(buffer)]"}
+}
+set status [wait]
+puts "exit [lindex $status 3]"
+`, "eof bye\nexit 0\n", 0},
+		{"exit status", `
+log_user 0
+spawn sh -c "exit 3"
+expect eof
+exit [lindex [wait] 3]
+`, "", 3},
+		{"default", `
+log_user 0
+set timeout 0.2
+spawn sleep 5
+expect "never" {exit 1} default {puts default}
+`, "default\n", 0},
+		{"proc with spawn_id", `
+log_user 0
+proc talk {} {
+	global spawn_id
+	send "ping\r"
+	expect "ping" {return pong}
+}
+spawn cat
+puts [talk]
+close
+`, "pong\n", 0},
+		{"close and wait", `
+log_user 0
+spawn cat
+close
+puts [lindex [wait] 3]
+spawn cat
+close
+`, "0\n", 0},
+		{"log_user", `
+spawn -noecho sh -c "echo logged; sleep 5"
+expect "logged"
+`, "logged", 0},
+		{"two spawns", `
+log_user 0
+spawn cat
+set a $spawn_id
+spawn cat
+set b $spawn_id
+send -i $a "to a\r"
+send -i $b "to b\r"
+expect -i $b "to b" {puts b}
+expect -i $a "to a" {puts a}
+`, "b\na\n", 0},
+		{"match_max", `
+log_user 0
+set timeout 5
+spawn -noecho sh -c "read go; seq 1 500; echo done; sleep 5"
+match_max 100
+send "go\r"
+expect -re {(\d+)\r\ndone} {
+	puts "last $expect_out(1,string)"
+	puts "buffer [string length $expect_out(buffer)]"
+}
+`, "last 500\n", 0},
+	} {
+		output, code, err := run(tc.script)
+		if err != nil {
+			t.Errorf("%s failed %s", tc.name, err)
+			continue
+		}
+		if code != tc.code || !strings.Contains(output, tc.output) {
+			t.Errorf("%s is %d %q not %d %q", tc.name, code, output, tc.code, tc.output)
+		} else {
+			t.Logf("%s is %d %q", tc.name, code, output)
+		}
+	}
+}
+
+func Test_Interact(t *testing.T) {
+	for _, logUser := range []string{"0", "1"} {
+		in := New()
+		var out bytes.Buffer
+		in.Stdout = &out
+		// The input stays open so interact ends when the process does
+		r, w := io.Pipe()
+		go w.Write([]byte("typed\r"))
+		in.Stdin = r
+		code, err := in.Run(`
+log_user ` + logUser + `
+spawn -noecho sh -c "read line; echo got \$line"
+interact
+`)
+		w.Close()
+		if err != nil || code != 0 || strings.Count(out.String(), "got typed") != 1 {
+			t.Errorf("interact with log_user %s is %d %v %q", logUser, code, err, out.String())
+		}
+	}
+}
+
+func Test_RunFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "args.exp")
+	script := "#!/usr/bin/expect -f\nputs \"$argc [lindex $argv 1] [file tail $argv0]\"\nexit 2\n"
+	if err := os.WriteFile(path, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+	in := New()
+	var out bytes.Buffer
+	in.Stdout = &out
+	code, err := in.RunFile(path, []string{"a", "b c"})
+	if err != nil || code != 2 || out.String() != "2 b c args.exp\n" {
+		t.Errorf("RunFile is %d %v %q", code, err, out.String())
+	}
+}
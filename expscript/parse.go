@@ -0,0 +1,497 @@
+/*
+File summary: Tcl parsing, substitution and lists
+Package: expscript
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expscript
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// parser evaluates Tcl source a command at a time. Words are substituted as
+// they are parsed, as Tcl does, so a braced body is never looked at until the
+// command it is passed to runs it.
+type parser struct {
+	src string
+	pos int
+
+	// nested is set for the script inside [], which ends at the matching ]
+	nested bool
+
+	// check is set to parse without running commands or reading variables,
+	// to find the end of a script before it is run
+	check bool
+}
+
+// script evaluates commands until the end of the source or, if nested, the
+// closing ]. The result is that of the last command.
+func (p *parser) script(in *Interp) (string, error) {
+	result := ""
+	for {
+		start, words, err := p.command(in)
+		if err != nil {
+			return "", err
+		}
+		if len(words) > 0 && !p.check {
+			result, err = in.call(words)
+			if err != nil {
+				return "", traceError(err, p.src[start:p.pos])
+			}
+		}
+		if p.pos >= len(p.src) || (p.nested && p.src[p.pos] == ']') {
+			return result, nil
+		}
+	}
+}
+
+// command parses and substitutes the words of the next command. It returns
+// the start of the command in src for error messages.
+func (p *parser) command(in *Interp) (int, []string, error) {
+	// Skip separators and comments
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == '\n' || c == ';' || isSpace(c):
+			p.pos++
+			continue
+		case c == '\\' && p.pos+1 < len(p.src) && p.src[p.pos+1] == '\n':
+			p.pos += 2
+			continue
+		case c == '#':
+			p.skipComment()
+			continue
+		}
+		break
+	}
+	start := p.pos
+
+	var words []string
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case isSpace(c):
+			p.pos++
+			continue
+		case c == '\\' && p.pos+1 < len(p.src) && p.src[p.pos+1] == '\n':
+			// Line continuation
+			p.pos += 2
+			continue
+		case c == '\n' || c == ';':
+			p.pos++
+			return start, words, nil
+		case p.nested && c == ']':
+			return start, words, nil
+		}
+		word, err := p.word(in)
+		if err != nil {
+			return start, nil, err
+		}
+		words = append(words, word)
+	}
+	return start, words, nil
+}
+
+// skipComment skips a comment up to the end of the line, which may be
+// continued with a backslash
+func (p *parser) skipComment() {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == '\\' {
+			p.pos += 2
+			continue
+		}
+		p.pos++
+		if c == '\n' {
+			return
+		}
+	}
+	if p.pos > len(p.src) {
+		p.pos = len(p.src)
+	}
+}
+
+// word parses and substitutes one word
+func (p *parser) word(in *Interp) (string, error) {
+	switch p.src[p.pos] {
+	case '{':
+		if strings.HasPrefix(p.src[p.pos:], "{*}") && p.pos+3 < len(p.src) && !isSpace(p.src[p.pos+3]) {
+			return "", errorf("argument expansion {*} is not supported")
+		}
+		word, end, err := braced(p.src, p.pos)
+		if err != nil {
+			return "", err
+		}
+		p.pos = end
+		if !p.atWordEnd() {
+			return "", errorf("extra characters after close-brace")
+		}
+		return word, nil
+	case '"':
+		p.pos++
+		word, err := p.substitute(in, func(c byte) bool { return c == '"' })
+		if err != nil {
+			return "", err
+		}
+		if p.pos >= len(p.src) {
+			return "", errorf("missing \"")
+		}
+		p.pos++
+		if !p.atWordEnd() {
+			return "", errorf("extra characters after close-quote")
+		}
+		return word, nil
+	}
+	return p.substitute(in, func(c byte) bool {
+		return isSpace(c) || c == '\n' || c == ';' || (p.nested && c == ']')
+	})
+}
+
+// atWordEnd is true if the next character ends a word
+func (p *parser) atWordEnd() bool {
+	if p.pos >= len(p.src) {
+		return true
+	}
+	c := p.src[p.pos]
+	return isSpace(c) || c == '\n' || c == ';' || (p.nested && c == ']') ||
+		(c == '\\' && p.pos+1 < len(p.src) && p.src[p.pos+1] == '\n')
+}
+
+// substitute copies the source up to a character for which end is true,
+// replacing variables, commands and backslash sequences
+func (p *parser) substitute(in *Interp, end func(c byte) bool) (string, error) {
+	var sb strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if end(c) {
+			break
+		}
+		switch c {
+		case '\\':
+			s, n := backslash(p.src[p.pos:])
+			sb.WriteString(s)
+			p.pos += n
+		case '$':
+			s, err := p.variable(in)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(s)
+		case '[':
+			// Nothing is run unless the ] is there
+			check := &parser{src: p.src, pos: p.pos + 1, nested: true, check: true}
+			if _, err := check.script(in); err != nil {
+				return "", err
+			}
+			if check.pos >= len(p.src) {
+				return "", errorf("missing close-bracket")
+			}
+			if p.check {
+				p.pos = check.pos + 1
+				continue
+			}
+			sub := &parser{src: p.src, pos: p.pos + 1, nested: true}
+			s, err := sub.script(in)
+			if err != nil {
+				return "", err
+			}
+			p.pos = sub.pos + 1
+			sb.WriteString(s)
+		default:
+			sb.WriteByte(c)
+			p.pos++
+		}
+	}
+	return sb.String(), nil
+}
+
+// variable substitutes the variable starting with $ at pos
+func (p *parser) variable(in *Interp) (string, error) {
+	p.pos++ // $
+	if p.pos < len(p.src) && p.src[p.pos] == '{' {
+		end := strings.IndexByte(p.src[p.pos:], '}')
+		if end < 0 {
+			return "", errorf("missing close-brace for variable name")
+		}
+		name := p.src[p.pos+1 : p.pos+end]
+		p.pos += end + 1
+		return p.getVar(in, name)
+	}
+
+	start := p.pos
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if isNameChar(c) {
+			p.pos++
+		} else if c == ':' && p.pos+1 < len(p.src) && p.src[p.pos+1] == ':' {
+			p.pos += 2
+		} else {
+			break
+		}
+	}
+	name := p.src[start:p.pos]
+	if name == "" {
+		return "$", nil
+	}
+	if p.pos < len(p.src) && p.src[p.pos] == '(' {
+		p.pos++
+		index, err := p.substitute(in, func(c byte) bool { return c == ')' })
+		if err != nil {
+			return "", err
+		}
+		if p.pos >= len(p.src) {
+			return "", errorf("missing )")
+		}
+		p.pos++
+		return p.getVar(in, name+"("+index+")")
+	}
+	return p.getVar(in, name)
+}
+
+// getVar returns the value of a variable, or "" when only checking
+func (p *parser) getVar(in *Interp, name string) (string, error) {
+	if p.check {
+		return "", nil
+	}
+	return in.getVar(name)
+}
+
+// braced returns the contents of the braced word starting at src[start] and
+// the position after the closing brace
+func braced(src string, start int) (string, int, error) {
+	depth := 0
+	for i := start; i < len(src); i++ {
+		switch src[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				// Backslash-newline is still replaced inside braces
+				return joinContinuations(src[start+1 : i]), i + 1, nil
+			}
+		}
+	}
+	return "", len(src), errorf("missing close-brace")
+}
+
+// joinContinuations replaces each backslash-newline and the white space
+// after it with a single space
+func joinContinuations(s string) string {
+	if !strings.Contains(s, "\\\n") {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			if s[i+1] == '\n' {
+				i += 2
+				for i < len(s) && isSpace(s[i]) {
+					i++
+				}
+				i--
+				sb.WriteByte(' ')
+				continue
+			}
+			sb.WriteByte(s[i])
+			i++
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+// backslash returns the substitution for the backslash sequence at the start
+// of s and its length
+func backslash(s string) (string, int) {
+	if len(s) < 2 {
+		return "\\", 1
+	}
+	switch c := s[1]; c {
+	case 'a':
+		return "\a", 2
+	case 'b':
+		return "\b", 2
+	case 'f':
+		return "\f", 2
+	case 'n':
+		return "\n", 2
+	case 'r':
+		return "\r", 2
+	case 't':
+		return "\t", 2
+	case 'v':
+		return "\v", 2
+	case '\n':
+		n := 2
+		for n < len(s) && isSpace(s[n]) {
+			n++
+		}
+		return " ", n
+	case 'x':
+		n := 2
+		for n < len(s) && n < 4 && isHex(s[n]) {
+			n++
+		}
+		if n == 2 {
+			return "x", 2
+		}
+		v, _ := strconv.ParseUint(s[2:n], 16, 8)
+		return string(rune(v)), n
+	case 'u':
+		n := 2
+		for n < len(s) && n < 6 && isHex(s[n]) {
+			n++
+		}
+		if n == 2 {
+			return "u", 2
+		}
+		v, _ := strconv.ParseUint(s[2:n], 16, 32)
+		return string(rune(v)), n
+	default:
+		if c >= '0' && c <= '7' {
+			n := 1
+			for n < len(s) && n < 4 && s[n] >= '0' && s[n] <= '7' {
+				n++
+			}
+			v, _ := strconv.ParseUint(s[1:n], 8, 8)
+			return string(rune(v)), n
+		}
+		_, size := utf8.DecodeRuneInString(s[1:])
+		return s[1 : 1+size], 1 + size
+	}
+}
+
+// parseList splits a Tcl list into its elements
+func parseList(s string) ([]string, error) {
+	var elems []string
+	i := 0
+	for {
+		for i < len(s) && (isSpace(s[i]) || s[i] == '\n') {
+			i++
+		}
+		if i >= len(s) {
+			return elems, nil
+		}
+		switch s[i] {
+		case '{':
+			elem, end, err := braced(s, i)
+			if err != nil {
+				return nil, errorf("unmatched open brace in list")
+			}
+			if end < len(s) && !isSpace(s[end]) && s[end] != '\n' {
+				return nil, errorf("list element in braces followed by %q instead of space", s[end:end+1])
+			}
+			elems = append(elems, elem)
+			i = end
+		case '"':
+			var sb strings.Builder
+			i++
+			for i < len(s) && s[i] != '"' {
+				if s[i] == '\\' {
+					b, n := backslash(s[i:])
+					sb.WriteString(b)
+					i += n
+					continue
+				}
+				sb.WriteByte(s[i])
+				i++
+			}
+			if i >= len(s) {
+				return nil, errorf("unmatched open quote in list")
+			}
+			i++
+			elems = append(elems, sb.String())
+		default:
+			var sb strings.Builder
+			for i < len(s) && !isSpace(s[i]) && s[i] != '\n' {
+				if s[i] == '\\' {
+					b, n := backslash(s[i:])
+					sb.WriteString(b)
+					i += n
+					continue
+				}
+				sb.WriteByte(s[i])
+				i++
+			}
+			elems = append(elems, sb.String())
+		}
+	}
+}
+
+// formatList joins elems into a Tcl list, quoting them as needed
+func formatList(elems []string) string {
+	quoted := make([]string, len(elems))
+	for i, e := range elems {
+		quoted[i] = listElement(e)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// listElement quotes s so that it is a single list element
+func listElement(s string) string {
+	if s == "" {
+		return "{}"
+	}
+	if !strings.ContainsAny(s, " \t\n\r\v\f;$[]\"{}\\") && s[0] != '#' {
+		return s
+	}
+	if bracesBalanced(s) && !strings.HasSuffix(s, "\\") {
+		return "{" + s + "}"
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case ' ', ';', '$', '[', ']', '"', '{', '}', '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case '\n':
+			sb.WriteString("\\n")
+		case '\t':
+			sb.WriteString("\\t")
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// bracesBalanced is true if s can be put in braces as is
+func bracesBalanced(s string) bool {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth < 0 {
+				return false
+			}
+		}
+	}
+	return depth == 0
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\v' || c == '\f'
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
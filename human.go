@@ -0,0 +1,111 @@
+/*
+File summary: Human-like typing, like expect's send -h
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"math"
+	"math/rand"
+	"time"
+	"unicode"
+)
+
+// HumanTiming holds the parameters for SendHuman(). They are the same as
+// those in Tcl expect's send_human variable.
+// The delay before each character is drawn from a Weibull distribution with
+// the given average and shape and then clamped to Min and Max.
+type HumanTiming struct {
+	// Average is the average time between characters
+	Average time.Duration
+
+	// WordEnd is the average time before a character that follows the end
+	// of a word, which lets the pause between words be longer
+	WordEnd time.Duration
+
+	// Variability is the shape of the distribution. 0.1 is very variable,
+	// 1 is reasonably variable and 10 is almost invariable. Zero means 1.
+	Variability float64
+
+	// Min and Max bound each delay. Max of zero is unlimited.
+	Min time.Duration
+	Max time.Duration
+
+	// Rand is the random source. Set it to rand.New(rand.NewSource(seed))
+	// to get the same delays every run. If nil the math/rand top level
+	// functions are used. A *rand.Rand is not safe for concurrent use so do
+	// not share a HumanTiming between goroutines if Rand is set.
+	Rand *rand.Rand
+}
+
+// DefaultHumanTiming is the send_human example from the expect man page:
+// {.1 .3 1 .05 2}
+var DefaultHumanTiming = HumanTiming{
+	Average:     100 * time.Millisecond,
+	WordEnd:     300 * time.Millisecond,
+	Variability: 1,
+	Min:         50 * time.Millisecond,
+	Max:         2 * time.Second,
+}
+
+// Delay returns the time to wait before typing a character that follows
+// prev. Pass 0 for prev for the first character.
+func (h *HumanTiming) Delay(prev rune) time.Duration {
+	average := h.Average
+	if prev != 0 && isWordEnd(prev) {
+		average = h.WordEnd
+	}
+	shape := h.Variability
+	if shape <= 0 {
+		shape = 1
+	}
+
+	u := 0.0
+	if h.Rand != nil {
+		u = h.Rand.Float64()
+	} else {
+		u = rand.Float64()
+	}
+	// Scale the Weibull so its mean is average
+	scale := float64(average) / math.Gamma(1+1/shape)
+	d := time.Duration(scale * math.Pow(-math.Log(1-u), 1/shape))
+
+	if d < h.Min {
+		d = h.Min
+	}
+	if h.Max > 0 && d > h.Max {
+		d = h.Max
+	}
+	return d
+}
+
+// isWordEnd is true if r ends a word
+func isWordEnd(r rune) bool {
+	return unicode.IsSpace(r) || unicode.IsPunct(r)
+}
+
+// SendHuman sends s rune by rune with a human-like delay before each, see
+// HumanTiming. If h is nil DefaultHumanTiming is used.
+// Note: the return is the number of bytes sent not the number of runes sent
+func (exp *Expect) SendHuman(h *HumanTiming, s string) (int, error) {
+	if h == nil {
+		h = &DefaultHumanTiming
+	}
+	sent := 0
+	prev := rune(0)
+	for _, r := range s {
+		time.Sleep(h.Delay(prev))
+		n, err := exp.Write([]byte(string(r)))
+		sent += n
+		if err != nil {
+			return sent, err
+		}
+		prev = r
+	}
+	return sent, nil
+}
@@ -0,0 +1,90 @@
+/*
+File summary: go test of human-like typing
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func Test_HumanTimingDelay(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	newTiming := func(seed int64) *HumanTiming {
+		h := DefaultHumanTiming
+		h.Rand = rand.New(rand.NewSource(seed))
+		return &h
+	}
+
+	t.Log("the same seed must give the same delays")
+	h1, h2 := newTiming(42), newTiming(42)
+	for i := 0; i < 100; i++ {
+		d1, d2 := h1.Delay('a'), h2.Delay('a')
+		if d1 != d2 {
+			t.Errorf("delay %d differs: %s and %s", i, d1, d2)
+			break
+		}
+	}
+
+	h := newTiming(1)
+	var total, wordTotal time.Duration
+	n := 2000
+	for i := 0; i < n; i++ {
+		d := h.Delay('a')
+		if d < h.Min || d > h.Max {
+			t.Errorf("delay %s outside %s to %s", d, h.Min, h.Max)
+		}
+		total += d
+		wordTotal += h.Delay(' ')
+	}
+	average, wordAverage := total/time.Duration(n), wordTotal/time.Duration(n)
+	t.Logf("average %s, after word endings %s", average, wordAverage)
+	if average < 80*time.Millisecond || average > 150*time.Millisecond {
+		t.Errorf("average %s is not close to %s", average, h.Average)
+	}
+	if wordAverage < 250*time.Millisecond || wordAverage > 350*time.Millisecond {
+		t.Errorf("average after word endings %s is not close to %s", wordAverage, h.WordEnd)
+	}
+}
+
+func Test_SendHuman(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	t.Logf("starting %s", prog)
+	exp, err := NewExpect(prog)
+	if err != nil {
+		t.Errorf("NewExpect failed %s", err)
+		return
+	}
+	exp.SetTimeoutSecs(10) // Shouldn't happen
+	exp.Expect("Enter test name:")
+
+	h := &HumanTiming{
+		Average:     10 * time.Millisecond,
+		WordEnd:     30 * time.Millisecond,
+		Variability: 1,
+		Max:         50 * time.Millisecond,
+		Rand:        rand.New(rand.NewSource(1)),
+	}
+	sent, err := exp.SendHuman(h, "HELLO\r")
+	if err != nil || sent != 6 {
+		t.Errorf("SendHuman sent %d: %s", sent, err)
+	}
+
+	pat := "I saw hello"
+	n, found, err := exp.Expect(pat)
+	checkResultStr(t, pat, 0, n, found, err)
+
+	exp.SendEOF()
+	showWaitResult(t, exp)
+}
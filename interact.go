@@ -0,0 +1,136 @@
+/*
+File summary: Hand the process over to the user as expect's interact does
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/kr/pty"
+)
+
+// InteractChunk is the most output Interact() collects before writing it out
+var InteractChunk = 4096
+
+// Interact hands the process over to the user. What is read from in is sent
+// to the process and what the process writes is written to out, until the
+// process closes its output, in ends or ctx is done. Output already read but
+// not yet matched by Expect() is written to out first.
+// If in is a terminal it is put in raw mode, so that each key is passed on as
+// it is typed, and its window size is given to the pty. The terminal settings
+// are restored before Interact returns.
+// Reading from in cannot always be interrupted. If in does not support read
+// deadlines, as os.Stdin on a terminal usually does not, the next read from in
+// after Interact returns is lost.
+// Nil is returned when the process closes its output or in ends, otherwise
+// ctx.Err() or the read error.
+func (exp *Expect) Interact(ctx context.Context, in io.Reader, out io.Writer) error {
+	if f, ok := in.(*os.File); ok {
+		if saved, err := getTermios(f); err == nil {
+			raw := *saved
+			raw.Raw()
+			if setTermios(f, &raw) == nil {
+				defer setTermios(f, saved)
+				pty.InheritSize(f, exp.File)
+			}
+		}
+	}
+
+	exp.lockRead()
+	defer exp.unlockRead()
+	if exp.Buffer.Len() > 0 {
+		if _, err := out.Write(exp.takeBuffer()); err != nil {
+			return err
+		}
+	}
+	if exp.Eof {
+		return nil
+	}
+
+	// Copy the user's input to the process. Once stopped any input read is
+	// dropped rather than sent after Interact has returned.
+	var stopped atomic.Bool
+	inDone := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := in.Read(buf)
+			if n > 0 && !stopped.Load() {
+				if _, werr := exp.Write(buf[:n]); werr != nil {
+					inDone <- werr
+					return
+				}
+			}
+			if err != nil {
+				inDone <- err
+				return
+			}
+		}
+	}()
+	defer func() {
+		stopped.Store(true)
+		if d, ok := in.(interface{ SetReadDeadline(time.Time) error }); ok {
+			d.SetReadDeadline(time.Now())
+		}
+	}()
+
+	var cancelled <-chan struct{}
+	if ctx != nil {
+		cancelled = ctx.Done()
+	}
+	chunk := make([]byte, 0, InteractChunk)
+	for {
+		select {
+		case <-cancelled:
+			return ctx.Err()
+		case err := <-inDone:
+			if errors.Is(err, io.EOF) || errors.Is(err, os.ErrDeadlineExceeded) {
+				return nil
+			}
+			return err
+		case boe := <-exp.bytesIn:
+			// Collect whatever else has already arrived so that out is
+			// written a chunk rather than a byte at a time
+			chunk = chunk[:0]
+		collect:
+			for {
+				switch {
+				case boe.err != nil:
+					exp.Eof = true
+					exp.endBackgrounds()
+					out.Write(chunk)
+					return boe.err
+				case boe.isEOF:
+					exp.Eof = true
+					exp.endBackgrounds()
+					_, err := out.Write(chunk)
+					return err
+				case boe.isByte:
+					chunk = append(chunk, boe.b)
+				}
+				if len(chunk) >= InteractChunk {
+					break
+				}
+				select {
+				case boe = <-exp.bytesIn:
+				default:
+					break collect
+				}
+			}
+			if _, err := out.Write(chunk); err != nil {
+				return err
+			}
+		}
+	}
+}
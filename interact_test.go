@@ -0,0 +1,86 @@
+/*
+File summary: go test of Interact
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Interact(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	exp, err := NewExpect("sh", "-c", "echo ready; read a; echo got $a")
+	if err != nil {
+		t.Fatalf("NewExpect failed %s", err)
+	}
+	defer exp.Kill()
+	exp.SetTimeout(5 * time.Second)
+	if n, _, err := exp.Expect("ready"); n != 0 {
+		t.Fatalf("Expect ready failed %d %v", n, err)
+	}
+
+	// The input stays open so Interact ends when the process does
+	r, w := io.Pipe()
+	defer w.Close()
+	go w.Write([]byte("typed\r"))
+	var out bytes.Buffer
+	if err := exp.Interact(context.Background(), r, &out); err != nil {
+		t.Errorf("Interact failed %s", err)
+	}
+	t.Logf("Interact output %q", out.String())
+	if !strings.HasPrefix(out.String(), "\r\n") || !strings.Contains(out.String(), "got typed") {
+		t.Errorf("Interact output is %q", out.String())
+	}
+	if !exp.Eof {
+		t.Errorf("Interact did not set Eof")
+	}
+}
+
+func Test_InteractEnds(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	exp, err := NewExpect("cat")
+	if err != nil {
+		t.Fatalf("NewExpect failed %s", err)
+	}
+	defer exp.Kill()
+
+	// The end of the input ends Interact, leaving the process running
+	var out bytes.Buffer
+	if err := exp.Interact(context.Background(), strings.NewReader(""), &out); err != nil {
+		t.Errorf("Interact at EOF failed %s", err)
+	}
+	if exp.Exited() {
+		t.Errorf("process exited")
+	}
+
+	// As does ctx
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	r, w := io.Pipe()
+	defer w.Close()
+	if err := exp.Interact(ctx, r, &out); err != context.DeadlineExceeded {
+		t.Errorf("Interact with ctx is %v", err)
+	}
+
+	// After which Expect carries on
+	exp.SetTimeout(5 * time.Second)
+	exp.Send("after\r")
+	if n, _, err := exp.Expect("after"); n != 0 {
+		t.Errorf("Expect after Interact failed %d %v", n, err)
+	}
+}
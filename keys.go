@@ -0,0 +1,425 @@
+/*
+File summary: Named key encoding for sending arrows, function keys etc
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unicode"
+	"unicode/utf8"
+)
+
+// KeyCode identifies a key that does not simply type a character
+type KeyCode int
+
+const (
+	// KeyRune is a key that types Key.Rune
+	KeyRune KeyCode = iota
+	KeyEnter
+	KeyTab
+	KeyBackspace
+	KeyEscape
+	KeySpace
+	KeyUp
+	KeyDown
+	KeyRight
+	KeyLeft
+	KeyHome
+	KeyEnd
+	KeyInsert
+	KeyDelete
+	KeyPageUp
+	KeyPageDown
+	KeyF1
+	KeyF2
+	KeyF3
+	KeyF4
+	KeyF5
+	KeyF6
+	KeyF7
+	KeyF8
+	KeyF9
+	KeyF10
+	KeyF11
+	KeyF12
+	// KeyKP0 to KeyKP9 are the keypad digits
+	KeyKP0
+	KeyKP1
+	KeyKP2
+	KeyKP3
+	KeyKP4
+	KeyKP5
+	KeyKP6
+	KeyKP7
+	KeyKP8
+	KeyKP9
+	KeyKPEnter
+	KeyKPPlus
+	KeyKPMinus
+	KeyKPMultiply
+	KeyKPDivide
+	KeyKPDecimal
+	KeyKPEqual
+)
+
+// Modifier is a set of modifier keys held down with a Key
+type Modifier int
+
+const (
+	ModShift Modifier = 1 << iota
+	ModAlt
+	ModCtrl
+)
+
+// Key is a key press, possibly with modifiers, for SendKeys()
+type Key struct {
+	Code KeyCode
+
+	// Rune is the character typed when Code is KeyRune
+	Rune rune
+
+	Mod Modifier
+}
+
+// KeyOf returns the Key for code with no modifiers
+func KeyOf(code KeyCode) Key {
+	return Key{Code: code}
+}
+
+// RuneKey returns the Key that types r
+func RuneKey(r rune) Key {
+	return Key{Code: KeyRune, Rune: r}
+}
+
+// With returns k with the modifiers mod added
+func (k Key) With(mod Modifier) Key {
+	k.Mod |= mod
+	return k
+}
+
+// keyNames maps the names used in key specs to KeyCodes. Names are matched
+// ignoring case.
+var keyNames = map[string]KeyCode{
+	"enter": KeyEnter, "cr": KeyEnter, "return": KeyEnter,
+	"tab":       KeyTab,
+	"bs":        KeyBackspace,
+	"backspace": KeyBackspace,
+	"esc":       KeyEscape,
+	"escape":    KeyEscape,
+	"space":     KeySpace,
+	"up":        KeyUp,
+	"down":      KeyDown,
+	"right":     KeyRight,
+	"left":      KeyLeft,
+	"home":      KeyHome,
+	"end":       KeyEnd,
+	"insert":    KeyInsert, "ins": KeyInsert,
+	"delete": KeyDelete, "del": KeyDelete,
+	"pageup": KeyPageUp, "pgup": KeyPageUp,
+	"pagedown": KeyPageDown, "pgdn": KeyPageDown,
+	"f1": KeyF1, "f2": KeyF2, "f3": KeyF3, "f4": KeyF4,
+	"f5": KeyF5, "f6": KeyF6, "f7": KeyF7, "f8": KeyF8,
+	"f9": KeyF9, "f10": KeyF10, "f11": KeyF11, "f12": KeyF12,
+	"kp0": KeyKP0, "kp1": KeyKP1, "kp2": KeyKP2, "kp3": KeyKP3, "kp4": KeyKP4,
+	"kp5": KeyKP5, "kp6": KeyKP6, "kp7": KeyKP7, "kp8": KeyKP8, "kp9": KeyKP9,
+	"kpenter":    KeyKPEnter,
+	"kpplus":     KeyKPPlus,
+	"kpminus":    KeyKPMinus,
+	"kpmultiply": KeyKPMultiply,
+	"kpdivide":   KeyKPDivide,
+	"kpdecimal":  KeyKPDecimal,
+	"kpequal":    KeyKPEqual,
+}
+
+// ParseKeys converts a key spec into Keys. Plain text in the spec types
+// itself and named keys go in angle brackets, optionally with modifiers, for
+// example:
+//
+//	"ls<Enter>", "<Up><Up><Enter>", "<C-c>", "<A-f>", "<C-S-Left>", "<lt>"
+//
+// The modifiers are C- (Ctrl), A- or M- (Alt) and S- (Shift). <lt> types a <.
+// Key names are matched ignoring case, see keyNames for the full list.
+func ParseKeys(spec string) ([]Key, error) {
+	var keys []Key
+	for len(spec) > 0 {
+		if spec[0] != '<' {
+			r, size := utf8.DecodeRuneInString(spec)
+			keys = append(keys, RuneKey(r))
+			spec = spec[size:]
+			continue
+		}
+		end := strings.IndexByte(spec, '>')
+		if end < 0 {
+			return nil, fmt.Errorf("expect: unterminated key name in %q", spec)
+		}
+		key, err := parseKeyName(spec[1:end])
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+		spec = spec[end+1:]
+	}
+	return keys, nil
+}
+
+// parseKeyName parses the inside of a <...> in a key spec
+func parseKeyName(name string) (Key, error) {
+	var mod Modifier
+	rest := name
+	for len(rest) > 2 && rest[1] == '-' {
+		switch rest[0] {
+		case 'C', 'c':
+			mod |= ModCtrl
+		case 'A', 'a', 'M', 'm':
+			mod |= ModAlt
+		case 'S', 's':
+			mod |= ModShift
+		default:
+			return Key{}, fmt.Errorf("expect: unknown modifier in <%s>", name)
+		}
+		rest = rest[2:]
+	}
+	if strings.EqualFold(rest, "lt") {
+		return RuneKey('<').With(mod), nil
+	}
+	if code, ok := keyNames[strings.ToLower(rest)]; ok {
+		return KeyOf(code).With(mod), nil
+	}
+	if r, size := utf8.DecodeRuneInString(rest); size == len(rest) && mod != 0 {
+		return RuneKey(r).With(mod), nil
+	}
+	return Key{}, fmt.Errorf("expect: unknown key <%s>", name)
+}
+
+// keyModes are the terminal modes that change what keys and pastes send.
+// They are tracked by watching for the escape sequences that set them in the
+// output of the command.
+type keyModes struct {
+	// cursorApp is DECCKM, set by ESC[?1h and cleared by ESC[?1l
+	cursorApp atomic.Bool
+
+	// keypadApp is DECKPAM, set by ESC= and cleared by ESC>
+	keypadApp atomic.Bool
+
+	// bracketedPaste is set by ESC[?2004h and cleared by ESC[?2004l
+	bracketedPaste atomic.Bool
+
+	// Parser state, only used by the expectReader goroutine
+	state  int
+	params []byte
+}
+
+const (
+	kmGround = iota
+	kmEscape
+	kmCSI
+)
+
+// scan updates the modes from the next byte of output
+func (km *keyModes) scan(b byte) {
+	switch km.state {
+	case kmGround:
+		if b == 0x1b {
+			km.state = kmEscape
+		}
+	case kmEscape:
+		km.state = kmGround
+		switch b {
+		case '[':
+			km.state = kmCSI
+			km.params = km.params[:0]
+		case '=':
+			km.keypadApp.Store(true)
+		case '>':
+			km.keypadApp.Store(false)
+		case 'c':
+			// RIS, full reset
+			km.cursorApp.Store(false)
+			km.keypadApp.Store(false)
+			km.bracketedPaste.Store(false)
+		case 0x1b:
+			km.state = kmEscape
+		}
+	case kmCSI:
+		if b >= 0x30 && b <= 0x3f {
+			if len(km.params) < 64 {
+				km.params = append(km.params, b)
+			}
+			return
+		}
+		if b >= 0x20 && b <= 0x2f {
+			// Intermediate bytes, not used by the modes tracked
+			return
+		}
+		km.state = kmGround
+		if (b == 'h' || b == 'l') && len(km.params) > 0 && km.params[0] == '?' {
+			for _, p := range strings.Split(string(km.params[1:]), ";") {
+				switch p {
+				case "1":
+					km.cursorApp.Store(b == 'h')
+				case "2004":
+					km.bracketedPaste.Store(b == 'h')
+				}
+			}
+		}
+	}
+}
+
+// ApplicationCursorKeys returns true if the command has put the terminal in
+// application cursor key mode (DECCKM) so the cursor keys send ESC O A etc
+func (exp *Expect) ApplicationCursorKeys() bool {
+	return exp.keyModes.cursorApp.Load()
+}
+
+// ApplicationKeypad returns true if the command has put the terminal in
+// application keypad mode (DECKPAM)
+func (exp *Expect) ApplicationKeypad() bool {
+	return exp.keyModes.keypadApp.Load()
+}
+
+// EncodeKey returns the bytes an xterm sends for k in the current modes
+func (exp *Expect) EncodeKey(k Key) []byte {
+	return encodeKey(k, exp.ApplicationCursorKeys(), exp.ApplicationKeypad())
+}
+
+// SendKeys sends the bytes for each of keys, see EncodeKey()
+func (exp *Expect) SendKeys(keys ...Key) (int, error) {
+	var out []byte
+	for _, k := range keys {
+		out = append(out, exp.EncodeKey(k)...)
+	}
+	return exp.Write(out)
+}
+
+// SendKeySpec parses spec with ParseKeys() and sends the keys
+func (exp *Expect) SendKeySpec(spec string) (int, error) {
+	keys, err := ParseKeys(spec)
+	if err != nil {
+		return 0, err
+	}
+	return exp.SendKeys(keys...)
+}
+
+// csiFinal are the keys sent as CSI <final> or SS3 <final>
+var csiFinal = map[KeyCode]byte{
+	KeyUp: 'A', KeyDown: 'B', KeyRight: 'C', KeyLeft: 'D',
+	KeyHome: 'H', KeyEnd: 'F',
+	KeyF1: 'P', KeyF2: 'Q', KeyF3: 'R', KeyF4: 'S',
+}
+
+// tildeCodes are the keys sent as CSI <code> ~
+var tildeCodes = map[KeyCode]int{
+	KeyInsert: 2, KeyDelete: 3, KeyPageUp: 5, KeyPageDown: 6,
+	KeyF5: 15, KeyF6: 17, KeyF7: 18, KeyF8: 19,
+	KeyF9: 20, KeyF10: 21, KeyF11: 23, KeyF12: 24,
+}
+
+// keypad maps the keypad keys to the character sent in numeric mode and the
+// final byte of the SS3 sequence sent in application mode
+var keypad = map[KeyCode][2]byte{
+	KeyKP0: {'0', 'p'}, KeyKP1: {'1', 'q'}, KeyKP2: {'2', 'r'},
+	KeyKP3: {'3', 's'}, KeyKP4: {'4', 't'}, KeyKP5: {'5', 'u'},
+	KeyKP6: {'6', 'v'}, KeyKP7: {'7', 'w'}, KeyKP8: {'8', 'x'},
+	KeyKP9: {'9', 'y'}, KeyKPEnter: {'\r', 'M'}, KeyKPPlus: {'+', 'k'},
+	KeyKPMinus: {'-', 'm'}, KeyKPMultiply: {'*', 'j'},
+	KeyKPDivide: {'/', 'o'}, KeyKPDecimal: {'.', 'n'}, KeyKPEqual: {'=', 'X'},
+}
+
+// encodeKey is EncodeKey() with the modes passed in
+func encodeKey(k Key, cursorApp, keypadApp bool) []byte {
+	// xterm's modifier parameter
+	param := 1
+	if k.Mod&ModShift != 0 {
+		param++
+	}
+	if k.Mod&ModAlt != 0 {
+		param += 2
+	}
+	if k.Mod&ModCtrl != 0 {
+		param += 4
+	}
+
+	if final, ok := csiFinal[k.Code]; ok {
+		isFn := k.Code >= KeyF1 && k.Code <= KeyF4
+		switch {
+		case param > 1:
+			return []byte("\x1b[1;" + strconv.Itoa(param) + string(final))
+		case isFn || cursorApp:
+			return []byte{0x1b, 'O', final}
+		default:
+			return []byte{0x1b, '[', final}
+		}
+	}
+	if code, ok := tildeCodes[k.Code]; ok {
+		s := "\x1b[" + strconv.Itoa(code)
+		if param > 1 {
+			s += ";" + strconv.Itoa(param)
+		}
+		return []byte(s + "~")
+	}
+	if kp, ok := keypad[k.Code]; ok {
+		if keypadApp {
+			return []byte{0x1b, 'O', kp[1]}
+		}
+		return altPrefix(k.Mod, []byte{kp[0]})
+	}
+
+	switch k.Code {
+	case KeyEnter:
+		return altPrefix(k.Mod, []byte{'\r'})
+	case KeyTab:
+		if k.Mod&ModShift != 0 {
+			return []byte("\x1b[Z")
+		}
+		return altPrefix(k.Mod, []byte{'\t'})
+	case KeyBackspace:
+		if k.Mod&ModCtrl != 0 {
+			return altPrefix(k.Mod, []byte{0x08})
+		}
+		return altPrefix(k.Mod, []byte{0x7f})
+	case KeyEscape:
+		return altPrefix(k.Mod, []byte{0x1b})
+	case KeySpace:
+		if k.Mod&ModCtrl != 0 {
+			return altPrefix(k.Mod, []byte{0})
+		}
+		return altPrefix(k.Mod, []byte{' '})
+	}
+
+	// KeyRune
+	r := k.Rune
+	if k.Mod&ModShift != 0 {
+		r = unicode.ToUpper(r)
+	}
+	if k.Mod&ModCtrl != 0 {
+		switch {
+		case r >= 'a' && r <= 'z':
+			r = r - 'a' + 1
+		case r >= '@' && r <= '_':
+			r = r - '@'
+		case r == '?':
+			r = 0x7f
+		case r == ' ':
+			r = 0
+		}
+	}
+	return altPrefix(k.Mod, []byte(string(r)))
+}
+
+// altPrefix puts ESC in front of b if Alt is held, as xterm does with
+// metaSendsEscape
+func altPrefix(mod Modifier, b []byte) []byte {
+	if mod&ModAlt != 0 {
+		return append([]byte{0x1b}, b...)
+	}
+	return b
+}
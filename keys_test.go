@@ -0,0 +1,113 @@
+/*
+File summary: go test of named key encoding
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"testing"
+)
+
+func Test_EncodeKey(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	tests := []struct {
+		spec      string
+		cursorApp bool
+		keypadApp bool
+		expected  string
+	}{
+		{"ls<Enter>", false, false, "ls\r"},
+		{"<Up><Down><Right><Left>", false, false, "\x1b[A\x1b[B\x1b[C\x1b[D"},
+		{"<Up><Home>", true, false, "\x1bOA\x1bOH"},
+		{"<C-Left><S-Up><C-S-Right>", true, false, "\x1b[1;5D\x1b[1;2A\x1b[1;6C"},
+		{"<F1><F4><F5><F12>", false, false, "\x1bOP\x1bOS\x1b[15~\x1b[24~"},
+		{"<S-F3>", false, false, "\x1b[1;2R"},
+		{"<PgUp><Del><C-Del>", false, false, "\x1b[5~\x1b[3~\x1b[3;5~"},
+		{"<C-c><C-d><C-[><C-Space>", false, false, "\x03\x04\x1b\x00"},
+		{"<A-f><M-b><A-Enter>", false, false, "\x1bf\x1bb\x1b\r"},
+		{"<S-Tab><Tab><BS><Esc>", false, false, "\x1b[Z\t\x7f\x1b"},
+		{"<KP1><KPEnter>", false, false, "1\r"},
+		{"<KP1><KPEnter>", false, true, "\x1bOq\x1bOM"},
+		{"<lt>tag>", false, false, "<tag>"},
+		{"世界", false, false, "世界"},
+	}
+	for _, test := range tests {
+		keys, err := ParseKeys(test.spec)
+		if err != nil {
+			t.Errorf("ParseKeys(%q) failed %s", test.spec, err)
+			continue
+		}
+		var got []byte
+		for _, k := range keys {
+			got = append(got, encodeKey(k, test.cursorApp, test.keypadApp)...)
+		}
+		if string(got) != test.expected {
+			t.Errorf("%q encoded as %q not %q", test.spec, got, test.expected)
+		}
+	}
+
+	for _, bad := range []string{"<Up", "<Nope>", "<X-a>"} {
+		if _, err := ParseKeys(bad); err == nil {
+			t.Errorf("ParseKeys(%q) did not fail", bad)
+		}
+	}
+}
+
+func Test_KeyModes(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	var km keyModes
+	scan := func(s string) {
+		for _, b := range []byte(s) {
+			km.scan(b)
+		}
+	}
+	scan("hello \x1b[?1049h\x1b[?1h\x1b=")
+	if !km.cursorApp.Load() || !km.keypadApp.Load() {
+		t.Errorf("application modes not set")
+	}
+	scan("\x1b[1;1H\x1b[?1l\x1b>")
+	if km.cursorApp.Load() || km.keypadApp.Load() {
+		t.Errorf("application modes not cleared")
+	}
+	scan("\x1b[?25;1h")
+	if !km.cursorApp.Load() {
+		t.Errorf("application cursor mode not set from a parameter list")
+	}
+	scan("\x1b[?2004h")
+	if !km.bracketedPaste.Load() {
+		t.Errorf("bracketed paste mode not set")
+	}
+}
+
+func Test_SendKeySpec(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	t.Logf("starting %s", prog)
+	exp, err := NewExpect(prog)
+	if err != nil {
+		t.Errorf("NewExpect failed %s", err)
+		return
+	}
+	exp.SetTimeoutSecs(10) // Shouldn't happen
+	exp.Expect("Enter test name:")
+
+	t.Log("sending HELLX<BS>O<Enter>, the line discipline will erase the X")
+	exp.SendKeySpec("HELLX<BS>O<Enter>")
+
+	pat := "I saw hello"
+	n, found, err := exp.Expect(pat)
+	checkResultStr(t, pat, 0, n, found, err)
+
+	exp.SendEOF()
+	showWaitResult(t, exp)
+}
@@ -0,0 +1,476 @@
+/*
+File summary: Incremental matching of Expect's patterns as input arrives
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"bytes"
+	"regexp"
+	"regexp/syntax"
+	"unicode/utf8"
+)
+
+// matcher finds the first of Expect()'s patterns to match Buffer as it grows
+// a byte at a time, giving the same answer as matchPatterns() without
+// searching the whole of Buffer again for every byte.
+// Nothing can have matched before the new byte, or Expect() would have
+// returned, so a new match must involve the new byte. The strings are found
+// together by an Aho-Corasick automaton fed one byte at a time. Each regexp
+// is only run over the end of Buffer where a new match can be, see
+// reWindow, and only once a literal every match must contain has been seen
+// there. The literals are found by the same automaton. A regexp that can
+// match across lines and has no limit on its length, such as
+// `(?s)start.*end`, still has to search all of Buffer.
+type matcher struct {
+	patterns []interface{}
+
+	// ac finds the non-empty strings and the regexps' literals, acState is
+	// where it has got to
+	ac      *ahoCorasick
+	acState int32
+
+	// res are the regexps in the order they were passed
+	res []reWindow
+
+	// rescan is set when Buffer has changed other than by adding to its end,
+	// so the next call of next() has to search all of it as something may
+	// now match at the start or across what was removed. always is set if
+	// a pattern is "", which matches at once.
+	rescan bool
+	always bool
+
+	// context, if not nil, is the last rune of the input discarded from the
+	// front of Buffer, which a regexp's assertions at its start must see
+	context []byte
+}
+
+// reWindow is a regexp and how far back from the end of Buffer a new match
+// can start
+type reWindow struct {
+	index int
+	re    *regexp.Regexp
+
+	// oneLine is true if a match cannot span a newline, so a new match is
+	// on the last line of Buffer
+	oneLine bool
+
+	// maxLen, if not -1, is the longest a match can be. It is only set if
+	// the regexp has no assertions that look at the bytes around a match.
+	maxLen int
+
+	// literal, if not "", is in every match and seen is where in Buffer the
+	// last one found ends, or -1
+	literal string
+	seen    int
+
+	// after is re wrapped to match after a rune of context, see find()
+	after *regexp.Regexp
+}
+
+// newMatcher prepares to match patterns. Pseudo-patterns are ignored as for
+// matchPatterns().
+func newMatcher(patterns []interface{}) *matcher {
+	m := &matcher{patterns: patterns}
+	var strs []string
+	var strIndex []int
+	for n, pattern := range patterns {
+		switch p := pattern.(type) {
+		case string:
+			if p == "" {
+				m.always = true
+				continue
+			}
+			strs = append(strs, p)
+			strIndex = append(strIndex, n)
+		case *regexp.Regexp:
+			rw := newReWindow(n, p)
+			if rw.literal != "" {
+				// A negative index marks a literal, see ahoCorasick
+				strs = append(strs, rw.literal)
+				strIndex = append(strIndex, -1-len(m.res))
+			}
+			m.res = append(m.res, rw)
+		}
+	}
+	if len(strs) > 0 {
+		m.ac = newAhoCorasick(strs, strIndex)
+	}
+	return m
+}
+
+// scan searches all of buf, as matchPatterns() does, and gets ready for
+// next() to carry on from the end of buf
+func (m *matcher) scan(buf []byte) (int, int, int) {
+	m.rescan = false
+	if m.ac != nil {
+		m.acState = 0
+		from := len(buf) - m.ac.maxLen
+		if from < 0 {
+			from = 0
+		}
+		for _, b := range buf[from:] {
+			m.acState = m.ac.step(m.acState, b)
+		}
+	}
+	for i := range m.res {
+		if rw := &m.res[i]; rw.literal != "" {
+			if rw.seen = bytes.LastIndex(buf, []byte(rw.literal)); rw.seen >= 0 {
+				rw.seen += len(rw.literal)
+			}
+		}
+	}
+	if m.context == nil {
+		return matchPatterns(m.patterns, buf)
+	}
+	r := 0
+	for n, pattern := range m.patterns {
+		switch p := pattern.(type) {
+		case string:
+			if start := bytes.Index(buf, []byte(p)); start >= 0 {
+				return n, start, start + len(p)
+			}
+		case *regexp.Regexp:
+			loc := m.res[r].find(buf, 0, m.context)
+			r++
+			if loc != nil {
+				return n, loc[0], loc[1]
+			}
+		}
+	}
+	return NotFound, 0, 0
+}
+
+// next returns the first pattern to match buf, and where, when the last byte
+// of buf has just been added. It returns NotFound if nothing matches.
+func (m *matcher) next(buf []byte) (int, int, int) {
+	if m.rescan || m.always || len(buf) == 0 {
+		return m.scan(buf)
+	}
+
+	found, start, end := NotFound, 0, 0
+	if m.ac != nil {
+		m.acState = m.ac.step(m.acState, buf[len(buf)-1])
+		if n := m.ac.out[m.acState]; n >= 0 {
+			found, end = m.ac.index[n], len(buf)
+			start = end - len(m.ac.strs[n])
+		}
+		for _, r := range m.ac.literals[m.acState] {
+			m.res[r].seen = len(buf)
+		}
+	}
+	for i := range m.res {
+		rw := &m.res[i]
+		if found >= 0 && rw.index > found {
+			break
+		}
+		from := rw.from(buf)
+		if rw.literal != "" && rw.seen-len(rw.literal) < from {
+			continue
+		}
+		if loc := rw.find(buf, from, m.context); loc != nil {
+			return rw.index, loc[0], loc[1]
+		}
+	}
+	return found, start, end
+}
+
+// newReWindow works out how far back a new match of re can start
+func newReWindow(index int, re *regexp.Regexp) reWindow {
+	rw := reWindow{index: index, re: re, maxLen: -1}
+	parsed, err := syntax.Parse(re.String(), syntax.Perl)
+	if err != nil {
+		return rw
+	}
+	parsed = parsed.Simplify()
+	if hasOp(parsed, syntax.OpBeginText) {
+		// Only matches at the start of Buffer, and fails quickly elsewhere,
+		// so has to be run over all of it
+		return rw
+	}
+	rw.oneLine = !canMatchNewline(parsed)
+	rw.literal = requiredLiteral(parsed)
+	rw.seen = -1
+	if !hasOp(parsed, syntax.OpBeginLine, syntax.OpEndLine, syntax.OpWordBoundary, syntax.OpNoWordBoundary) {
+		rw.maxLen = maxMatchLen(parsed)
+	}
+	return rw
+}
+
+// from returns where in buf to start looking for a new match
+func (rw *reWindow) from(buf []byte) int {
+	from := 0
+	if rw.maxLen >= 0 && len(buf) > rw.maxLen {
+		from = len(buf) - rw.maxLen
+	}
+	if rw.oneLine {
+		// The last line, back to where a word boundary or start of line
+		// looks the same as the start of the text. The newline just added
+		// cannot be part of a match.
+		for i := len(buf) - 2; i >= from; i-- {
+			if buf[i] == '\n' {
+				from = i + 1
+				break
+			}
+		}
+	}
+	return from
+}
+
+// find returns where re first matches buf from from onwards, or nil. When
+// from is 0 and context is not nil buf follows context rather than starting
+// the text, so ^ and \A cannot match at its start and \b sees context.
+// Otherwise buf[from-1], if any, is a newline or re has no assertions.
+func (rw *reWindow) find(buf []byte, from int, context []byte) []int {
+	if from == 0 && context != nil && rw.after == nil {
+		after, err := regexp.Compile(`\A(?s:.)(?s:.*?)(` + rw.re.String() + `)`)
+		if err != nil {
+			debugf("reWindow cannot match %s after context: %s", rw.re, err)
+			after = rw.re
+		}
+		rw.after = after
+	}
+	if from > 0 || context == nil || rw.after == rw.re {
+		loc := rw.re.FindIndex(buf[from:])
+		if loc == nil {
+			return nil
+		}
+		return []int{from + loc[0], from + loc[1]}
+	}
+	text := append(append(make([]byte, 0, len(context)+len(buf)), context...), buf...)
+	loc := rw.after.FindSubmatchIndex(text)
+	if loc == nil {
+		return nil
+	}
+	return []int{loc[2] - len(context), loc[3] - len(context)}
+}
+
+// hasOp reports whether re uses any of ops
+func hasOp(re *syntax.Regexp, ops ...syntax.Op) bool {
+	for _, op := range ops {
+		if re.Op == op {
+			return true
+		}
+	}
+	for _, sub := range re.Sub {
+		if hasOp(sub, ops...) {
+			return true
+		}
+	}
+	return false
+}
+
+// requiredLiteral returns the longest literal that is in every match of re,
+// or "" if it cannot tell
+func requiredLiteral(re *syntax.Regexp) string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase == 0 {
+			return string(re.Rune)
+		}
+	case syntax.OpCapture, syntax.OpPlus:
+		return requiredLiteral(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min > 0 {
+			return requiredLiteral(re.Sub[0])
+		}
+	case syntax.OpConcat:
+		longest := ""
+		for _, sub := range re.Sub {
+			if lit := requiredLiteral(sub); len(lit) > len(longest) {
+				longest = lit
+			}
+		}
+		return longest
+	}
+	return ""
+}
+
+// canMatchNewline reports whether a match of re can include a newline
+func canMatchNewline(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpAnyChar:
+		return true
+	case syntax.OpLiteral:
+		for _, r := range re.Rune {
+			if r == '\n' {
+				return true
+			}
+		}
+	case syntax.OpCharClass:
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			if re.Rune[i] <= '\n' && '\n' <= re.Rune[i+1] {
+				return true
+			}
+		}
+	}
+	for _, sub := range re.Sub {
+		if canMatchNewline(sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// maxMatchLen returns the most bytes a match of re can be, or -1 if there is
+// no limit
+func maxMatchLen(re *syntax.Regexp) int {
+	switch re.Op {
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase != 0 {
+			return len(re.Rune) * utf8.UTFMax
+		}
+		n := 0
+		for _, r := range re.Rune {
+			n += utf8.RuneLen(r)
+		}
+		return n
+	case syntax.OpCharClass:
+		if len(re.Rune) > 0 {
+			if n := utf8.RuneLen(re.Rune[len(re.Rune)-1]); n > 0 {
+				return n
+			}
+		}
+		return utf8.UTFMax
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return utf8.UTFMax
+	case syntax.OpCapture, syntax.OpQuest:
+		return maxMatchLen(re.Sub[0])
+	case syntax.OpStar, syntax.OpPlus:
+		if maxMatchLen(re.Sub[0]) == 0 {
+			return 0
+		}
+		return -1
+	case syntax.OpRepeat:
+		sub := maxMatchLen(re.Sub[0])
+		if sub < 0 || re.Max < 0 && sub > 0 {
+			return -1
+		}
+		if re.Max < 0 {
+			return 0
+		}
+		return sub * re.Max
+	case syntax.OpConcat, syntax.OpAlternate:
+		total := 0
+		for _, sub := range re.Sub {
+			n := maxMatchLen(sub)
+			if n < 0 {
+				return -1
+			}
+			if re.Op == syntax.OpConcat {
+				total += n
+			} else if n > total {
+				total = n
+			}
+		}
+		return total
+	}
+	// The empty-width assertions, OpEmptyMatch and OpNoMatch
+	return 0
+}
+
+// ahoCorasick is an Aho-Corasick automaton that finds a set of strings as
+// their last bytes arrive
+type ahoCorasick struct {
+	strs []string
+
+	// index is the pattern index of each string, or for a regexp's literal
+	// -1 less the regexp's place in matcher.res
+	index []int
+
+	// Each state has its transitions in next, the state for the longest
+	// proper suffix that is also a state in fail, in out the first pattern
+	// string that ends at it or -1 and in literals the regexps whose literal
+	// ends at it. root is next for state 0 with every byte filled in.
+	next     []map[byte]int32
+	fail     []int32
+	out      []int
+	literals [][]int32
+	root     [256]int32
+
+	maxLen int
+}
+
+// newAhoCorasick builds the automaton for strs
+func newAhoCorasick(strs []string, index []int) *ahoCorasick {
+	ac := &ahoCorasick{strs: strs, index: index}
+	ac.addState()
+	for n, s := range strs {
+		if len(s) > ac.maxLen {
+			ac.maxLen = len(s)
+		}
+		state := int32(0)
+		for i := 0; i < len(s); i++ {
+			nextState, ok := ac.next[state][s[i]]
+			if !ok {
+				nextState = ac.addState()
+				ac.next[state][s[i]] = nextState
+			}
+			state = nextState
+		}
+		if index[n] < 0 {
+			ac.literals[state] = append(ac.literals[state], int32(-1-index[n]))
+		} else if ac.out[state] < 0 {
+			ac.out[state] = n
+		}
+	}
+	for b, to := range ac.next[0] {
+		ac.root[b] = to
+	}
+
+	// Breadth first so each state's fail is done before its children's
+	queue := []int32{}
+	for _, child := range ac.next[0] {
+		queue = append(queue, child)
+	}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		for b, child := range ac.next[state] {
+			queue = append(queue, child)
+			fail := ac.fail[state]
+			for {
+				if to, ok := ac.next[fail][b]; ok && to != child {
+					ac.fail[child] = to
+					break
+				}
+				if fail == 0 {
+					break
+				}
+				fail = ac.fail[fail]
+			}
+			// A string ending at the suffix also ends here, the first
+			// of strs wins
+			if out := ac.out[ac.fail[child]]; out >= 0 && (ac.out[child] < 0 || out < ac.out[child]) {
+				ac.out[child] = out
+			}
+			ac.literals[child] = append(ac.literals[child], ac.literals[ac.fail[child]]...)
+		}
+	}
+	return ac
+}
+
+// addState adds a state returning its number
+func (ac *ahoCorasick) addState() int32 {
+	ac.next = append(ac.next, map[byte]int32{})
+	ac.fail = append(ac.fail, 0)
+	ac.out = append(ac.out, -1)
+	ac.literals = append(ac.literals, nil)
+	return int32(len(ac.next) - 1)
+}
+
+// step returns the state after b arrives in state
+func (ac *ahoCorasick) step(state int32, b byte) int32 {
+	for state != 0 {
+		if to, ok := ac.next[state][b]; ok {
+			return to
+		}
+		state = ac.fail[state]
+	}
+	return ac.root[b]
+}
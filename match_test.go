@@ -0,0 +1,225 @@
+/*
+File summary: go test and benchmarks of the incremental matcher
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"testing"
+)
+
+// matcherRegexps are regexps that exercise the different windows
+var matcherRegexps = []string{
+	`a+b`, `(?m)^ab$`, `\bab\b`, `a\Bb`, `[ab]{2,3}x`, `(?s)a.*x`, `a.*x`,
+	`^ab`, `x$`, `(?i)AB`, `a\s*b`, `b?`, `(?m)^$`, `x\n`, `a[^x]b`, `(ab|ba)-`,
+	`x-(ab)+`, `(?m)^b a$`, `(a-){2}x`, `b a\n`,
+}
+
+// Test_Matcher checks the matcher against matchPatterns() as Expect() uses
+// it, with matches removed from the buffer and a full buffer discarded
+func Test_Matcher(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	rnd := rand.New(rand.NewSource(1))
+	const alphabet = "ab\n -x"
+	randString := func(max int) string {
+		s := make([]byte, 1+rnd.Intn(max))
+		for i := range s {
+			s[i] = alphabet[rnd.Intn(len(alphabet))]
+		}
+		return string(s)
+	}
+
+	for run := 0; run < 300; run++ {
+		var patterns []interface{}
+		for n := 1 + rnd.Intn(6); n > 0; n-- {
+			if rnd.Intn(2) == 0 {
+				patterns = append(patterns, randString(4))
+			} else {
+				patterns = append(patterns, regexp.MustCompile(matcherRegexps[rnd.Intn(len(matcherRegexps))]))
+			}
+		}
+		matchMax := 0
+		if rnd.Intn(3) == 0 {
+			matchMax = 1 + rnd.Intn(8)
+		}
+
+		var buf bytes.Buffer
+		buf.WriteString(randString(5))
+		m := newMatcher(patterns)
+		n, start, end := m.scan(buf.Bytes())
+		for i := 0; i < 200; i++ {
+			if n >= 0 {
+				buf.Next(end)
+				m = newMatcher(patterns)
+				n, start, end = m.scan(buf.Bytes())
+				continue
+			}
+			buf.WriteByte(alphabet[rnd.Intn(len(alphabet))])
+			wantN, wantStart, wantEnd := matchPatterns(patterns, buf.Bytes())
+			n, start, end = m.next(buf.Bytes())
+			if n != wantN || n >= 0 && (start != wantStart || end != wantEnd) {
+				t.Fatalf("patterns %q on %q: next is %d %d-%d, matchPatterns is %d %d-%d",
+					patterns, buf.Bytes(), n, start, end, wantN, wantStart, wantEnd)
+			}
+			if n < 0 && matchMax > 0 && buf.Len() > matchMax {
+				buf.Next(buf.Len() - matchMax)
+				m.rescan = true
+			}
+		}
+	}
+}
+
+// Test_ExpectBuffered checks a match already in Buffer is found before one in
+// the input waiting to be read
+func Test_ExpectBuffered(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	exp := queuedExpect("first\r\n", "second\r\n")
+	pat := regexp.MustCompile(`[a-z]+\r`)
+	for _, want := range []string{"first\r", "second\r"} {
+		n, found, err := exp.Expect(pat)
+		if n != 0 || string(found) != want {
+			t.Errorf("Expect found %d %q %v not %q", n, found, err, want)
+		}
+	}
+}
+
+func Test_ReWindow(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	for _, tc := range []struct {
+		re      string
+		oneLine bool
+		maxLen  int
+		literal string
+	}{
+		{`password: `, true, 10, "password: "},
+		{`(?m)^\$ $`, true, -1, "$ "},
+		{`[#$] $`, true, 2, " "},
+		{`\d+%`, true, -1, "%"},
+		{`(?i)login:`, true, 24, ""},
+		{`a\s*b`, false, -1, "a"},
+		{`(?s)start.*end`, false, -1, "start"},
+		{`^ready`, false, -1, ""},
+		{`done\r\n`, false, 6, "done\r\n"},
+		{`(\d+) (failures|errors)`, true, -1, " "},
+	} {
+		rw := newReWindow(0, regexp.MustCompile(tc.re))
+		if rw.oneLine != tc.oneLine || rw.maxLen != tc.maxLen || rw.literal != tc.literal {
+			t.Errorf("window of %s is %v %d %q not %v %d %q", tc.re,
+				rw.oneLine, rw.maxLen, rw.literal, tc.oneLine, tc.maxLen, tc.literal)
+		}
+	}
+}
+
+// benchmarkPatterns are dozens of literals and a few typical regexps, none of
+// which match the output until its end
+func benchmarkPatterns() []interface{} {
+	var patterns []interface{}
+	for n := 0; n < 40; n++ {
+		patterns = append(patterns, fmt.Sprintf("error %02d:", n))
+	}
+	return append(patterns,
+		regexp.MustCompile(`(?m)^\$ $`),
+		regexp.MustCompile(`[Pp]assword: `),
+		regexp.MustCompile(`panic: \w+`),
+		regexp.MustCompile(`(\d+) failures`),
+		"ALL DONE",
+	)
+}
+
+// benchmarkOutput is size bytes of lines of output ending in ALL DONE
+func benchmarkOutput(size int) []byte {
+	var out bytes.Buffer
+	for n := 0; out.Len() < size; n++ {
+		fmt.Fprintf(&out, "line %d of the output with some words in it\r\n", n)
+	}
+	out.Truncate(size - len("ALL DONE"))
+	out.WriteString("ALL DONE")
+	return out.Bytes()
+}
+
+// benchmarkMatch feeds output to match a byte at a time, as Expect() does
+func benchmarkMatch(b *testing.B, size int, match func(patterns []interface{}, buf []byte) int) {
+	patterns := benchmarkPatterns()
+	output := benchmarkOutput(size)
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var buf bytes.Buffer
+		buf.Grow(size)
+		found := NotFound
+		for _, c := range output {
+			buf.WriteByte(c)
+			if found = match(patterns, buf.Bytes()); found >= 0 {
+				break
+			}
+		}
+		if found != len(patterns)-1 || buf.Len() != size {
+			b.Fatalf("found %d after %d bytes", found, buf.Len())
+		}
+	}
+}
+
+// Benchmark_Matcher shows the time per byte stays the same as the output
+// grows
+func Benchmark_Matcher(b *testing.B) {
+	for _, size := range []int{1 << 20, 4 << 20, 16 << 20} {
+		b.Run(fmt.Sprintf("%dMB", size>>20), func(b *testing.B) {
+			var m *matcher
+			benchmarkMatch(b, size, func(patterns []interface{}, buf []byte) int {
+				if m == nil || len(buf) == 1 {
+					m = newMatcher(patterns)
+				}
+				n, _, _ := m.next(buf)
+				return n
+			})
+		})
+	}
+}
+
+// Benchmark_MatchPatterns is the search of the whole buffer for every byte
+// that Expect() used to do, which slows down as the output grows
+func Benchmark_MatchPatterns(b *testing.B) {
+	for _, size := range []int{4 << 10, 16 << 10} {
+		b.Run(fmt.Sprintf("%dKB", size>>10), func(b *testing.B) {
+			benchmarkMatch(b, size, func(patterns []interface{}, buf []byte) int {
+				n, _, _ := matchPatterns(patterns, buf)
+				return n
+			})
+		})
+	}
+}
+
+// Benchmark_ExpectOutput is Expect() reading output from a process
+func Benchmark_ExpectOutput(b *testing.B) {
+	for _, size := range []int{1 << 20, 4 << 20} {
+		b.Run(fmt.Sprintf("%dMB", size>>20), func(b *testing.B) {
+			patterns := benchmarkPatterns()
+			b.SetBytes(int64(size))
+			for i := 0; i < b.N; i++ {
+				exp, err := NewExpect("sh", "-c", fmt.Sprintf("yes 'some output from the process' | head -c %d; echo; echo ALL DONE", size))
+				if err != nil {
+					b.Fatalf("NewExpect failed %s", err)
+				}
+				if n, _, err := exp.Expect(patterns...); n != len(patterns)-1 {
+					b.Fatalf("Expect failed %d %v", n, err)
+				}
+				exp.Kill()
+			}
+		})
+	}
+}
@@ -0,0 +1,256 @@
+/*
+File summary: Copy the output to many writers and hooks
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"io"
+	"sync"
+)
+
+// OutputPolicy is what an output writer does when it cannot keep up
+type OutputPolicy int
+
+const (
+	// Backpressure writes the output as it is read, before Expect() sees
+	// it. A slow writer slows down reading, and so the process once the
+	// pty's buffer is full.
+	Backpressure OutputPolicy = iota
+
+	// Drop queues the output for a goroutine that writes it. Output that
+	// does not fit in the queue is dropped so a slow writer never holds up
+	// reading.
+	Drop
+)
+
+// OutputOptions control AddOutputWriter()
+type OutputOptions struct {
+	// Policy is Backpressure or Drop
+	Policy OutputPolicy
+
+	// QueueSize is the most bytes queued for a Drop writer. Zero means
+	// 64KiB.
+	QueueSize int
+
+	// OnDrop, if not nil, is called with any output a Drop writer drops. It
+	// is called by the goroutine reading the output so must be quick.
+	OnDrop func(dropped []byte)
+}
+
+// outputWriter is a writer added by AddOutputWriter()
+type outputWriter struct {
+	w    io.Writer
+	opts OutputOptions
+
+	// For a Drop writer the queue and the goroutine writing it, which
+	// writes what is left and ends once stop is closed
+	mu       sync.Mutex
+	queue    []byte
+	wake     chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// hook is a func added by OnSend() or OnReceive()
+type hook struct {
+	f func(p []byte)
+}
+
+// AddOutputWriter adds w to the writers sent a copy of everything read from
+// the process, so logging, recording and a UI can all follow one session.
+// If opts is nil the writer uses Backpressure. A writer that returns an error
+// is removed. Writers are removed by RemoveOutputWriter() so must be
+// comparable, as pointers are.
+func (exp *Expect) AddOutputWriter(w io.Writer, opts *OutputOptions) {
+	ow := &outputWriter{w: w}
+	if opts != nil {
+		ow.opts = *opts
+	}
+	if ow.opts.Policy == Drop {
+		if ow.opts.QueueSize <= 0 {
+			ow.opts.QueueSize = 64 * 1024
+		}
+		ow.wake = make(chan struct{}, 1)
+		ow.stop = make(chan struct{})
+		ow.done = make(chan struct{})
+		go exp.outputWriter(ow)
+	}
+
+	exp.outputMu.Lock()
+	defer exp.outputMu.Unlock()
+	outputs := append(append([]*outputWriter{}, exp.outputWriters()...), ow)
+	exp.outputs.Store(&outputs)
+	if exp.outputEnded {
+		ow.end()
+	}
+}
+
+// RemoveOutputWriter removes w, added by AddOutputWriter() or SetCmdOut().
+// Output already queued for a Drop writer is written before it returns.
+func (exp *Expect) RemoveOutputWriter(w io.Writer) {
+	exp.outputMu.Lock()
+	var outputs, removed []*outputWriter
+	for _, ow := range exp.outputWriters() {
+		if ow.w == w {
+			removed = append(removed, ow)
+		} else {
+			outputs = append(outputs, ow)
+		}
+	}
+	exp.outputs.Store(&outputs)
+	if exp.cmdOut == w {
+		exp.cmdOut = nil
+	}
+	exp.outputMu.Unlock()
+
+	for _, ow := range removed {
+		ow.end()
+		if ow.done != nil {
+			<-ow.done
+		}
+	}
+}
+
+// OnSend adds a hook called with everything written to the process by Write(),
+// and so Send() and the rest. The hook must not keep or change p. It returns
+// a func that removes the hook.
+func (exp *Expect) OnSend(f func(p []byte)) (remove func()) {
+	return exp.addHook(&exp.sendHooks, f)
+}
+
+// OnReceive adds a hook called with everything read from the process, as it
+// is read and before Expect() sees it. The hook is called by the goroutine
+// reading the output so must be quick and must not keep or change p. It
+// returns a func that removes the hook.
+func (exp *Expect) OnReceive(f func(p []byte)) (remove func()) {
+	return exp.addHook(&exp.receiveHooks, f)
+}
+
+// Write writes p to the process and calls the OnSend() hooks with what was
+// written
+func (exp *Expect) Write(p []byte) (int, error) {
+	n, err := exp.File.Write(p)
+	if n > 0 {
+		exp.hooksMu.Lock()
+		hooks := exp.sendHooks
+		exp.hooksMu.Unlock()
+		for _, h := range hooks {
+			h.f(p[:n])
+		}
+	}
+	return n, err
+}
+
+// addHook adds f to hooks returning the func that removes it
+func (exp *Expect) addHook(hooks *[]*hook, f func(p []byte)) func() {
+	h := &hook{f: f}
+	exp.hooksMu.Lock()
+	defer exp.hooksMu.Unlock()
+	*hooks = append(append([]*hook{}, *hooks...), h)
+	return func() {
+		exp.hooksMu.Lock()
+		defer exp.hooksMu.Unlock()
+		var kept []*hook
+		for _, k := range *hooks {
+			if k != h {
+				kept = append(kept, k)
+			}
+		}
+		*hooks = kept
+	}
+}
+
+// outputWriters returns the current output writers
+func (exp *Expect) outputWriters() []*outputWriter {
+	if outputs := exp.outputs.Load(); outputs != nil {
+		return *outputs
+	}
+	return nil
+}
+
+// writeOutput passes p, just read from the process, to the output writers
+// and OnReceive() hooks
+func (exp *Expect) writeOutput(p []byte) {
+	for _, ow := range exp.outputWriters() {
+		if ow.opts.Policy != Drop {
+			if _, err := ow.w.Write(p); err != nil {
+				debugf("output writer failed %s", err)
+				exp.RemoveOutputWriter(ow.w)
+			}
+			continue
+		}
+		ow.mu.Lock()
+		room := ow.opts.QueueSize - len(ow.queue)
+		if room > len(p) {
+			room = len(p)
+		}
+		ow.queue = append(ow.queue, p[:room]...)
+		ow.mu.Unlock()
+		select {
+		case ow.wake <- struct{}{}:
+		default:
+		}
+		if room < len(p) && ow.opts.OnDrop != nil {
+			ow.opts.OnDrop(p[room:])
+		}
+	}
+
+	exp.hooksMu.Lock()
+	hooks := exp.receiveHooks
+	exp.hooksMu.Unlock()
+	for _, h := range hooks {
+		h.f(p)
+	}
+}
+
+// endOutputWriters ends the goroutines of the Drop writers once the output
+// has ended
+func (exp *Expect) endOutputWriters() {
+	exp.outputMu.Lock()
+	defer exp.outputMu.Unlock()
+	exp.outputEnded = true
+	for _, ow := range exp.outputWriters() {
+		ow.end()
+	}
+}
+
+// outputWriter writes the queue of a Drop writer
+func (exp *Expect) outputWriter(ow *outputWriter) {
+	defer close(ow.done)
+	for {
+		var stopping bool
+		select {
+		case <-ow.wake:
+		case <-ow.stop:
+			stopping = true
+		}
+		ow.mu.Lock()
+		data := ow.queue
+		ow.queue = nil
+		ow.mu.Unlock()
+		if len(data) > 0 {
+			if _, err := ow.w.Write(data); err != nil {
+				debugf("output writer failed %s", err)
+				go exp.RemoveOutputWriter(ow.w)
+				return
+			}
+		}
+		if stopping {
+			return
+		}
+	}
+}
+
+// end stops the goroutine of a Drop writer
+func (ow *outputWriter) end() {
+	if ow.stop != nil {
+		ow.stopOnce.Do(func() { close(ow.stop) })
+	}
+}
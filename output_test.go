@@ -0,0 +1,134 @@
+/*
+File summary: go test of output writers and hooks
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowWriter is an output writer that takes its time
+type slowWriter struct {
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	delay time.Duration
+}
+
+func (sw *slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(sw.delay)
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	return sw.buf.Write(p)
+}
+
+func (sw *slowWriter) String() string {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	return sw.buf.String()
+}
+
+func Test_OutputWriters(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	exp, err := NewExpect("sh", "-c", "read a; echo got $a; read b; echo got $b")
+	if err != nil {
+		t.Fatalf("NewExpect failed %s", err)
+	}
+	defer exp.Kill()
+	exp.SetTimeout(5 * time.Second)
+
+	var first, second, cmdOut slowWriter
+	exp.AddOutputWriter(&first, nil)
+	exp.AddOutputWriter(&second, &OutputOptions{Policy: Drop})
+	exp.SetCmdOut(&cmdOut)
+
+	var mu sync.Mutex
+	var sent, received strings.Builder
+	exp.OnSend(func(p []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		sent.Write(p)
+	})
+	removeReceive := exp.OnReceive(func(p []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		received.Write(p)
+	})
+
+	exp.Send("one\r")
+	if n, _, err := exp.Expect("got one\r\n"); n != 0 {
+		t.Fatalf("Expect got one failed %d %v", n, err)
+	}
+
+	// Replacing the SetCmdOut writer, as LogUser does, leaves the others
+	var replaced slowWriter
+	exp.SetCmdOut(&replaced)
+	exp.RemoveOutputWriter(&second)
+	removeReceive()
+	exp.Send("two\r")
+	if n, _, err := exp.Expect(EndOfFile); n != 0 {
+		t.Errorf("Expect EndOfFile failed %d %v", n, err)
+	}
+
+	all := "one\r\ngot one\r\ntwo\r\ngot two\r\n"
+	for _, tc := range []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"first", first.String(), all},
+		{"second", second.String(), all[:14]},
+		{"cmdOut", cmdOut.String(), all[:14]},
+		{"replaced", replaced.String(), all[14:]},
+		{"sent", sent.String(), "one\rtwo\r"},
+		{"received", received.String(), all[:14]},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("%s output is %q not %q", tc.name, tc.got, tc.want)
+		}
+	}
+}
+
+func Test_OutputDrop(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	exp, err := NewExpect("sh", "-c", "read a; for i in 1 2 3 4 5 6 7 8 9 10; do echo 0123456789; done; echo end")
+	if err != nil {
+		t.Fatalf("NewExpect failed %s", err)
+	}
+	defer exp.Kill()
+	exp.SetTimeout(5 * time.Second)
+
+	// A slow Drop writer does not hold up reading
+	slow := &slowWriter{delay: 500 * time.Millisecond}
+	var dropped int
+	exp.AddOutputWriter(slow, &OutputOptions{Policy: Drop, QueueSize: 10, OnDrop: func(p []byte) {
+		dropped += len(p)
+	}})
+	started := time.Now()
+	exp.Send("go\r")
+	if n, _, err := exp.Expect("end\r\n"); n != 0 {
+		t.Fatalf("Expect end failed %d %v", n, err)
+	}
+	if elapsed := time.Since(started); elapsed > 400*time.Millisecond {
+		t.Errorf("reading took %s", elapsed)
+	}
+	exp.RemoveOutputWriter(slow)
+	total := len("go\r\n") + 10*len("0123456789\r\n") + len("end\r\n")
+	t.Logf("wrote %d dropped %d of %d", len(slow.String()), dropped, total)
+	if dropped == 0 || len(slow.String())+dropped != total {
+		t.Errorf("wrote %d and dropped %d of %d", len(slow.String()), dropped, total)
+	}
+}
@@ -0,0 +1,305 @@
+/*
+File summary: Repl drives interactive interpreters such as python or psql
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ReplConfig describes how to start and talk to a read-eval-print loop.
+// The patterns are regexps searched for in the output as it arrives. The
+// prompts should end with $ and usually start with (?m)^ so that they cannot
+// match within a line of output.
+type ReplConfig struct {
+	// Command and Args start the REPL
+	Command string
+	Args    []string
+
+	// Env is added to the environment of the REPL. The presets set TERM=dumb
+	// to stop line editors sending escape sequences.
+	Env []string
+
+	// Prompt matches the primary prompt, shown when the REPL is ready for
+	// new input
+	Prompt string
+
+	// Continuation matches the prompt shown when the REPL needs more input
+	// to complete a statement. It may be empty if there is none.
+	Continuation string
+
+	// Errors match the output of a failed evaluation
+	Errors []string
+
+	// BlankLineEndsBlock sends an empty line if the continuation prompt is
+	// shown after the last line of input, as python needs to end a block
+	BlankLineEndsBlock bool
+
+	// Setup is sent after the REPL starts, before it is returned by NewRepl
+	Setup []string
+}
+
+// Presets for common REPLs. Copy one and change it as needed, or pass extra
+// arguments such as the database to NewRepl().
+var (
+	PythonRepl = ReplConfig{
+		Command:            "python3",
+		Args:               []string{"-q", "-i"},
+		Env:                []string{"TERM=dumb", "PYTHON_BASIC_REPL=1"},
+		Prompt:             `(?m)^>>> $`,
+		Continuation:       `(?m)^\.\.\. $`,
+		Errors:             []string{`(?m)^Traceback \(most recent call last\):`, `(?m)^\w+(Error|Exception): `},
+		BlankLineEndsBlock: true,
+	}
+
+	PsqlRepl = ReplConfig{
+		Command:      "psql",
+		Args:         []string{"-X", "--pset=pager=off", "--set=PROMPT1=psql=> ", "--set=PROMPT2=psql-> "},
+		Env:          []string{"TERM=dumb"},
+		Prompt:       `(?m)^psql=> $`,
+		Continuation: `(?m)^psql-> $`,
+		Errors:       []string{`(?m)^(ERROR|FATAL): `},
+	}
+
+	Sqlite3Repl = ReplConfig{
+		Command:      "sqlite3",
+		Args:         []string{"-interactive"},
+		Env:          []string{"TERM=dumb"},
+		Prompt:       `(?m)^sqlite> $`,
+		Continuation: `(?m)^ *\.\.\.> $`,
+		Errors:       []string{`(?m)^(Parse error|Runtime error|Error)\b`},
+	}
+
+	NodeRepl = ReplConfig{
+		Command:      "node",
+		Args:         []string{"-i"},
+		Env:          []string{"TERM=dumb", "NODE_NO_READLINE=1"},
+		Prompt:       `(?m)^> $`,
+		Continuation: `(?m)^\.\.\. $`,
+		Errors:       []string{`(?m)^Uncaught\b`},
+	}
+
+	GdbRepl = ReplConfig{
+		Command: "gdb",
+		Args: []string{"-q", "-nx",
+			"-iex", "set pagination off", "-iex", "set confirm off", "-iex", "set width 0"},
+		Env:          []string{"TERM=dumb"},
+		Prompt:       `(?m)^\(gdb\) $`,
+		Continuation: `(?m)^>$`,
+		Errors:       []string{`(?m)^(No symbol|Undefined command|No such file|Cannot access memory|The program is not being run)`},
+	}
+)
+
+// ReplStartTimeout is how long NewRepl waits for the first prompt
+var ReplStartTimeout = 10 * time.Second
+
+// EReplIncomplete is returned by Eval() if the REPL still wants more input
+// after the last line was sent. The input is abandoned with an interrupt,
+// which not every REPL honours (sqlite3 keeps the partial statement).
+var EReplIncomplete = errors.New("repl input incomplete")
+
+// ReplError is returned by Eval() when the output matches one of the error
+// patterns
+type ReplError struct {
+	Input  string
+	Output string
+
+	// Match is the text the error pattern matched
+	Match string
+}
+
+func (e *ReplError) Error() string {
+	return fmt.Sprintf("repl error %q evaluating %q", e.Match, e.Input)
+}
+
+// Repl is a running REPL. The *Expect is available for anything else but
+// leave the REPL at its primary prompt before calling Eval() again.
+type Repl struct {
+	*Expect
+
+	// prompts matches the primary or continuation prompt
+	prompts *regexp.Regexp
+
+	// continuation matches the continuation prompt alone, nil if there is none
+	continuation *regexp.Regexp
+
+	errors []*regexp.Regexp
+
+	blankLineEndsBlock bool
+}
+
+// NewRepl starts the REPL described by cfg, with args added to its Args, and
+// waits for its first prompt
+func NewRepl(cfg ReplConfig, args ...string) (*Repl, error) {
+	r := &Repl{blankLineEndsBlock: cfg.BlankLineEndsBlock}
+
+	var err error
+	prompts := `(?:` + cfg.Prompt + `)`
+	if cfg.Continuation != "" {
+		r.continuation, err = regexp.Compile(cfg.Continuation)
+		if err != nil {
+			return nil, err
+		}
+		prompts += `|(?:` + cfg.Continuation + `)`
+	}
+	r.prompts, err = regexp.Compile(prompts)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range cfg.Errors {
+		re, err := regexp.Compile(e)
+		if err != nil {
+			return nil, err
+		}
+		r.errors = append(r.errors, re)
+	}
+
+	cmd := exec.Command(cfg.Command, append(append([]string{}, cfg.Args...), args...)...)
+	if len(cfg.Env) > 0 {
+		cmd.Env = append(os.Environ(), cfg.Env...)
+	}
+	r.Expect, err = newExpectCommon(true, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ReplStartTimeout)
+	defer cancel()
+	if _, _, err := r.next(ctx); err != nil {
+		r.Kill()
+		return nil, err
+	}
+
+	for _, s := range cfg.Setup {
+		if _, err := r.Eval(ctx, s); err != nil {
+			r.Kill()
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Eval sends input a line at a time, waiting for a prompt after each, and
+// returns the output up to the next primary prompt. The echo of each line is
+// removed and "\r\n" turned back into "\n".
+// If the output matches an error pattern it is returned with a *ReplError.
+// If the REPL still wants more input after the last line EReplIncomplete is
+// returned. If ctx is done the REPL is sent an interrupt (^C) and ctx.Err()
+// is returned.
+func (r *Repl) Eval(ctx context.Context, input string) (string, error) {
+	r.Clear()
+	lines := strings.Split(strings.TrimSuffix(input, "\n"), "\n")
+
+	var output bytes.Buffer
+	continued := false
+	for i := 0; i < len(lines) || (continued && r.blankLineEndsBlock && i == len(lines)); i++ {
+		line := ""
+		if i < len(lines) {
+			line = lines[i]
+		}
+		debugf("Repl Eval %q", line)
+		if _, err := r.Send(line + "\n"); err != nil {
+			return output.String(), err
+		}
+		before, more, err := r.next(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				r.resync()
+			}
+			return output.String(), err
+		}
+		output.Write(stripEcho(before, line))
+		continued = more
+	}
+
+	out := string(bytes.ReplaceAll(output.Bytes(), []byte("\r\n"), []byte("\n")))
+	if continued {
+		r.resync()
+		return out, EReplIncomplete
+	}
+	for _, re := range r.errors {
+		if m := re.FindString(out); m != "" {
+			return out, &ReplError{Input: input, Output: out, Match: m}
+		}
+	}
+	return out, nil
+}
+
+// Close ends the REPL as Shutdown() does, which starts by sending EOF (^D)
+func (r *Repl) Close() error {
+	return r.Shutdown(context.Background())
+}
+
+// next waits for a prompt and returns the output before it and whether it
+// was the continuation prompt
+func (r *Repl) next(ctx context.Context) ([]byte, bool, error) {
+	n, before, found, err := r.expectBefore(ctx, []interface{}{r.prompts, EndOfFile})
+	switch {
+	case n == 1:
+		return nil, false, &EOFError{Patterns: []interface{}{r.prompts}, Tail: errorTail(found)}
+	case n < 0:
+		return nil, false, err
+	}
+	more := r.continuation != nil && r.continuation.Match(found)
+	return before, more, nil
+}
+
+// resync interrupts the REPL and waits briefly for the primary prompt. The
+// interrupt is repeated a few times as it can be lost if it arrives while the
+// REPL's line editor is changing the terminal settings.
+func (r *Repl) resync() {
+	for try := 0; try < 4; try++ {
+		if r.resyncOnce() {
+			return
+		}
+	}
+	debugf("Repl cannot resync")
+}
+
+// resyncOnce sends one interrupt and returns true if the primary prompt
+// followed
+func (r *Repl) resyncOnce() bool {
+	r.SendIntr()
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+	for {
+		_, more, err := r.next(ctx)
+		if err != nil {
+			debugf("Repl resync: %s", err)
+			return r.Eof
+		}
+		if !more {
+			return true
+		}
+	}
+}
+
+// stripEcho removes the echo of line from the start of out. If the first
+// line of out does not contain line it is assumed there was no echo.
+func stripEcho(out []byte, line string) []byte {
+	nl := bytes.IndexByte(out, '\n')
+	if nl < 0 {
+		if bytes.Contains(out, []byte(line)) {
+			return nil
+		}
+		return out
+	}
+	if bytes.Contains(out[:nl], []byte(line)) {
+		return out[nl+1:]
+	}
+	return out
+}
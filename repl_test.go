@@ -0,0 +1,115 @@
+/*
+File summary: go test of Repl
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+)
+
+type replCase struct {
+	input  string
+	output string
+	failed bool
+}
+
+func testRepl(t *testing.T, cfg ReplConfig, cases []replCase) {
+	if _, err := exec.LookPath(cfg.Command); err != nil {
+		t.Skipf("%s not installed", cfg.Command)
+	}
+
+	r, err := NewRepl(cfg)
+	if err != nil {
+		t.Errorf("NewRepl(%s) failed %s", cfg.Command, err)
+		return
+	}
+	defer r.Close()
+
+	for _, tc := range cases {
+		output, err := r.Eval(context.Background(), tc.input)
+		var replErr *ReplError
+		if tc.failed {
+			if !errors.As(err, &replErr) {
+				t.Errorf("%s Eval(%q) err is %v not a ReplError", cfg.Command, tc.input, err)
+			} else {
+				t.Logf("%s Eval(%q) failed as expected: %s", cfg.Command, tc.input, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s Eval(%q) failed %s", cfg.Command, tc.input, err)
+		} else if output != tc.output {
+			t.Errorf("%s Eval(%q) is %q not %q", cfg.Command, tc.input, output, tc.output)
+		} else {
+			t.Logf("%s Eval(%q) is %q", cfg.Command, tc.input, output)
+		}
+	}
+}
+
+func Test_ReplPython(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	testRepl(t, PythonRepl, []replCase{
+		{input: "1 + 2", output: "3\n"},
+		{input: "x = 6\nx * 7", output: "42\n"},
+		{input: "for i in range(3):\n    print(i)", output: "0\n1\n2\n"},
+		{input: "1/0", failed: true},
+		{input: "print('still here')", output: "still here\n"},
+	})
+}
+
+func Test_ReplSqlite3(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	testRepl(t, Sqlite3Repl, []replCase{
+		{input: "create table t(a);", output: ""},
+		{input: "insert into t values (1), (2);", output: ""},
+		{input: "select sum(a)\nfrom t;", output: "3\n"},
+		{input: "select * from missing;", failed: true},
+	})
+}
+
+func Test_ReplNode(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	testRepl(t, NodeRepl, []replCase{
+		{input: "1 + 2", output: "3\n"},
+		{input: "function f(x) {\nreturn x * 2\n}", output: "undefined\n"},
+		{input: "f(21)", output: "42\n"},
+		{input: "missing()", failed: true},
+	})
+}
+
+func Test_ReplIncomplete(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 not installed")
+	}
+	r, err := NewRepl(PythonRepl)
+	if err != nil {
+		t.Errorf("NewRepl failed %s", err)
+		return
+	}
+	defer r.Close()
+
+	if _, err := r.Eval(context.Background(), "(1 +"); err != EReplIncomplete {
+		t.Errorf("Eval of incomplete input err is %v not EReplIncomplete", err)
+	}
+	if output, err := r.Eval(context.Background(), "2 + 2"); err != nil || output != "4\n" {
+		t.Errorf("Eval after incomplete is %q, %v", output, err)
+	}
+}
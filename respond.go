@@ -0,0 +1,113 @@
+/*
+File summary: Answer recurring prompts from a table until done
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"fmt"
+	"time"
+)
+
+// Response is a reply for Respond() to give whenever its pattern appears
+type Response struct {
+	// Pattern is a string or *regexp.Regexp, as passed to Expect()
+	Pattern interface{}
+
+	// Reply is sent as is so usually ends with "\r"
+	Reply string
+
+	// Max is how many times Reply may be given, zero for no limit
+	Max int
+}
+
+// Answered is a prompt answered by Respond()
+type Answered struct {
+	// Index is the position of the Response used
+	Index int
+
+	// Prompt is the text its Pattern found
+	Prompt []byte
+
+	// Reply is what was sent
+	Reply string
+
+	// At is when the prompt was found, from the start of Respond()
+	At time.Duration
+}
+
+// RespondLimitError is returned by Respond() when a prompt appears again
+// after its Response has been given Max times, usually because the program
+// did not accept the reply and asked again
+type RespondLimitError struct {
+	// Index is the position of the Response and Max its limit
+	Index int
+	Max   int
+
+	// Prompt is the text its Pattern found the last time
+	Prompt []byte
+}
+
+func (e *RespondLimitError) Error() string {
+	return fmt.Sprintf("respond: prompt %q appeared again after its reply was used %d times", e.Prompt, e.Max)
+}
+
+// Respond answers whichever prompt in responses appears, in any order and
+// as often as it appears, until done is found. The answers given so far are
+// returned in order, along with any error.
+// done is a string, *regexp.Regexp or Pseudo. If it is nil or EndOfFile then
+// Respond ends without error when the process closes its output, otherwise
+// that gives an *EOFError. The timeout set by SetTimeout() applies to the
+// wait for each prompt and gives a *TimeoutError.
+// A Response given more than its Max times gives a *RespondLimitError.
+func (exp *Expect) Respond(done interface{}, responses ...Response) ([]Answered, error) {
+	pats := make([]interface{}, 0, len(responses)+2)
+	for _, r := range responses {
+		pats = append(pats, r.Pattern)
+	}
+	doneIndex := len(pats)
+	if done != nil {
+		pats = append(pats, done)
+	}
+	eofIndex := pseudoIndex(pats, EndOfFile)
+	if eofIndex < 0 {
+		eofIndex = len(pats)
+		pats = append(pats, EndOfFile)
+	}
+
+	var log []Answered
+	used := make([]int, len(responses))
+	started := time.Now()
+	for {
+		waited := time.Now()
+		n, found, err := exp.Expect(pats...)
+		switch {
+		case n < 0:
+			return log, err
+		case n == eofIndex && (done == nil || done == EndOfFile):
+			debugf("Respond eof")
+			return log, nil
+		case n == eofIndex:
+			return log, &EOFError{Patterns: pats[doneIndex:eofIndex], Elapsed: time.Since(waited), Tail: errorTail(found)}
+		case n >= doneIndex:
+			debugf("Respond done")
+			return log, nil
+		}
+
+		r := responses[n]
+		if r.Max > 0 && used[n] == r.Max {
+			return log, &RespondLimitError{Index: n, Max: r.Max, Prompt: found}
+		}
+		used[n]++
+		debugf("Respond to %q with %q", found, r.Reply)
+		if _, err := exp.Send(r.Reply); err != nil {
+			return log, err
+		}
+		log = append(log, Answered{Index: n, Prompt: found, Reply: r.Reply, At: time.Since(started)})
+	}
+}
@@ -0,0 +1,79 @@
+/*
+File summary: go test of Respond
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"errors"
+	"io"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// wizard asks its questions, name twice, then finishes
+const wizard = `for q in Name: Colour: Name:; do printf '%s ' "$q"; read a; echo "[$a]"; done; echo Installed`
+
+func Test_Respond(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	responses := []Response{
+		{Pattern: "Name: ", Reply: "bob\r"},
+		{Pattern: regexp.MustCompile(`Colou?r: `), Reply: "red\r", Max: 1},
+	}
+	for _, tc := range []struct {
+		done    interface{}
+		nameMax int
+		answers []int
+		check   func(error) bool
+	}{
+		{"Installed", 0, []int{0, 1, 0}, func(err error) bool { return err == nil }},
+		{nil, 0, []int{0, 1, 0}, func(err error) bool { return err == nil }},
+		{EndOfFile, 2, []int{0, 1, 0}, func(err error) bool { return err == nil }},
+		{"Never", 0, []int{0, 1, 0}, func(err error) bool { return errors.Is(err, io.EOF) }},
+		{"Installed", 1, []int{0, 1}, func(err error) bool {
+			var limit *RespondLimitError
+			return errors.As(err, &limit) && limit.Index == 0 && limit.Max == 1 && string(limit.Prompt) == "Name: "
+		}},
+	} {
+		exp, err := NewExpect("sh", "-c", wizard)
+		if err != nil {
+			t.Fatalf("NewExpect failed %s", err)
+		}
+		exp.SetTimeout(5 * time.Second)
+		responses[0].Max = tc.nameMax
+		log, err := exp.Respond(tc.done, responses...)
+		t.Logf("Respond until %v: %v", tc.done, err)
+		if !tc.check(err) {
+			t.Errorf("Respond until %v gave %v", tc.done, err)
+		}
+		if len(log) != len(tc.answers) {
+			t.Errorf("Respond until %v answered %+v", tc.done, log)
+		} else {
+			for n, a := range log {
+				if a.Index != tc.answers[n] || a.Reply != responses[a.Index].Reply {
+					t.Errorf("Respond answer %d is %+v", n, a)
+				}
+			}
+		}
+		exp.Kill()
+	}
+
+	// A question not in the table times out
+	exp, err := NewExpect("sh", "-c", "printf 'Age: '; read a")
+	if err != nil {
+		t.Fatalf("NewExpect failed %s", err)
+	}
+	defer exp.Kill()
+	exp.SetTimeout(200 * time.Millisecond)
+	if log, err := exp.Respond(nil, responses...); !errors.Is(err, ETimedOut) || len(log) != 0 {
+		t.Errorf("Respond to an unknown question gave %v %+v", err, log)
+	}
+}
@@ -0,0 +1,291 @@
+/*
+File summary: JUnit XML and JSON reports of scenario results
+Package: scenario
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package scenario
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// SaveTranscript writes the Output to path and records path as the
+// TranscriptFile that reports link to
+func (r *Result) SaveTranscript(path string) error {
+	if err := os.WriteFile(path, []byte(r.Output), 0644); err != nil {
+		return err
+	}
+	r.TranscriptFile = path
+	return nil
+}
+
+// transcriptLink returns "file:line" for offset in the Output or "" if there
+// is no TranscriptFile
+func (r *Result) transcriptLink(offset int) string {
+	if r.TranscriptFile == "" {
+		return ""
+	}
+	offset = min(offset, len(r.Output))
+	return fmt.Sprintf("%s:%d", r.TranscriptFile, 1+strings.Count(r.Output[:offset], "\n"))
+}
+
+// wallTime returns the time from the first result starting to the last
+// ending, which is less than the total of the Durations when run in parallel
+func wallTime(results []*Result) time.Duration {
+	var first, last time.Time
+	for _, r := range results {
+		if first.IsZero() || r.Start.Before(first) {
+			first = r.Start
+		}
+		if end := r.Start.Add(r.Duration); end.After(last) {
+			last = end
+		}
+	}
+	return last.Sub(first)
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name       string          `xml:"name,attr"`
+	File       string          `xml:"file,attr,omitempty"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Skipped    int             `xml:"skipped,attr"`
+	Time       string          `xml:"time,attr"`
+	Timestamp  string          `xml:"timestamp,attr"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	Cases      []junitTestCase `xml:"testcase"`
+	SystemOut  string          `xml:"system-out,omitempty"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+// WriteJUnit writes results as JUnit XML. Each scenario is a testsuite and
+// each of its steps a testcase, steps after a failure are skipped. A failure
+// that is not in a step, such as the wrong exit status, is a testcase called
+// "scenario". The full output is in the testsuite's system-out unless it was
+// saved with SaveTranscript() when the file is given as a property instead.
+func WriteJUnit(w io.Writer, results []*Result) error {
+	doc := junitTestSuites{Time: seconds(wallTime(results))}
+	for _, r := range results {
+		suite := junitTestSuite{
+			Name:      r.Name,
+			File:      r.File,
+			Time:      seconds(r.Duration),
+			Timestamp: r.Start.Format("2006-01-02T15:04:05"),
+		}
+		if r.TranscriptFile != "" {
+			suite.Properties = append(suite.Properties, junitProperty{"transcript", r.TranscriptFile})
+		} else {
+			suite.SystemOut = r.Output
+		}
+
+		for i, sr := range r.Steps {
+			tc := junitTestCase{
+				Name:      fmt.Sprintf("step %d: %s", i+1, sr.Name),
+				Classname: r.Name,
+				Time:      seconds(sr.Duration),
+			}
+			switch {
+			case sr.Attempts == 0:
+				tc.Skipped = &junitSkipped{Message: "an earlier step failed"}
+				suite.Skipped++
+			case sr.Err != nil:
+				text := fmt.Sprintf("attempts: %d\n", sr.Attempts)
+				if sr.Tail != "" {
+					text += fmt.Sprintf("buffer tail: %q\n", sr.Tail)
+				}
+				if link := r.transcriptLink(sr.OutputStart); link != "" {
+					text += "transcript: " + link + "\n"
+				}
+				tc.Failure = &junitFailure{Message: sr.Err.Error(), Type: errorType(sr.Err), Text: text}
+				suite.Failures++
+			}
+			tc.SystemOut = stepDetails(r, sr)
+			suite.Cases = append(suite.Cases, tc)
+		}
+
+		var se *StepError
+		if r.Err != nil && !errors.As(r.Err, &se) {
+			suite.Cases = append(suite.Cases, junitTestCase{
+				Name:      "scenario",
+				Classname: r.Name,
+				Time:      seconds(r.Duration),
+				Failure:   &junitFailure{Message: r.Err.Error(), Type: errorType(r.Err)},
+			})
+			suite.Failures++
+		}
+
+		suite.Tests = len(suite.Cases)
+		doc.Tests += suite.Tests
+		doc.Failures += suite.Failures
+		doc.Skipped += suite.Skipped
+		doc.Suites = append(doc.Suites, suite)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// stepDetails describes what a step expected and matched
+func stepDetails(r *Result, sr StepResult) string {
+	var b strings.Builder
+	if sr.Pattern != "" {
+		fmt.Fprintf(&b, "pattern: %q\n", sr.Pattern)
+	}
+	if sr.Match != "" {
+		fmt.Fprintf(&b, "match: %q\n", sr.Match)
+	}
+	if link := r.transcriptLink(sr.OutputStart); link != "" && sr.Attempts > 0 {
+		fmt.Fprintf(&b, "transcript: %s\n", link)
+	}
+	return b.String()
+}
+
+// errorType names the kind of err for the JUnit failure type
+func errorType(err error) string {
+	var se *StepError
+	if errors.As(err, &se) {
+		err = se.Err
+	}
+	return strings.TrimPrefix(fmt.Sprintf("%T", err), "*")
+}
+
+type jsonReport struct {
+	Passed    int            `json:"passed"`
+	Failed    int            `json:"failed"`
+	Duration  float64        `json:"duration"`
+	Scenarios []jsonScenario `json:"scenarios"`
+}
+
+type jsonScenario struct {
+	Name       string     `json:"name"`
+	File       string     `json:"file,omitempty"`
+	Passed     bool       `json:"passed"`
+	Error      string     `json:"error,omitempty"`
+	Start      time.Time  `json:"start"`
+	Duration   float64    `json:"duration"`
+	Transcript string     `json:"transcript,omitempty"`
+	Vars       Vars       `json:"vars,omitempty"`
+	Steps      []jsonStep `json:"steps"`
+	OnFailure  []jsonStep `json:"on_failure,omitempty"`
+}
+
+type jsonStep struct {
+	Name       string  `json:"name"`
+	Pattern    string  `json:"pattern,omitempty"`
+	Match      string  `json:"match,omitempty"`
+	Attempts   int     `json:"attempts"`
+	Skipped    bool    `json:"skipped,omitempty"`
+	Error      string  `json:"error,omitempty"`
+	Tail       string  `json:"tail,omitempty"`
+	Duration   float64 `json:"duration"`
+	Transcript string  `json:"transcript,omitempty"`
+}
+
+// WriteJSON writes a summary of results as JSON. Durations are in seconds and
+// transcript links are "file:line" for results saved with SaveTranscript().
+func WriteJSON(w io.Writer, results []*Result) error {
+	report := jsonReport{Duration: wallTime(results).Seconds(), Scenarios: []jsonScenario{}}
+	for _, r := range results {
+		js := jsonScenario{
+			Name:       r.Name,
+			File:       r.File,
+			Passed:     r.Passed(),
+			Start:      r.Start,
+			Duration:   r.Duration.Seconds(),
+			Transcript: r.TranscriptFile,
+			Vars:       r.Vars,
+			Steps:      jsonSteps(r, r.Steps),
+			OnFailure:  jsonSteps(r, r.OnFailure),
+		}
+		if r.Passed() {
+			report.Passed++
+		} else {
+			report.Failed++
+			js.Error = r.Err.Error()
+		}
+		report.Scenarios = append(report.Scenarios, js)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(report)
+}
+
+func jsonSteps(r *Result, steps []StepResult) []jsonStep {
+	js := []jsonStep{}
+	for _, sr := range steps {
+		s := jsonStep{
+			Name:     sr.Name,
+			Pattern:  sr.Pattern,
+			Match:    sr.Match,
+			Attempts: sr.Attempts,
+			Skipped:  sr.Attempts == 0,
+			Tail:     sr.Tail,
+			Duration: sr.Duration.Seconds(),
+		}
+		if sr.Err != nil {
+			s.Error = sr.Err.Error()
+		}
+		if !s.Skipped {
+			s.Transcript = r.transcriptLink(sr.OutputStart)
+		}
+		js = append(js, s)
+	}
+	return js
+}
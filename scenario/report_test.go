@@ -0,0 +1,165 @@
+/*
+File summary: go test of scenario reports and parallel runs
+Package: scenario
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package scenario
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// reportResults runs a passing and a failing scenario with transcripts saved
+func reportResults(t *testing.T) []*Result {
+	pass := &Scenario{
+		Name:    "pass",
+		Command: "sh",
+		Steps: []Step{
+			{SendLine: "echo one", Expect: "one\r"},
+			{SendLine: "echo n=42", ExpectRe: `n=(\d+)\r`, Capture: []string{"n"}},
+		},
+	}
+	fail := &Scenario{
+		Name:    "fail",
+		Command: "sh",
+		Timeout: Duration(200 * time.Millisecond),
+		Steps: []Step{
+			{SendLine: "echo start", Expect: "start\r"},
+			{SendLine: "echo nope", Expect: "yes"},
+			{SendLine: "echo skipped"},
+		},
+	}
+	results := RunAll(context.Background(), []*Scenario{pass, fail}, 2, nil)
+	dir := t.TempDir()
+	for _, r := range results {
+		if err := r.SaveTranscript(filepath.Join(dir, r.Name+".log")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return results
+}
+
+func Test_WriteJUnit(t *testing.T) {
+	results := reportResults(t)
+	var buf bytes.Buffer
+	if err := WriteJUnit(&buf, results); err != nil {
+		t.Fatalf("WriteJUnit failed %s", err)
+	}
+	t.Logf("JUnit:\n%s", buf.String())
+
+	var doc junitTestSuites
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("JUnit does not parse %s", err)
+	}
+	if doc.Tests != 5 || doc.Failures != 1 || doc.Skipped != 1 || len(doc.Suites) != 2 {
+		t.Fatalf("testsuites is %+v", doc)
+	}
+	pass, fail := doc.Suites[0], doc.Suites[1]
+	if pass.Name != "pass" || pass.Failures != 0 || len(pass.Cases) != 2 ||
+		!strings.Contains(pass.Cases[1].SystemOut, `match: "n=42\r"`) {
+		t.Errorf("pass testsuite is %+v", pass)
+	}
+	if len(fail.Properties) != 1 || !strings.HasSuffix(fail.Properties[0].Value, "fail.log") {
+		t.Errorf("fail properties are %+v", fail.Properties)
+	}
+	failed := fail.Cases[1].Failure
+	if failed == nil || failed.Type != "expect.TimeoutError" || !strings.Contains(failed.Text, "buffer tail:") ||
+		!strings.Contains(failed.Text, "fail.log:") {
+		t.Errorf("failure is %+v", failed)
+	}
+	if fail.Cases[2].Skipped == nil {
+		t.Errorf("last step was not skipped %+v", fail.Cases[2])
+	}
+
+	// A failure outside the steps gets its own testcase
+	code := 1
+	s := &Scenario{Name: "exit", Command: "true", ExitStatus: &code}
+	buf.Reset()
+	WriteJUnit(&buf, []*Result{s.Run(context.Background())})
+	if !strings.Contains(buf.String(), `<testcase name="scenario" classname="exit"`) ||
+		!strings.Contains(buf.String(), "exit status is 0 not 1") {
+		t.Errorf("exit status JUnit is %s", buf.String())
+	}
+}
+
+func Test_WriteJSON(t *testing.T) {
+	results := reportResults(t)
+	var buf bytes.Buffer
+	if err := WriteJSON(&buf, results); err != nil {
+		t.Fatalf("WriteJSON failed %s", err)
+	}
+	var report jsonReport
+	if err := json.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatalf("JSON does not parse %s", err)
+	}
+	if report.Passed != 1 || report.Failed != 1 || len(report.Scenarios) != 2 {
+		t.Fatalf("report is %+v", report)
+	}
+	pass, fail := report.Scenarios[0], report.Scenarios[1]
+	if !pass.Passed || pass.Vars["n"] != "42" || pass.Steps[1].Pattern != `n=(\d+)\r` ||
+		pass.Steps[1].Match != "n=42\r" {
+		t.Errorf("pass is %+v", pass)
+	}
+	if fail.Passed || !strings.HasPrefix(fail.Error, "step 2") || !strings.Contains(fail.Steps[1].Tail, "nope") ||
+		!fail.Steps[2].Skipped || fail.Steps[2].Transcript != "" {
+		t.Errorf("fail is %+v", fail)
+	}
+
+	// The transcript link goes to the line the step's output starts on
+	file, _, _ := strings.Cut(fail.Steps[1].Transcript, ":")
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("transcript %s", err)
+	}
+	line := strings.Count(string(data[:results[1].Steps[1].OutputStart]), "\n") + 1
+	after := strings.Join(strings.Split(string(data), "\n")[line-1:], "\n")
+	if fail.Steps[1].Transcript != fmt.Sprintf("%s:%d", file, line) || !strings.Contains(after, "nope") {
+		t.Errorf("transcript link %s in %q", fail.Steps[1].Transcript, data)
+	}
+}
+
+func Test_RunAll(t *testing.T) {
+	var scenarios []*Scenario
+	for i := 0; i < 6; i++ {
+		scenarios = append(scenarios, &Scenario{
+			Name:    string(rune('a' + i)),
+			Command: "sleep",
+			Args:    []Scalar{"0.3"},
+			Steps:   []Step{{ExpectEOF: true}},
+		})
+	}
+
+	var order []string
+	start := time.Now()
+	results := RunAll(context.Background(), scenarios, 3, func(r *Result) {
+		order = append(order, r.Name)
+	})
+	elapsed := time.Since(start)
+	t.Logf("RunAll took %s finishing %v", elapsed, order)
+
+	if len(order) != 6 {
+		t.Errorf("done was called %d times", len(order))
+	}
+	for i, r := range results {
+		if r.Name != scenarios[i].Name || !r.Passed() {
+			t.Errorf("result %d is %s %v", i, r.Name, r.Err)
+		}
+	}
+	// Two batches of three
+	if elapsed < 600*time.Millisecond || elapsed > 1500*time.Millisecond {
+		t.Errorf("RunAll took %s, expected about 600ms", elapsed)
+	}
+}
@@ -0,0 +1,709 @@
+/*
+File summary: Declarative send/expect scenarios read from YAML or JSON
+Package: scenario
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+// Package scenario runs terminal tests described in YAML or JSON files rather
+// than Go. A scenario spawns a command with expect.NewExpect() and then runs
+// a list of steps, each of which may sleep, send some text and expect a
+// literal string, a regexp or EOF:
+//
+//	name: login
+//	command: ssh
+//	args: [-t, "${host}"]
+//	timeout: 5s
+//	vars:
+//	  host: example.com
+//	  user: guest
+//	steps:
+//	  - expect: "login: "
+//	  - sendline: "${user}"
+//	    expect_re: 'uid=(?P<uid>\d+)\('
+//	    retries: 2
+//	  - sendline: "echo ${uid}"
+//	    expect: "${uid}"
+//	  - sendline: exit
+//	    expect_eof: true
+//	exit_status: 0
+//	on_failure:
+//	  - send: "\x03"
+//
+// Strings in command, args, env, send, sendline, expect and expect_re may use
+// ${name} to refer to vars. Named regexp groups, and the groups listed in a
+// step's capture, set vars that later steps can use. Write $${ for a literal
+// ${. In expect_re a var matches its value literally, it is not a regexp.
+// As output arrives in pieces a regexp should say where its match ends,
+// so 'n=(\d+)\r' rather than 'n=(\d+)' which may match only the first digit.
+// Numbers and booleans may be given where a string is expected, so
+// sendline: 42 sends "42". Durations are strings such as "1.5s" or numbers of
+// seconds.
+package scenario
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leemcloughlin/expect"
+)
+
+// DefaultTimeout is how long a step waits for its expect when neither the
+// step nor the scenario sets a timeout
+var DefaultTimeout = 10 * time.Second
+
+// ShutdownTimeout is how long Run waits for the command to end once the
+// steps are done
+var ShutdownTimeout = 5 * time.Second
+
+// Scenario is a command to spawn and the steps to run against it
+type Scenario struct {
+	// Name identifies the scenario in results, Load defaults it to the
+	// file's name
+	Name string `json:"name"`
+
+	// File is the file Load read the scenario from
+	File string `json:"-"`
+
+	// Command and Args are given to expect.NewExpect()
+	Command Scalar   `json:"command"`
+	Args    []Scalar `json:"args"`
+
+	// Env are name=value pairs added to the command's environment
+	Env []Scalar `json:"env"`
+
+	// Timeout is the default timeout for each step
+	Timeout Duration `json:"timeout"`
+
+	// Vars are the initial variables
+	Vars Vars `json:"vars"`
+
+	Steps []Step `json:"steps"`
+
+	// OnFailure steps are run, ignoring their errors, when a step fails.
+	// For example to send a ^C or print some diagnostics.
+	OnFailure []Step `json:"on_failure"`
+
+	// ExitStatus, if set, is the exit code the command must end with after
+	// the last step
+	ExitStatus *int `json:"exit_status"`
+}
+
+// Step is an optional sleep, an optional send and then an optional expect
+type Step struct {
+	// Name describes the step in results, the default is made from what it
+	// does
+	Name string `json:"name"`
+
+	// Sleep is how long to wait before sending
+	Sleep Duration `json:"sleep"`
+
+	// Send is sent as is, SendLine is sent with a "\r" added
+	Send     Scalar `json:"send"`
+	SendLine Scalar `json:"sendline"`
+
+	// Only one of Expect, a literal string, ExpectRe, a regexp, or ExpectEOF
+	// may be given
+	Expect    Scalar `json:"expect"`
+	ExpectRe  Scalar `json:"expect_re"`
+	ExpectEOF bool   `json:"expect_eof"`
+
+	// Capture names the groups of ExpectRe, in order, to save as vars
+	Capture []string `json:"capture"`
+
+	// Timeout overrides the scenario's timeout for this step
+	Timeout Duration `json:"timeout"`
+
+	// Retries is how many more times the whole step, including the send, is
+	// tried if the expect fails. RetryDelay is the wait between tries.
+	Retries    int      `json:"retries"`
+	RetryDelay Duration `json:"retry_delay"`
+}
+
+// Duration is a time.Duration that is read from JSON or YAML as a string
+// such as "1.5s" or a number of seconds
+type Duration time.Duration
+
+// UnmarshalJSON reads a duration string or a number of seconds
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case float64:
+		*d = Duration(v * float64(time.Second))
+	case string:
+		t, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = Duration(t)
+	case nil:
+		*d = 0
+	default:
+		return fmt.Errorf("bad duration %s", b)
+	}
+	if *d < 0 {
+		return fmt.Errorf("negative duration %s", b)
+	}
+	return nil
+}
+
+// Scalar is a string that in a file may be given as any scalar, so that
+// sendline: 42 sends "42"
+type Scalar string
+
+// UnmarshalJSON reads a string, number, boolean or null
+func (s *Scalar) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	str, ok := scalarString(v)
+	if !ok {
+		return fmt.Errorf("%s is not a scalar", b)
+	}
+	*s = Scalar(str)
+	return nil
+}
+
+// Vars are variables by name. In a file their values may be any scalar.
+type Vars map[string]string
+
+// UnmarshalJSON reads an object of scalars
+func (v *Vars) UnmarshalJSON(b []byte) error {
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	*v = make(Vars, len(m))
+	for name, val := range m {
+		str, ok := scalarString(val)
+		if !ok {
+			return fmt.Errorf("var %q is not a scalar", name)
+		}
+		(*v)[name] = str
+	}
+	return nil
+}
+
+// scalarString returns a scalar decoded from JSON as a string, null being ""
+func scalarString(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	case nil:
+		return "", true
+	}
+	return "", false
+}
+
+// Load reads a scenario file, see Parse()
+func Load(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if s.Name == "" {
+		s.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	s.File = path
+	return s, nil
+}
+
+// Parse reads a scenario from JSON, if data starts with a {, or otherwise
+// YAML. Unknown fields are errors so that typing mistakes are caught.
+func Parse(data []byte) (*Scenario, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) == 0 || trimmed[0] != '{' {
+		v, err := parseYAML(data)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = json.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
+
+	s := new(Scenario)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(s); err != nil {
+		return nil, err
+	}
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// validate checks for mistakes that do not need the vars to find
+func (s *Scenario) validate() error {
+	if s.Command == "" {
+		return errors.New("no command given")
+	}
+	for _, steps := range [][]Step{s.Steps, s.OnFailure} {
+		for i, st := range steps {
+			expects := 0
+			for _, given := range []bool{st.Expect != "", st.ExpectRe != "", st.ExpectEOF} {
+				if given {
+					expects++
+				}
+			}
+			switch {
+			case expects > 1:
+				return fmt.Errorf("step %d (%s): only one of expect, expect_re and expect_eof may be given", i+1, st)
+			case len(st.Capture) > 0 && st.ExpectRe == "":
+				return fmt.Errorf("step %d (%s): capture needs expect_re", i+1, st)
+			case st.Retries < 0:
+				return fmt.Errorf("step %d (%s): negative retries", i+1, st)
+			}
+		}
+	}
+	return nil
+}
+
+// String describes the step by its Name or else what it does
+func (st Step) String() string {
+	if st.Name != "" {
+		return st.Name
+	}
+	var what []string
+	if st.Sleep > 0 {
+		what = append(what, "sleep "+time.Duration(st.Sleep).String())
+	}
+	if st.Send != "" {
+		what = append(what, "send "+strconv.Quote(string(st.Send)))
+	}
+	if st.SendLine != "" {
+		what = append(what, "sendline "+strconv.Quote(string(st.SendLine)))
+	}
+	switch {
+	case st.Expect != "":
+		what = append(what, "expect "+strconv.Quote(string(st.Expect)))
+	case st.ExpectRe != "":
+		what = append(what, "expect_re "+strconv.Quote(string(st.ExpectRe)))
+	case st.ExpectEOF:
+		what = append(what, "expect_eof")
+	}
+	if len(what) == 0 {
+		return "empty step"
+	}
+	return strings.Join(what, ", ")
+}
+
+// Result is the outcome of running a Scenario
+type Result struct {
+	Name string
+	File string
+
+	// Err is nil if the scenario passed. A failing step gives a *StepError.
+	Err error
+
+	// Steps are the results of every step, those after a failing step have
+	// no Attempts, and OnFailure of the on_failure steps
+	Steps     []StepResult
+	OnFailure []StepResult
+
+	// Vars are the variables when the scenario ended
+	Vars Vars
+
+	// Output is everything the command wrote
+	Output string
+
+	// TranscriptFile is where SaveTranscript() wrote Output
+	TranscriptFile string
+
+	Start    time.Time
+	Duration time.Duration
+}
+
+// Passed reports whether the scenario passed
+func (r *Result) Passed() bool {
+	return r.Err == nil
+}
+
+// StepResult is the outcome of one Step
+type StepResult struct {
+	Name string
+
+	// Pattern is what the step expected once vars were expanded and Match
+	// is the text that matched it
+	Pattern string
+	Match   string
+
+	// Attempts is how many times the step was tried, 0 if it was skipped
+	// because an earlier step failed
+	Attempts int
+
+	Err error
+
+	// Tail is the end of the output unmatched when the step failed
+	Tail string
+
+	// OutputStart and OutputEnd are the part of Result.Output written while
+	// the step ran
+	OutputStart, OutputEnd int
+
+	Duration time.Duration
+}
+
+// StepError is the Result error when a step fails
+type StepError struct {
+	// Step is the failing step, counting from 1
+	Step int
+	Name string
+	Err  error
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("step %d (%s): %s", e.Step, e.Name, e.Err)
+}
+
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+// Run spawns the scenario's command and runs its steps. The command is shut
+// down, see expect.Shutdown(), before Run returns. When ctx is done the step
+// running fails with ctx.Err().
+func (s *Scenario) Run(ctx context.Context) *Result {
+	start := time.Now()
+	r := &Result{Name: s.Name, File: s.File, Vars: Vars{}, Start: start}
+	for name, val := range s.Vars {
+		r.Vars[name] = val
+	}
+	out := new(transcript)
+	defer func() {
+		r.Output = out.String()
+		r.Duration = time.Since(start)
+	}()
+
+	prog, args, err := s.commandLine(r.Vars)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	exp, err := expect.NewExpect(prog, args...)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	exp.SetCmdOut(out)
+	defer func() {
+		shutCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+		defer cancel()
+		exp.Shutdown(shutCtx)
+	}()
+
+	timeout := DefaultTimeout
+	if s.Timeout > 0 {
+		timeout = time.Duration(s.Timeout)
+	}
+	for i, st := range s.Steps {
+		if r.Err != nil {
+			r.Steps = append(r.Steps, StepResult{Name: st.String()})
+			continue
+		}
+		sr := r.runStep(ctx, exp, out, st, timeout)
+		r.Steps = append(r.Steps, sr)
+		if sr.Err != nil {
+			r.Err = &StepError{Step: i + 1, Name: sr.Name, Err: sr.Err}
+		}
+	}
+
+	if r.Err == nil && s.ExitStatus != nil {
+		r.Err = checkExit(ctx, exp, *s.ExitStatus, timeout)
+	}
+
+	if r.Err != nil {
+		for _, st := range s.OnFailure {
+			st.Retries = 0
+			r.OnFailure = append(r.OnFailure, r.runStep(ctx, exp, out, st, timeout))
+		}
+	}
+	return r
+}
+
+// RunAll runs scenarios with at most parallel of them running at once, or
+// all at once if parallel is less than 1. If done is not nil it is called, one
+// at a time, with each Result as its scenario ends. The Results are returned
+// in the same order as scenarios.
+func RunAll(ctx context.Context, scenarios []*Scenario, parallel int, done func(*Result)) []*Result {
+	if parallel < 1 || parallel > len(scenarios) {
+		parallel = len(scenarios)
+	}
+	results := make([]*Result, len(scenarios))
+	slots := make(chan struct{}, parallel)
+	var doneMu sync.Mutex
+	var wg sync.WaitGroup
+	for i, s := range scenarios {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = s.Run(ctx)
+			<-slots
+			if done != nil {
+				doneMu.Lock()
+				defer doneMu.Unlock()
+				done(results[i])
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// commandLine returns the program and args to spawn. Env is passed by
+// running the command with env(1).
+func (s *Scenario) commandLine(vars Vars) (string, []string, error) {
+	words := append([]Scalar{s.Command}, s.Args...)
+	if len(s.Env) > 0 {
+		words = append(append([]Scalar{"env"}, s.Env...), words...)
+	}
+	expanded := make([]string, len(words))
+	for i, w := range words {
+		var err error
+		if expanded[i], err = vars.expand(string(w)); err != nil {
+			return "", nil, err
+		}
+	}
+	return expanded[0], expanded[1:], nil
+}
+
+// checkExit waits for the command to exit with code
+func checkExit(ctx context.Context, exp *expect.Expect, code int, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-exp.Done():
+	case <-timer.C:
+		return fmt.Errorf("command did not exit within %s", timeout)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if _, err := exp.Wait(); err != nil {
+		return err
+	}
+	if got := exp.ExitCode(); got != code {
+		return fmt.Errorf("exit status is %d not %d", got, code)
+	}
+	return nil
+}
+
+// runStep runs st, trying it again after a failure if it has retries
+func (r *Result) runStep(ctx context.Context, exp *expect.Expect, out *transcript, st Step, timeout time.Duration) StepResult {
+	start := time.Now()
+	sr := StepResult{Name: st.String(), OutputStart: out.Len()}
+	if st.Timeout > 0 {
+		timeout = time.Duration(st.Timeout)
+	}
+	for {
+		sr.Attempts++
+		sr.Err = r.attempt(ctx, exp, st, timeout, &sr)
+		if sr.Err == nil || sr.Attempts > st.Retries || ctx.Err() != nil {
+			break
+		}
+		if !sleep(ctx, time.Duration(st.RetryDelay)) {
+			sr.Err = ctx.Err()
+			break
+		}
+	}
+	if sr.Err != nil {
+		sr.Tail = string(errorTail(sr.Err))
+	}
+	sr.OutputEnd = out.Len()
+	sr.Duration = time.Since(start)
+	return sr
+}
+
+// errorTail returns the buffer tail saved in an expect error
+func errorTail(err error) []byte {
+	var te *expect.TimeoutError
+	var ee *expect.EOFError
+	var re *expect.ReadError
+	switch {
+	case errors.As(err, &te):
+		return te.Tail
+	case errors.As(err, &ee):
+		return ee.Tail
+	case errors.As(err, &re):
+		return re.Tail
+	}
+	return nil
+}
+
+// attempt tries st once, filling in the Pattern and Match of sr
+func (r *Result) attempt(ctx context.Context, exp *expect.Expect, st Step, timeout time.Duration, sr *StepResult) error {
+	if !sleep(ctx, time.Duration(st.Sleep)) {
+		return ctx.Err()
+	}
+
+	for i, send := range []Scalar{st.Send, st.SendLine} {
+		if send == "" {
+			continue
+		}
+		text, err := r.Vars.expand(string(send))
+		if err != nil {
+			return err
+		}
+		if i == 1 {
+			text += "\r"
+		}
+		if _, err := exp.Send(text); err != nil {
+			return err
+		}
+	}
+
+	var pattern interface{}
+	var re *regexp.Regexp
+	switch {
+	case st.Expect != "":
+		text, err := r.Vars.expand(string(st.Expect))
+		if err != nil {
+			return err
+		}
+		pattern = text
+		sr.Pattern = text
+	case st.ExpectRe != "":
+		text, err := r.Vars.expandRe(string(st.ExpectRe))
+		if err != nil {
+			return err
+		}
+		if re, err = regexp.Compile(text); err != nil {
+			return err
+		}
+		if len(st.Capture) > re.NumSubexp() {
+			return fmt.Errorf("capture names %d groups but expect_re has %d", len(st.Capture), re.NumSubexp())
+		}
+		pattern = re
+		sr.Pattern = text
+	case st.ExpectEOF:
+		pattern = expect.EndOfFile
+		sr.Pattern = expect.EndOfFile.String()
+	default:
+		return nil
+	}
+
+	exp.SetTimeout(timeout)
+	started := time.Now()
+	n, found, err := exp.ExpectContext(ctx, pattern, expect.EndOfFile)
+	switch {
+	case n < 0:
+		return err
+	case n == 1 && !st.ExpectEOF:
+		tail := found
+		if len(tail) > expect.ErrorTailSize {
+			tail = tail[len(tail)-expect.ErrorTailSize:]
+		}
+		return &expect.EOFError{Patterns: []interface{}{pattern}, Elapsed: time.Since(started), Tail: tail}
+	}
+	sr.Match = string(found)
+
+	if re != nil {
+		m := re.FindSubmatch(found)
+		for g, name := range re.SubexpNames() {
+			if name != "" && m != nil {
+				r.Vars[name] = string(m[g])
+			}
+		}
+		for g, name := range st.Capture {
+			if m != nil {
+				r.Vars[name] = string(m[g+1])
+			}
+		}
+	}
+	return nil
+}
+
+// sleep waits for d or until ctx is done, returning false for the latter
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+var varRef = regexp.MustCompile(`\$?\$\{([^}]*)\}`)
+
+// expand replaces ${name} in s with the value of the var name. $${ is a
+// literal ${. An unknown var is an error.
+func (v Vars) expand(s string) (string, error) {
+	return v.expandQuoted(s, func(val string) string { return val })
+}
+
+// expandRe is expand() for a regexp, quoting the values so that they match
+// literally
+func (v Vars) expandRe(s string) (string, error) {
+	return v.expandQuoted(s, regexp.QuoteMeta)
+}
+
+// expandQuoted is expand() with each value passed through quote
+func (v Vars) expandQuoted(s string, quote func(string) string) (string, error) {
+	var err error
+	s = varRef.ReplaceAllStringFunc(s, func(ref string) string {
+		if strings.HasPrefix(ref, "$$") {
+			return ref[1:]
+		}
+		name := ref[2 : len(ref)-1]
+		val, ok := v[name]
+		if !ok && err == nil {
+			err = fmt.Errorf("undefined var %q", name)
+		}
+		return quote(val)
+	})
+	return s, err
+}
+
+// transcript collects the command's output. It is written by the Expect's
+// reader and read once the Expect has been shut down.
+type transcript struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (t *transcript) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.buf.Write(p)
+}
+
+func (t *transcript) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.buf.Len()
+}
+
+func (t *transcript) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.buf.String()
+}
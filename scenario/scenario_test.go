@@ -0,0 +1,201 @@
+/*
+File summary: go test of running scenarios
+Package: scenario
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package scenario
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/leemcloughlin/expect"
+)
+
+func Test_Parse(t *testing.T) {
+	yaml := `
+name: both
+command: sh
+args: [-c, "${script}"]
+timeout: 2.5
+vars: {script: "read x", n: 3, on: true}
+steps:
+  - sendline: hello
+    expect_re: '(h)ello'
+    capture: [first]
+    retries: 2
+    retry_delay: 100ms
+exit_status: 0
+`
+	json := `{
+	"name": "both", "command": "sh", "args": ["-c", "${script}"], "timeout": 2.5,
+	"vars": {"script": "read x", "n": 3, "on": true},
+	"steps": [{"sendline": "hello", "expect_re": "(h)ello", "capture": ["first"],
+		"retries": 2, "retry_delay": "100ms"}],
+	"exit_status": 0
+}`
+	for _, src := range []string{yaml, json} {
+		s, err := Parse([]byte(src))
+		if err != nil {
+			t.Errorf("Parse failed %s", err)
+			continue
+		}
+		if s.Name != "both" || s.Command != "sh" || len(s.Args) != 2 ||
+			time.Duration(s.Timeout) != 2500*time.Millisecond ||
+			s.Vars["n"] != "3" || s.Vars["on"] != "true" || s.ExitStatus == nil || *s.ExitStatus != 0 {
+			t.Errorf("Parse gave %+v", s)
+		}
+		if len(s.Steps) != 1 || s.Steps[0].SendLine != "hello" || s.Steps[0].Capture[0] != "first" ||
+			s.Steps[0].Retries != 2 || time.Duration(s.Steps[0].RetryDelay) != 100*time.Millisecond {
+			t.Errorf("Parse gave steps %+v", s.Steps)
+		}
+	}
+
+	// Numbers and booleans are read as strings
+	s, err := Parse([]byte("command: seq\nargs: [1, 2]\nenv: [X=1, 3]\nsteps:\n" +
+		"  - sendline: 42\n    expect: 100\n  - send: true\n    expect_re: 2.5\n"))
+	if err != nil {
+		t.Fatalf("Parse of scalars failed %s", err)
+	}
+	if !reflect.DeepEqual(s.Args, []Scalar{"1", "2"}) || !reflect.DeepEqual(s.Env, []Scalar{"X=1", "3"}) ||
+		s.Steps[0].SendLine != "42" || s.Steps[0].Expect != "100" ||
+		s.Steps[1].Send != "true" || s.Steps[1].ExpectRe != "2.5" {
+		t.Errorf("Parse of scalars gave %+v", s)
+	}
+
+	for src, msg := range map[string]string{
+		"steps: []\n":                         "no command",
+		"command: sh\nsteps:\n - expcet: x\n": `unknown field "expcet"`,
+		"command: sh\nsteps:\n - expect: x\n   expect_eof: true\n": "only one of",
+		"command: sh\nsteps:\n - expect: x\n   capture: [a]\n":     "capture needs expect_re",
+		"command: sh\ntimeout: soon\n":                             "invalid duration",
+		"command: sh\nsteps:\n - sendline: [a]\n":                  "is not a scalar",
+	} {
+		if _, err := Parse([]byte(src)); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%q error is %v, expected %q", src, err, msg)
+		}
+	}
+}
+
+func Test_Run(t *testing.T) {
+	s, err := Parse([]byte(`
+command: sh
+env: [GREETING=hi]
+timeout: 5s
+vars:
+  name: world
+  price: 1.5+tax
+steps:
+  - sendline: 'echo "$GREETING ${name}"'
+    expect: "hi world"
+  - sendline: echo 'price=${price}'
+    expect_re: 'price=${price}\r'
+  - sendline: echo version=1.$((20 + 3)) build=456
+    expect_re: 'version=(\d+)\.(\d+) build=(?P<build>\d+)\r'
+    capture: [major, minor]
+  - sendline: echo ${major}-${minor}-${build}
+    expect: 1-23-456
+  - sendline: exit 3
+    expect_eof: true
+exit_status: 3
+`))
+	if err != nil {
+		t.Fatalf("Parse failed %s", err)
+	}
+	r := s.Run(context.Background())
+	if !r.Passed() {
+		t.Fatalf("Run failed %s output %q", r.Err, r.Output)
+	}
+	if r.Vars["major"] != "1" || r.Vars["minor"] != "23" || r.Vars["build"] != "456" {
+		t.Errorf("vars are %v", r.Vars)
+	}
+	if len(r.Steps) != 5 || !strings.Contains(r.Output, "1-23-456") {
+		t.Errorf("result is %+v", r)
+	}
+	t.Logf("Run took %s", r.Duration)
+}
+
+func Test_RunFailure(t *testing.T) {
+	s := &Scenario{
+		Command: "sh",
+		Timeout: Duration(200 * time.Millisecond),
+		Steps: []Step{
+			{SendLine: "echo ok", Expect: "ok"},
+			{Name: "never", Expect: "never", Retries: 2, SendLine: "echo try"},
+			{SendLine: "echo not reached"},
+		},
+		OnFailure: []Step{{SendLine: "echo cleanup", Expect: "cleanup"}},
+	}
+	r := s.Run(context.Background())
+	var se *StepError
+	if !errors.As(r.Err, &se) || se.Step != 2 || se.Name != "never" || !errors.Is(r.Err, expect.ETimedOut) {
+		t.Fatalf("Run error is %v", r.Err)
+	}
+	if len(r.Steps) != 3 || r.Steps[1].Attempts != 3 || r.Steps[2].Attempts != 0 ||
+		!strings.Contains(r.Steps[1].Tail, "try") || r.Steps[0].Match != "ok" {
+		t.Errorf("steps are %+v", r.Steps)
+	}
+	if len(r.OnFailure) != 1 || r.OnFailure[0].Err != nil {
+		t.Errorf("on failure steps are %+v", r.OnFailure)
+	}
+	if strings.Count(r.Output, "try") < 3 || strings.Contains(r.Output, "not reached") {
+		t.Errorf("output is %q", r.Output)
+	}
+
+	// The process exiting is an EOF error
+	s = &Scenario{Command: "sh", Steps: []Step{{SendLine: "exit", Expect: "never"}}}
+	if r = s.Run(context.Background()); !errors.Is(r.Err, io.EOF) {
+		t.Errorf("exit error is %v", r.Err)
+	}
+
+	// So is a wrong exit status
+	code := 1
+	s = &Scenario{Command: "sh", Steps: []Step{{SendLine: "exit 2"}}, ExitStatus: &code}
+	if r = s.Run(context.Background()); r.Err == nil || !strings.Contains(r.Err.Error(), "exit status is 2 not 1") {
+		t.Errorf("exit status error is %v", r.Err)
+	}
+
+	// And an undefined var
+	s = &Scenario{Command: "sh", Steps: []Step{{SendLine: "echo ${nosuch} $${literal}"}}}
+	if r = s.Run(context.Background()); r.Err == nil || !strings.Contains(r.Err.Error(), `undefined var "nosuch"`) {
+		t.Errorf("undefined var error is %v", r.Err)
+	}
+
+	// Cancelling stops the step
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	s = &Scenario{Command: "sh", Steps: []Step{{Expect: "never", Timeout: Duration(time.Minute)}}}
+	if r = s.Run(ctx); !errors.Is(r.Err, context.DeadlineExceeded) {
+		t.Errorf("cancelled error is %v", r.Err)
+	}
+}
+
+func Test_Load(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "echo-test.yaml")
+	if err := os.WriteFile(path, []byte("command: cat\nsteps:\n  - {sendline: hi, expect: hi}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := Load(path)
+	if err != nil || s.Name != "echo-test" {
+		t.Fatalf("Load is %+v %v", s, err)
+	}
+	if r := s.Run(context.Background()); !r.Passed() {
+		t.Errorf("Run failed %s", r.Err)
+	}
+
+	os.WriteFile(path, []byte("command: [\n"), 0644)
+	if _, err := Load(path); err == nil || !strings.HasPrefix(err.Error(), path+": yaml: line 1") {
+		t.Errorf("Load error is %v", err)
+	}
+}
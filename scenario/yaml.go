@@ -0,0 +1,497 @@
+/*
+File summary: A reader for the subset of YAML used by scenario files
+Package: scenario
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package scenario
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// parseYAML reads the subset of YAML that scenario files need: block mappings
+// and sequences, plain, single and double quoted scalars, one line flow
+// sequences and mappings, literal (|) and folded (>) block scalars and
+// comments. Anchors, aliases, tags and multiple documents are not supported.
+// Mappings are returned as map[string]interface{}, sequences as
+// []interface{} and scalars as string, float64, bool or nil as for
+// encoding/json.
+func parseYAML(data []byte) (interface{}, error) {
+	if !utf8.Valid(data) {
+		return nil, fmt.Errorf("yaml: not valid UTF-8")
+	}
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	text = strings.TrimPrefix(text, "\ufeff")
+	// The line break ending the last line does not start another one, which
+	// a block scalar with keep chomping would take as a blank line
+	p := &yamlParser{lines: strings.Split(strings.TrimSuffix(text, "\n"), "\n")}
+	if p.skipBlank(); p.n < len(p.lines) && strings.TrimSpace(stripComment(p.lines[p.n])) == "---" {
+		p.n++
+	}
+
+	v, err := p.block(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.skipBlank(); p.n < len(p.lines) {
+		return nil, p.errorf("unexpected %q", strings.TrimSpace(p.lines[p.n]))
+	}
+	return v, nil
+}
+
+type yamlParser struct {
+	lines []string
+	n     int // the next line to read
+}
+
+func (p *yamlParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("yaml: line %d: %s", p.n+1, fmt.Sprintf(format, args...))
+}
+
+// skipBlank moves past blank and comment only lines
+func (p *yamlParser) skipBlank() {
+	for p.n < len(p.lines) && strings.TrimSpace(stripComment(p.lines[p.n])) == "" {
+		p.n++
+	}
+}
+
+// next returns the indent and content of the next non blank line, or -1 at
+// the end of the input
+func (p *yamlParser) next() (int, string, error) {
+	p.skipBlank()
+	if p.n >= len(p.lines) {
+		return -1, "", nil
+	}
+	line := stripComment(p.lines[p.n])
+	content := strings.TrimLeft(line, " ")
+	if strings.HasPrefix(content, "\t") {
+		return 0, "", p.errorf("tabs cannot be used for indentation")
+	}
+	return len(line) - len(content), strings.TrimRight(content, " \t"), nil
+}
+
+// block reads the node whose lines are indented at least indent
+func (p *yamlParser) block(indent int) (interface{}, error) {
+	ind, content, err := p.next()
+	if err != nil || ind < indent {
+		return nil, err
+	}
+	switch {
+	case isSeqItem(content):
+		return p.sequence(ind)
+	case mappingColon(content) >= 0:
+		return p.mapping(ind)
+	}
+	p.n++
+	return p.scalar(content)
+}
+
+// isSeqItem reports whether content is a block sequence item
+func isSeqItem(content string) bool {
+	return content == "-" || strings.HasPrefix(content, "- ")
+}
+
+// sequence reads the block sequence items at indent
+func (p *yamlParser) sequence(indent int) (interface{}, error) {
+	seq := []interface{}{}
+	for {
+		ind, content, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if ind < indent || (ind == indent && !isSeqItem(content)) {
+			return seq, nil
+		}
+		if ind > indent {
+			return nil, p.errorf("bad indentation of a sequence item")
+		}
+
+		item := strings.TrimLeft(content[1:], " ")
+		var v interface{}
+		if item == "" {
+			p.n++
+			v, err = p.block(indent + 1)
+		} else {
+			// Treat the rest of the line as if it started a line of its own
+			// so that "- key: value" can be followed by more keys at the
+			// same indent as key
+			at := indent + len(content) - len(item)
+			p.lines[p.n] = strings.Repeat(" ", at) + item
+			v, err = p.block(at)
+		}
+		if err != nil {
+			return nil, err
+		}
+		seq = append(seq, v)
+	}
+}
+
+// mapping reads the block mapping keys at indent
+func (p *yamlParser) mapping(indent int) (interface{}, error) {
+	m := map[string]interface{}{}
+	for {
+		ind, content, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if ind < indent {
+			return m, nil
+		}
+		colon := mappingColon(content)
+		if ind > indent || colon < 0 {
+			if ind == indent && isSeqItem(content) {
+				return m, nil
+			}
+			return nil, p.errorf("expected a key at this indentation")
+		}
+
+		key, err := p.key(content[:colon])
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := m[key]; dup {
+			return nil, p.errorf("duplicate key %q", key)
+		}
+		rest := strings.TrimSpace(content[colon+1:])
+
+		var v interface{}
+		switch {
+		case rest == "":
+			// The value is on the following lines, a sequence may be at
+			// the same indent as the key
+			p.n++
+			nind, ncontent, err := p.next()
+			if err != nil {
+				return nil, err
+			}
+			if nind > indent {
+				v, err = p.block(nind)
+			} else if nind == indent && isSeqItem(ncontent) {
+				v, err = p.sequence(nind)
+			}
+			if err != nil {
+				return nil, err
+			}
+		case rest[0] == '|' || rest[0] == '>':
+			p.n++
+			v, err = p.blockScalar(indent, rest)
+		default:
+			v, err = p.scalar(rest)
+			p.n++
+		}
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+}
+
+// key returns the mapping key s, which may be quoted
+func (p *yamlParser) key(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s != "" && (s[0] == '"' || s[0] == '\'') {
+		v, err := p.scalar(s)
+		if err != nil {
+			return "", err
+		}
+		return v.(string), nil
+	}
+	return s, nil
+}
+
+// blockScalar reads a literal or folded block scalar whose header is header
+// and which belongs to a key at indent
+func (p *yamlParser) blockScalar(indent int, header string) (interface{}, error) {
+	style, chomp := header[0], header[1:]
+	if chomp != "" && chomp != "-" && chomp != "+" {
+		return nil, p.errorf("unsupported block scalar header %q", header)
+	}
+
+	var lines []string
+	contentIndent := -1
+	for ; p.n < len(p.lines); p.n++ {
+		line := p.lines[p.n]
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" {
+			lines = append(lines, "")
+			continue
+		}
+		ind := len(line) - len(trimmed)
+		if ind <= indent {
+			break
+		}
+		if contentIndent < 0 {
+			contentIndent = ind
+		}
+		if ind < contentIndent {
+			return nil, p.errorf("bad indentation in block scalar")
+		}
+		lines = append(lines, line[contentIndent:])
+	}
+
+	// Trailing blank lines belong to the block only for keep chomping
+	body := len(lines)
+	for body > 0 && lines[body-1] == "" {
+		body--
+	}
+	var b strings.Builder
+	for i, line := range lines[:body] {
+		switch {
+		case i == 0:
+		case style == '>' && line != "" && lines[i-1] == "" && !strings.HasPrefix(line, " "):
+			// The blank line before has already given the line break
+		case style == '|' || line == "" || lines[i-1] == "" ||
+			strings.HasPrefix(line, " ") || strings.HasPrefix(lines[i-1], " "):
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(' ')
+		}
+		b.WriteString(line)
+	}
+	switch chomp {
+	case "":
+		if body > 0 {
+			b.WriteByte('\n')
+		}
+	case "+":
+		b.WriteString(strings.Repeat("\n", len(lines)-body+1))
+	}
+	return b.String(), nil
+}
+
+var yamlNumber = regexp.MustCompile(`^[-+]?(\d+|\d*\.\d+|\d+\.\d*)([eE][-+]?\d+)?$`)
+
+// scalar returns the value of a scalar or flow node written on one line
+func (p *yamlParser) scalar(s string) (interface{}, error) {
+	v, end, err := p.flowValue(s, 0, false)
+	if err != nil {
+		return nil, err
+	}
+	if rest := strings.TrimSpace(s[end:]); rest != "" {
+		return nil, p.errorf("unexpected %q after value", rest)
+	}
+	return v, nil
+}
+
+// flowValue reads the value starting at s[pos] and returns it and the index
+// after it. If inFlow is true the value is inside [] or {} and a plain scalar
+// ends at a comma or closing bracket.
+func (p *yamlParser) flowValue(s string, pos int, inFlow bool) (interface{}, int, error) {
+	for pos < len(s) && s[pos] == ' ' {
+		pos++
+	}
+	if pos >= len(s) {
+		return nil, pos, nil
+	}
+	switch s[pos] {
+	case '"':
+		return p.doubleQuoted(s, pos)
+	case '\'':
+		return p.singleQuoted(s, pos)
+	case '[':
+		return p.flowSequence(s, pos)
+	case '{':
+		return p.flowMapping(s, pos)
+	case '&', '*', '!':
+		return nil, pos, p.errorf("anchors, aliases and tags are not supported")
+	}
+
+	end := pos
+	for end < len(s) {
+		c := s[end]
+		if inFlow && (c == ',' || c == ']' || c == '}' ||
+			(c == ':' && (end+1 == len(s) || strings.ContainsRune(" ,]}", rune(s[end+1]))))) {
+			break
+		}
+		end++
+	}
+	plain := strings.TrimSpace(s[pos:end])
+	switch {
+	case plain == "" || plain == "~" || plain == "null":
+		return nil, end, nil
+	case plain == "true":
+		return true, end, nil
+	case plain == "false":
+		return false, end, nil
+	case yamlNumber.MatchString(plain):
+		f, err := strconv.ParseFloat(plain, 64)
+		if err == nil {
+			return f, end, nil
+		}
+	}
+	return plain, end, nil
+}
+
+func (p *yamlParser) flowSequence(s string, pos int) (interface{}, int, error) {
+	seq := []interface{}{}
+	pos++
+	for {
+		pos = skipSpaces(s, pos)
+		if pos >= len(s) {
+			return nil, pos, p.errorf("missing ] (flow sequences must be on one line)")
+		}
+		if s[pos] == ']' {
+			return seq, pos + 1, nil
+		}
+		v, end, err := p.flowValue(s, pos, true)
+		if err != nil {
+			return nil, end, err
+		}
+		seq = append(seq, v)
+		if pos = skipSpaces(s, end); pos < len(s) && s[pos] == ',' {
+			pos++
+		} else if pos < len(s) && s[pos] != ']' {
+			return nil, pos, p.errorf("expected , or ] in flow sequence")
+		}
+	}
+}
+
+func (p *yamlParser) flowMapping(s string, pos int) (interface{}, int, error) {
+	m := map[string]interface{}{}
+	pos++
+	for {
+		pos = skipSpaces(s, pos)
+		if pos >= len(s) {
+			return nil, pos, p.errorf("missing } (flow mappings must be on one line)")
+		}
+		if s[pos] == '}' {
+			return m, pos + 1, nil
+		}
+		k, end, err := p.flowValue(s, pos, true)
+		if err != nil {
+			return nil, end, err
+		}
+		if pos = skipSpaces(s, end); pos >= len(s) || s[pos] != ':' {
+			return nil, pos, p.errorf("expected : in flow mapping")
+		}
+		v, end, err := p.flowValue(s, pos+1, true)
+		if err != nil {
+			return nil, end, err
+		}
+		m[fmt.Sprint(k)] = v
+		if pos = skipSpaces(s, end); pos < len(s) && s[pos] == ',' {
+			pos++
+		} else if pos < len(s) && s[pos] != '}' {
+			return nil, pos, p.errorf("expected , or } in flow mapping")
+		}
+	}
+}
+
+func (p *yamlParser) singleQuoted(s string, pos int) (interface{}, int, error) {
+	var b strings.Builder
+	for i := pos + 1; i < len(s); i++ {
+		if s[i] == '\'' {
+			if i+1 < len(s) && s[i+1] == '\'' {
+				b.WriteByte('\'')
+				i++
+				continue
+			}
+			return b.String(), i + 1, nil
+		}
+		b.WriteByte(s[i])
+	}
+	return nil, len(s), p.errorf("missing closing '")
+}
+
+var yamlEscapes = map[byte]string{
+	'0': "\x00", 'a': "\a", 'b': "\b", 't': "\t", 'n': "\n", 'v': "\v",
+	'f': "\f", 'r': "\r", 'e': "\x1b", ' ': " ", '"': "\"", '/': "/",
+	'\\': "\\",
+}
+
+func (p *yamlParser) doubleQuoted(s string, pos int) (interface{}, int, error) {
+	var b strings.Builder
+	for i := pos + 1; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return b.String(), i + 1, nil
+		case '\\':
+			if i+1 >= len(s) {
+				return nil, i, p.errorf("missing closing \"")
+			}
+			i++
+			if e, ok := yamlEscapes[s[i]]; ok {
+				b.WriteString(e)
+				continue
+			}
+			size := map[byte]int{'x': 2, 'u': 4, 'U': 8}[s[i]]
+			if size == 0 || i+size >= len(s) {
+				return nil, i, p.errorf("unknown escape \\%c", s[i])
+			}
+			r, err := strconv.ParseUint(s[i+1:i+1+size], 16, 32)
+			if err != nil {
+				return nil, i, p.errorf("bad escape \\%s", s[i:i+1+size])
+			}
+			b.WriteRune(rune(r))
+			i += size
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return nil, len(s), p.errorf("missing closing \" (quoted strings must be on one line)")
+}
+
+func skipSpaces(s string, pos int) int {
+	for pos < len(s) && s[pos] == ' ' {
+		pos++
+	}
+	return pos
+}
+
+// mappingColon returns the index of the colon ending the key in content, or
+// -1 if content is not a "key: value" line
+func mappingColon(content string) int {
+	if content == "" || strings.ContainsRune("[{|>", rune(content[0])) {
+		return -1
+	}
+	var quote byte
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' {
+				i++
+			}
+		case (c == '"' || c == '\'') && i == 0:
+			quote = c
+		case c == ':' && (i+1 == len(content) || content[i+1] == ' '):
+			return i
+		}
+	}
+	return -1
+}
+
+// stripComment removes a # comment from line. A # only starts a comment at
+// the start of the line or after a space, and not inside quotes.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' {
+				i++
+			}
+		case c == '"' || c == '\'':
+			// Only a quote at the start of a value opens a quoted scalar
+			if i == 0 || strings.ContainsRune(" [{,:-", rune(line[i-1])) {
+				quote = c
+			}
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
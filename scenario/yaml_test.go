@@ -0,0 +1,89 @@
+/*
+File summary: go test of the scenario YAML reader
+Package: scenario
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package scenario
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_YAML(t *testing.T) {
+	for _, tc := range []struct {
+		yaml     string
+		expected interface{}
+	}{
+		{"a: 1\nb: two\nc: true\nd: ~\n", map[string]interface{}{"a": 1.0, "b": "two", "c": true, "d": nil}},
+		{"# comment\n---\nkey: value # trailing\n", map[string]interface{}{"key": "value"}},
+		{"- a\n- 'b c'\n- \"d\\te\"\n", []interface{}{"a", "b c", "d\te"}},
+		{"list: [a, \"b, c\", 3]\nmap: {x: 1, 'y': z}\n", map[string]interface{}{
+			"list": []interface{}{"a", "b, c", 3.0},
+			"map":  map[string]interface{}{"x": 1.0, "y": "z"},
+		}},
+		{"steps:\n- send: a\n  expect: b\n-   expect: c\n    timeout: 1s\n", map[string]interface{}{
+			"steps": []interface{}{
+				map[string]interface{}{"send": "a", "expect": "b"},
+				map[string]interface{}{"expect": "c", "timeout": "1s"},
+			},
+		}},
+		{"a:\n  b:\n    - 1\n    - - 2\n      - 3\n  c: d\ne: f\n", map[string]interface{}{
+			"a": map[string]interface{}{"b": []interface{}{1.0, []interface{}{2.0, 3.0}}, "c": "d"},
+			"e": "f",
+		}},
+		{"url: http://host:80/x\nre: 'a#b: c'\nhash: a#b\n", map[string]interface{}{
+			"url": "http://host:80/x", "re": "a#b: c", "hash": "a#b",
+		}},
+		{"s: \"\\x03\\r\\e[1m\\u00e9\\\"\"\n", map[string]interface{}{"s": "\x03\r\x1b[1mé\""}},
+		{"s: 'it''s'\n\"quoted key\": v\n", map[string]interface{}{"s": "it's", "quoted key": "v"}},
+		{"lit: |\n  one\n    two\n\n  # not a comment\nnext: x\n", map[string]interface{}{
+			"lit": "one\n  two\n\n# not a comment\n", "next": "x",
+		}},
+		{"strip: |-\n  a\n  b\n\nfold: >\n  a\n  b\n\n  c\n", map[string]interface{}{
+			"strip": "a\nb", "fold": "a b\nc\n",
+		}},
+		{"keep: |+\n  a\n\n\nnext: x\nend: |+\n  b\n\n", map[string]interface{}{
+			"keep": "a\n\n\n", "next": "x", "end": "b\n\n",
+		}},
+		{"n: [1.5, -2, 1e3, 0x10, 1.2.3]\n", map[string]interface{}{
+			"n": []interface{}{1.5, -2.0, 1000.0, "0x10", "1.2.3"},
+		}},
+		{"empty:\nlist: []\n", map[string]interface{}{"empty": nil, "list": []interface{}{}}},
+	} {
+		v, err := parseYAML([]byte(tc.yaml))
+		if err != nil {
+			t.Errorf("%q failed %s", tc.yaml, err)
+			continue
+		}
+		if !reflect.DeepEqual(v, tc.expected) {
+			t.Errorf("%q is %#v not %#v", tc.yaml, v, tc.expected)
+		}
+	}
+}
+
+func Test_YAMLErrors(t *testing.T) {
+	for _, tc := range []struct {
+		yaml string
+		msg  string
+	}{
+		{"a: 1\n  b: 2\n", "line 2: expected a key"},
+		{"a: 1\na: 2\n", "line 2: duplicate key"},
+		{"a: \"open\n", "line 1: missing closing \""},
+		{"a: [1, 2\n", "missing ]"},
+		{"a: *ref\n", "aliases"},
+		{"a:\n\t- b\n", "tabs"},
+		{"- a\nb: c\n", "line 2: unexpected"},
+		{"a: \"\\q\"\n", "unknown escape"},
+	} {
+		_, err := parseYAML([]byte(tc.yaml))
+		if err == nil || !strings.Contains(err.Error(), tc.msg) {
+			t.Errorf("%q error is %v, expected %q", tc.yaml, err, tc.msg)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+/*
+File summary: Find the processes in a session on Linux
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// foregroundPgrp returns the foreground process group of the terminal on pty
+func foregroundPgrp(pty *os.File) (int, error) {
+	var pgrp int32
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, pty.Fd(), syscall.TIOCGPGRP,
+		uintptr(unsafe.Pointer(&pgrp)))
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(pgrp), nil
+}
+
+// sessionPids returns the pids of all the live (not zombie) processes in
+// session sid by scanning /proc
+func sessionPids(sid int) []int {
+	dir, err := os.Open("/proc")
+	if err != nil {
+		return nil
+	}
+	defer dir.Close()
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return nil
+	}
+
+	var pids []int
+	for _, name := range names {
+		pid, err := strconv.Atoi(name)
+		if err != nil {
+			continue
+		}
+		stat, err := os.ReadFile("/proc/" + name + "/stat")
+		if err != nil {
+			continue
+		}
+		// The format is: pid (comm) state ppid pgrp session ...
+		// comm can contain anything so skip to after the last )
+		end := bytes.LastIndexByte(stat, ')')
+		if end < 0 {
+			continue
+		}
+		fields := bytes.Fields(stat[end+1:])
+		if len(fields) < 4 || string(fields[0]) == "Z" {
+			continue
+		}
+		session, err := strconv.Atoi(string(fields[3]))
+		if err == nil && session == sid {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
+
+// pendingInput returns the number of bytes written to the pty that the
+// command has not yet read. It opens the slave side each time as holding it
+// open would stop the master ever seeing EOF.
+func pendingInput(pty *os.File) (int, error) {
+	var ptn uint32
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, pty.Fd(), syscall.TIOCGPTN,
+		uintptr(unsafe.Pointer(&ptn)))
+	if errno != 0 {
+		return 0, errno
+	}
+	tty, err := os.OpenFile("/dev/pts/"+strconv.Itoa(int(ptn)), os.O_RDONLY|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer tty.Close()
+
+	var n int32
+	_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, tty.Fd(), syscall.TIOCINQ,
+		uintptr(unsafe.Pointer(&n)))
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(n), nil
+}
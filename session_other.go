@@ -0,0 +1,33 @@
+//go:build !linux
+
+/*
+File summary: Find the processes in a session, unsupported
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"errors"
+	"os"
+)
+
+// foregroundPgrp is only supported on Linux
+func foregroundPgrp(pty *os.File) (int, error) {
+	return 0, errors.New("foreground process group not supported")
+}
+
+// sessionPids is only supported on Linux. Elsewhere only process groups are
+// signalled.
+func sessionPids(sid int) []int {
+	return nil
+}
+
+// pendingInput is only supported on Linux
+func pendingInput(pty *os.File) (int, error) {
+	return 0, errors.New("pending input not supported")
+}
@@ -0,0 +1,172 @@
+/*
+File summary: ShellSession runs commands in an interactive shell
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ShellSession is an interactive bash, zsh or sh on which commands can be run
+// with Run(). It sets its own prompts to a unique marker and turns off echo
+// and line editing so the output of each command can be picked out exactly.
+// The *Expect is available for anything else, such as answering a prompt
+// from a command, but the marker prompt must not be disturbed.
+type ShellSession struct {
+	*Expect
+
+	// prompt is the unique marker PS1 is set to
+	prompt string
+
+	// trailer matches the line printed after each command with its exit code
+	trailer *regexp.Regexp
+
+	// trailerCmd is sent after each command to print the trailer. It leaves
+	// $? as the command set it, for shell marks and the next command.
+	trailerCmd string
+
+	// shell is the shell's path as passed to NewShellSession
+	shell string
+}
+
+// ShellStartTimeout is how long NewShellSession waits for the shell to start
+var ShellStartTimeout = 10 * time.Second
+
+// NewShellSession starts shell, which should be bash, zsh or sh (or a shell
+// compatible with sh such as dash or ash), without reading any startup files
+// and readies it for Run()
+func NewShellSession(shell string) (*ShellSession, error) {
+	name := filepath.Base(shell)
+	var args []string
+	setup := "stty -echo; PS2=''; unset PROMPT_COMMAND"
+	switch {
+	case strings.Contains(name, "bash"):
+		args = []string{"--norc", "--noprofile", "--noediting", "-i"}
+	case strings.Contains(name, "zsh"):
+		args = []string{"-f", "-i"}
+		setup += "; unsetopt zle prompt_cr prompt_sp"
+	default:
+		args = []string{"-i"}
+	}
+
+	exp, err := NewExpect(shell, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	// The marker is split with quotes whenever it is typed so that should
+	// anything be echoed it does not match
+	id := fmt.Sprintf("%08x", rand.Uint32())
+	s := &ShellSession{
+		Expect:     exp,
+		prompt:     "EXP" + id + "> ",
+		trailer:    regexp.MustCompile(`EXP` + id + `RC=(\d+)\r?\n`),
+		trailerCmd: fmt.Sprintf(`expect_rc=$?; printf '%%s%%s%%d\n' 'EXP' '%sRC=' "$expect_rc"; (exit $expect_rc)`, id),
+		shell:      shell,
+	}
+
+	setup += fmt.Sprintf("; PS1='EXP''%s> '\n", id)
+	debugf("NewShellSession setup %q", setup)
+	if _, err := exp.Send(setup); err != nil {
+		exp.Kill()
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ShellStartTimeout)
+	defer cancel()
+	if err := s.waitPrompt(ctx); err != nil {
+		exp.Kill()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Run runs cmd in the shell and returns its output and exit code. The output
+// is everything the command wrote to the terminal, so both stdout and stderr,
+// with the terminal's "\r\n" line endings turned back into "\n".
+// cmd can be more than one line. If ctx is done before cmd finishes it is sent
+// an interrupt (^C) and ctx.Err() is returned.
+func (s *ShellSession) Run(ctx context.Context, cmd string) (string, int, error) {
+	s.Clear()
+	// Braces make the shell read the whole of cmd before running any of it
+	// so nothing sent after cmd can end up as its input
+	line := "{ " + cmd + "\n}; " + s.trailerCmd + "\n"
+	debugf("ShellSession Run %q", line)
+	if _, err := s.Send(line); err != nil {
+		return "", -1, err
+	}
+
+	n, before, found, err := s.expectBefore(ctx, []interface{}{s.trailer, EndOfFile})
+	switch {
+	case n == Cancelled:
+		s.resync()
+		return "", -1, err
+	case n == 1:
+		return "", -1, &EOFError{Patterns: []interface{}{s.trailer}, Tail: errorTail(found)}
+	case n < 0:
+		return "", -1, err
+	}
+
+	match := s.trailer.FindSubmatch(found)
+	output := string(bytes.ReplaceAll(StripShellMarks(before), []byte("\r\n"), []byte("\n")))
+	code, _ := strconv.Atoi(string(match[1]))
+
+	if err := s.waitPrompt(ctx); err != nil {
+		return output, code, err
+	}
+	return output, code, nil
+}
+
+// EnableShellMarks makes a bash or zsh session send OSC 133 marks and starts
+// parsing them, see Expect.EnableShellMarks(). Run() removes the marks from
+// the output it returns.
+func (s *ShellSession) EnableShellMarks() (*ShellMarks, error) {
+	setup, err := ShellMarksSetup(s.shell)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := s.Run(context.Background(), setup); err != nil {
+		return nil, err
+	}
+	return s.Expect.EnableShellMarks(), nil
+}
+
+// Close ends the shell, first asking it to exit and then as Shutdown() does
+func (s *ShellSession) Close() error {
+	s.Send("exit\n")
+	return s.Shutdown(context.Background())
+}
+
+// waitPrompt waits for the marker prompt
+func (s *ShellSession) waitPrompt(ctx context.Context) error {
+	n, found, err := s.ExpectContext(ctx, s.prompt, EndOfFile)
+	if n == 1 {
+		return &EOFError{Patterns: []interface{}{s.prompt}, Tail: errorTail(found)}
+	}
+	return err
+}
+
+// resync interrupts whatever is running and waits briefly for the prompt so
+// the next Run() starts cleanly
+func (s *ShellSession) resync() {
+	s.SendIntr()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.waitPrompt(ctx); err != nil {
+		debugf("ShellSession cannot resync: %s", err)
+	}
+}
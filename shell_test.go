@@ -0,0 +1,91 @@
+/*
+File summary: go test of ShellSession
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func testShellSession(t *testing.T, shell string) {
+	if _, err := exec.LookPath(shell); err != nil {
+		t.Skipf("%s not installed", shell)
+	}
+
+	s, err := NewShellSession(shell)
+	if err != nil {
+		t.Errorf("NewShellSession(%s) failed %s", shell, err)
+		return
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	for _, tc := range []struct {
+		cmd    string
+		output string
+		code   int
+	}{
+		{"echo hello", "hello\n", 0},
+		{"printf 'no newline'", "no newline", 0},
+		{"true", "", 0},
+		{"false", "", 1},
+		{"echo out; echo err >&2; (exit 3)", "out\nerr\n", 3},
+		{"for i in 1 2 3\ndo\n  echo line $i\ndone", "line 1\nline 2\nline 3\n", 0},
+		{"x=42", "", 0},
+		{"echo $x", "42\n", 0},
+	} {
+		output, code, err := s.Run(ctx, tc.cmd)
+		if err != nil {
+			t.Errorf("%s Run(%q) failed %s", shell, tc.cmd, err)
+			continue
+		}
+		if output != tc.output || code != tc.code {
+			t.Errorf("%s Run(%q) is %q, %d not %q, %d", shell, tc.cmd, output, code, tc.output, tc.code)
+		} else {
+			t.Logf("%s Run(%q) is %q, %d", shell, tc.cmd, output, code)
+		}
+	}
+
+	// A cancelled command is interrupted and the session can still be used
+	cctx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	_, _, err = s.Run(cctx, "sleep 10")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("%s Run(sleep) err is %v not DeadlineExceeded", shell, err)
+	}
+	output, code, err := s.Run(ctx, "echo after")
+	if err != nil || output != "after\n" || code != 0 {
+		t.Errorf("%s Run after cancel is %q, %d, %v", shell, output, code, err)
+	}
+}
+
+func Test_ShellSessionBash(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	testShellSession(t, "bash")
+}
+
+func Test_ShellSessionSh(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	testShellSession(t, "sh")
+}
+
+func Test_ShellSessionZsh(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	testShellSession(t, "zsh")
+}
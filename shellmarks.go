@@ -0,0 +1,319 @@
+/*
+File summary: Shell integration marks (OSC 133) for exact command boundaries
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ShellMark is one of the OSC 133 shell integration marks, as sent by
+// ESC ] 133 ; <mark> BEL
+type ShellMark int
+
+const (
+	// MarkPromptStart (A) is sent before the prompt
+	MarkPromptStart ShellMark = iota
+
+	// MarkCommandStart (B) is sent after the prompt, where the command is typed
+	MarkCommandStart
+
+	// MarkOutputStart (C) is sent once the command is entered, before it runs
+	MarkOutputStart
+
+	// MarkCommandEnd (D) is sent when the command has finished, usually with
+	// its exit code
+	MarkCommandEnd
+)
+
+func (m ShellMark) String() string {
+	switch m {
+	case MarkPromptStart:
+		return "PromptStart"
+	case MarkCommandStart:
+		return "CommandStart"
+	case MarkOutputStart:
+		return "OutputStart"
+	case MarkCommandEnd:
+		return "CommandEnd"
+	}
+	return fmt.Sprintf("ShellMark(%d)", int(m))
+}
+
+// ShellEvent is a shell integration mark seen in the output
+type ShellEvent struct {
+	Mark ShellMark
+
+	// ExitCode is the exit code given with MarkCommandEnd or -1 if none was
+	ExitCode int
+
+	// Output is, for MarkCommandEnd, everything output between
+	// MarkOutputStart and MarkCommandEnd with "\r\n" turned back into "\n"
+	// and any other OSC sequences removed
+	Output []byte
+}
+
+// ShellMarksMaxEvents is the most events ShellMarks holds waiting to be read
+// by Next(). Beyond that the oldest are dropped.
+var ShellMarksMaxEvents = 1024
+
+// ShellMarks parses OSC 133 marks out of a command's output and queues them
+// as ShellEvents. Get one with EnableShellMarks() or feed one yourself with
+// Write().
+// A MarkCommandEnd without a MarkOutputStart before it, as shells send after
+// an empty command line, is ignored.
+type ShellMarks struct {
+	mu     sync.Mutex
+	events []ShellEvent
+	closed bool
+
+	// notify is closed, and replaced, when an event is queued or ShellMarks
+	// is closed
+	notify chan struct{}
+
+	// Parser state
+	state    int
+	osc      []byte
+	inOutput bool
+	output   bytes.Buffer
+}
+
+const (
+	smGround = iota
+	smEscape
+	smOSC
+	smOSCEscape
+)
+
+// NewShellMarks returns a ShellMarks ready for Write()
+func NewShellMarks() *ShellMarks {
+	return &ShellMarks{notify: make(chan struct{})}
+}
+
+// Write parses p for marks. It never fails.
+func (m *ShellMarks) Write(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, b := range p {
+		m.scan(b)
+	}
+	return len(p), nil
+}
+
+// scan parses the next byte of output. m.mu must be held.
+func (m *ShellMarks) scan(b byte) {
+	switch m.state {
+	case smGround:
+		if b == 0x1b {
+			m.state = smEscape
+			return
+		}
+		m.keep(b)
+	case smEscape:
+		if b == ']' {
+			m.state = smOSC
+			m.osc = m.osc[:0]
+			return
+		}
+		m.state = smGround
+		m.keep(0x1b)
+		if b == 0x1b {
+			m.state = smEscape
+			return
+		}
+		m.keep(b)
+	case smOSC:
+		switch b {
+		case 0x07:
+			m.state = smGround
+			m.oscEnd()
+		case 0x1b:
+			m.state = smOSCEscape
+		default:
+			if len(m.osc) < 256 {
+				m.osc = append(m.osc, b)
+			}
+		}
+	case smOSCEscape:
+		// ESC \ is the proper string terminator, anything else aborts
+		m.state = smGround
+		if b == '\\' {
+			m.oscEnd()
+		}
+	}
+}
+
+// keep adds b to the output of the running command
+func (m *ShellMarks) keep(b byte) {
+	if m.inOutput {
+		m.output.WriteByte(b)
+	}
+}
+
+// oscEnd handles a complete OSC sequence
+func (m *ShellMarks) oscEnd() {
+	params := strings.Split(string(m.osc), ";")
+	if len(params) < 2 || params[0] != "133" || len(params[1]) != 1 {
+		return
+	}
+	switch params[1][0] {
+	case 'A':
+		m.queue(ShellEvent{Mark: MarkPromptStart, ExitCode: -1})
+	case 'B':
+		m.queue(ShellEvent{Mark: MarkCommandStart, ExitCode: -1})
+	case 'C':
+		m.inOutput = true
+		m.output.Reset()
+		m.queue(ShellEvent{Mark: MarkOutputStart, ExitCode: -1})
+	case 'D':
+		if !m.inOutput {
+			return
+		}
+		m.inOutput = false
+		code := -1
+		if len(params) > 2 {
+			if n, err := strconv.Atoi(params[2]); err == nil {
+				code = n
+			}
+		}
+		output := bytes.ReplaceAll(m.output.Bytes(), []byte("\r\n"), []byte("\n"))
+		m.output.Reset()
+		m.queue(ShellEvent{Mark: MarkCommandEnd, ExitCode: code, Output: output})
+	}
+}
+
+// queue adds ev to the events and wakes up Next()
+func (m *ShellMarks) queue(ev ShellEvent) {
+	debugf("ShellMarks %s %d", ev.Mark, ev.ExitCode)
+	if len(m.events) >= ShellMarksMaxEvents {
+		debugf("ShellMarks dropping %s", m.events[0].Mark)
+		m.events = m.events[1:]
+	}
+	m.events = append(m.events, ev)
+	close(m.notify)
+	m.notify = make(chan struct{})
+}
+
+// Close ends the events, once those queued have been read Next() returns
+// io.EOF. EnableShellMarks() arranges for this when the output ends.
+func (m *ShellMarks) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.closed {
+		m.closed = true
+		close(m.notify)
+	}
+	return nil
+}
+
+// Next returns the next event, waiting for one if need be
+func (m *ShellMarks) Next(ctx context.Context) (ShellEvent, error) {
+	for {
+		m.mu.Lock()
+		if len(m.events) > 0 {
+			ev := m.events[0]
+			m.events = m.events[1:]
+			m.mu.Unlock()
+			return ev, nil
+		}
+		if m.closed {
+			m.mu.Unlock()
+			return ShellEvent{}, io.EOF
+		}
+		notify := m.notify
+		m.mu.Unlock()
+
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return ShellEvent{}, ctx.Err()
+		}
+	}
+}
+
+// NextCommand skips events until the next MarkCommandEnd and returns it
+func (m *ShellMarks) NextCommand(ctx context.Context) (ShellEvent, error) {
+	for {
+		ev, err := m.Next(ctx)
+		if err != nil || ev.Mark == MarkCommandEnd {
+			return ev, err
+		}
+	}
+}
+
+// EnableShellMarks starts parsing the command's output for OSC 133 marks.
+// The output is still passed to Expect() unchanged. Calling it again returns
+// the same ShellMarks.
+// This does not make the command send the marks, see ShellMarksSetup().
+func (exp *Expect) EnableShellMarks() *ShellMarks {
+	m := NewShellMarks()
+	if !exp.shellMarks.CompareAndSwap(nil, m) {
+		return exp.shellMarks.Load()
+	}
+	select {
+	case <-exp.readerDone:
+		m.Close()
+	default:
+	}
+	return m
+}
+
+// StripShellMarks returns b without any OSC 133 sequences. An unterminated
+// sequence, such as one cut short at the end of b, is left in.
+func StripShellMarks(b []byte) []byte {
+	var out []byte
+	for {
+		start := bytes.Index(b, []byte("\x1b]133;"))
+		if start < 0 {
+			return append(out, b...)
+		}
+		out = append(out, b[:start]...)
+		rest := b[start:]
+		end := bytes.IndexAny(rest, "\x07\\")
+		if end < 0 {
+			return append(out, rest...)
+		}
+		b = rest[end+1:]
+	}
+}
+
+const (
+	// bashShellMarks sends A and B around the prompt, with D and the exit code
+	// of the last command before them, and C from PS0 once a command is read.
+	// PS0 needs bash 4.4 or later.
+	bashShellMarks = `PS0='\e]133;C\a'; PS1='\[\e]133;D;$?\a\e]133;A\a\]'"$PS1"'\[\e]133;B\a\]'`
+
+	// zshShellMarks uses precmd and preexec hooks for D, A and C and adds B
+	// to the end of the prompt
+	zshShellMarks = `_expect_precmd() { local rc=$?; print -n "\e]133;D;$rc\a\e]133;A\a"; }; ` +
+		`_expect_preexec() { print -n "\e]133;C\a"; }; ` +
+		`autoload -Uz add-zsh-hook; add-zsh-hook precmd _expect_precmd; ` +
+		`add-zsh-hook preexec _expect_preexec; PS1=$PS1$'%{\e]133;B\a%}'`
+)
+
+// ShellMarksSetup returns the command line that makes an interactive bash or
+// zsh send OSC 133 marks. It adds to the current prompt, PS1, so set that
+// first. Send it followed by a newline.
+func ShellMarksSetup(shell string) (string, error) {
+	name := filepath.Base(shell)
+	switch {
+	case strings.Contains(name, "bash"):
+		return bashShellMarks, nil
+	case strings.Contains(name, "zsh"):
+		return zshShellMarks, nil
+	}
+	return "", fmt.Errorf("expect: no shell integration marks for %s", name)
+}
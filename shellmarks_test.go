@@ -0,0 +1,130 @@
+/*
+File summary: go test of OSC 133 shell integration marks
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"context"
+	"io"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func Test_ShellMarksParse(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	m := NewShellMarks()
+	m.Write([]byte("\x1b]133;A\x07$ \x1b]133;B\x07"))
+	// D without C, as after an empty command line, is ignored
+	m.Write([]byte("\x1b]133;D;0\x07"))
+	m.Write([]byte("ls\r\n\x1b]133;C\x1b\\"))
+	// Split across writes with another OSC and a CSI in the output
+	m.Write([]byte("a\x1b]0;title\x07\x1b[1mb\r"))
+	m.Write([]byte("\nc\r\n\x1b]13"))
+	m.Write([]byte("3;D;2\x07"))
+	m.Write([]byte("\x1b]133;C\x07\x1b]133;D\x07"))
+	m.Close()
+
+	expected := []ShellEvent{
+		{Mark: MarkPromptStart, ExitCode: -1},
+		{Mark: MarkCommandStart, ExitCode: -1},
+		{Mark: MarkOutputStart, ExitCode: -1},
+		{Mark: MarkCommandEnd, ExitCode: 2, Output: []byte("a\x1b[1mb\nc\n")},
+		{Mark: MarkOutputStart, ExitCode: -1},
+		{Mark: MarkCommandEnd, ExitCode: -1, Output: []byte{}},
+	}
+	ctx := context.Background()
+	for _, want := range expected {
+		ev, err := m.Next(ctx)
+		if err != nil {
+			t.Errorf("Next failed %s", err)
+			return
+		}
+		if ev.Mark != want.Mark || ev.ExitCode != want.ExitCode || string(ev.Output) != string(want.Output) {
+			t.Errorf("event is %s %d %q not %s %d %q",
+				ev.Mark, ev.ExitCode, ev.Output, want.Mark, want.ExitCode, want.Output)
+		}
+	}
+	if _, err := m.Next(ctx); err != io.EOF {
+		t.Errorf("Next after Close err is %v not io.EOF", err)
+	}
+}
+
+func Test_StripShellMarks(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	for _, tc := range []struct {
+		in       string
+		expected string
+	}{
+		{"\x1b]133;C\x07out\x1b]0;title\x07put\x1b]133;D;0\x1b\\", "out\x1b]0;title\x07put"},
+		{"out\x1b]133;C\x07put\x1b]133;D;0", "output\x1b]133;D;0"},
+	} {
+		if out := string(StripShellMarks([]byte(tc.in))); out != tc.expected {
+			t.Errorf("StripShellMarks(%q) is %q not %q", tc.in, out, tc.expected)
+		}
+	}
+}
+
+func Test_ShellMarksBash(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash not installed")
+	}
+	s, err := NewShellSession("bash")
+	if err != nil {
+		t.Errorf("NewShellSession failed %s", err)
+		return
+	}
+	defer s.Close()
+
+	marks, err := s.EnableShellMarks()
+	if err != nil {
+		t.Errorf("EnableShellMarks failed %s", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s.Send("echo hello; (exit 3)\n")
+	ev, err := marks.NextCommand(ctx)
+	if err != nil {
+		t.Errorf("NextCommand failed %s", err)
+		return
+	}
+	if string(ev.Output) != "hello\n" || ev.ExitCode != 3 {
+		t.Errorf("command is %q, %d not \"hello\\n\", 3", ev.Output, ev.ExitCode)
+	} else {
+		t.Logf("command output %q exit code %d", ev.Output, ev.ExitCode)
+	}
+	if err := s.waitPrompt(ctx); err != nil {
+		t.Errorf("no prompt after command %s", err)
+	}
+
+	// Run() is unaffected by the marks
+	if output, code, err := s.Run(ctx, "echo again"); err != nil || output != "again\n" || code != 0 {
+		t.Errorf("Run is %q, %d, %v", output, code, err)
+	}
+
+	// The marks of a Run() have the command's exit code, not the trailer's
+	if _, code, err := s.Run(ctx, "(exit 4)"); err != nil || code != 4 {
+		t.Errorf("Run exit code is %d, %v not 4", code, err)
+	}
+	for _, expected := range []int{0, 4} {
+		ev, err := marks.NextCommand(ctx)
+		if err != nil || ev.ExitCode != expected {
+			t.Errorf("Run command mark is %d, %v not %d", ev.ExitCode, err, expected)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+/*
+File summary: Graceful escalating shutdown of the command and its session
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"context"
+	"syscall"
+	"time"
+)
+
+// DefaultShutdownGrace is the grace period given to new Expects, see
+// SetShutdownGrace()
+var DefaultShutdownGrace = 2 * time.Second
+
+// SetShutdownGrace sets how long Shutdown() waits for the command to exit
+// after each step before moving on to the next
+func (exp *Expect) SetShutdownGrace(grace time.Duration) {
+	exp.shutdownGrace = grace
+}
+
+// Shutdown ends the command as politely as it can. It sends EOF and then, if
+// the command has not exited after the grace period set by SetShutdownGrace(),
+// sends SIGHUP, SIGTERM and finally SIGKILL waiting the grace period after
+// each. The signals go to every process in the command's session, not just the
+// command itself, so shell pipelines and other grandchildren end too. The
+// command is always started as the leader of its own session by NewExpect.
+// If ctx is done before the command exits it is sent SIGKILL at once and
+// ctx.Err() is returned.
+// Only the command itself is waited for. Once it has exited and been reaped
+// its pid, which is also the id of its process group and session, may be
+// reused so nothing more is signalled. Any other processes left running in
+// the session, such as background jobs that ignore SIGHUP, are left to end on
+// their own.
+// However Shutdown returns the expectReader goroutine has ended and the pty
+// closed. Using an Expect after a Shutdown is undefined
+func (exp *Expect) Shutdown(ctx context.Context) error {
+	defer exp.release()
+
+	steps := []struct {
+		name string
+		sig  syscall.Signal
+	}{
+		{"EOF", 0},
+		{"SIGHUP", syscall.SIGHUP},
+		{"SIGTERM", syscall.SIGTERM},
+		{"SIGKILL", syscall.SIGKILL},
+	}
+	for _, step := range steps {
+		select {
+		case <-exp.Done():
+			debugf("Shutdown process has exited")
+			return nil
+		default:
+		}
+
+		debugf("Shutdown sending %s", step.name)
+		if step.sig == 0 {
+			if _, err := exp.SendEOF(); err != nil {
+				debugf("Shutdown cannot send EOF: %s", err)
+			}
+		} else {
+			exp.signalSession(step.sig)
+		}
+
+		grace := time.NewTimer(exp.shutdownGrace)
+		select {
+		case <-exp.Done():
+			grace.Stop()
+			debugf("Shutdown process has exited after %s", step.name)
+			return nil
+		case <-ctx.Done():
+			grace.Stop()
+			debugf("Shutdown %s", ctx.Err())
+			exp.signalSession(syscall.SIGKILL)
+			return ctx.Err()
+		case <-grace.C:
+		}
+	}
+
+	// Even SIGKILL can take a moment, for example if the process is in the
+	// middle of a core dump
+	select {
+	case <-exp.Done():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release ends the expectReader goroutine and closes the pty
+func (exp *Expect) release() {
+	exp.stopReader()
+	<-exp.readerDone
+	if err := exp.Close(); err != nil {
+		debugf("release cannot close pty: %s", err)
+	}
+}
+
+// signalSession sends sig to every process in the command's session: the
+// command's own process group, the pty's foreground process group and, where
+// they can be found, any others left in the session. This is only done while
+// the command has not been reaped, see Shutdown(), otherwise false is
+// returned.
+func (exp *Expect) signalSession(sig syscall.Signal) bool {
+	exp.reapMu.Lock()
+	defer exp.reapMu.Unlock()
+	pid := exp.cmd.Process.Pid
+	if exp.reaped || !unreaped(pid) {
+		debugf("signalSession %d has been reaped", pid)
+		return false
+	}
+	if err := syscall.Kill(-pid, sig); err != nil {
+		debugf("signalSession cannot signal group %d: %s", pid, err)
+	}
+	if pgrp, err := foregroundPgrp(exp.File); err == nil && pgrp > 0 && pgrp != pid {
+		if err := syscall.Kill(-pgrp, sig); err != nil {
+			debugf("signalSession cannot signal group %d: %s", pgrp, err)
+		}
+	}
+	for _, p := range sessionPids(pid) {
+		if err := syscall.Kill(p, sig); err != nil {
+			debugf("signalSession cannot signal %d: %s", p, err)
+		}
+	}
+	return true
+}
@@ -0,0 +1,127 @@
+/*
+File summary: go test of Shutdown
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"context"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func Test_ShutdownEOF(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	t.Logf("starting %s", prog)
+	exp, err := NewExpect(prog)
+	if err != nil {
+		t.Errorf("NewExpect failed %s", err)
+		return
+	}
+	exp.SetShutdownGrace(5 * time.Second)
+
+	started := time.Now()
+	if err := exp.Shutdown(context.Background()); err != nil {
+		t.Errorf("Shutdown failed %s", err)
+	}
+	if time.Since(started) > 4*time.Second {
+		t.Errorf("Shutdown took %s, EOF should have been enough", time.Since(started))
+	}
+	if exp.ExitCode() != 0 {
+		t.Errorf("ExitCode() is %d not 0", exp.ExitCode())
+	}
+}
+
+func Test_ShutdownSession(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	t.Log("starting a shell pipeline that ignores EOF")
+	exp, err := NewExpect("sh", "-c", "sleep 60 | sleep 60")
+	if err != nil {
+		t.Errorf("NewExpect failed %s", err)
+		return
+	}
+	pid := exp.cmd.Process.Pid
+	time.Sleep(200 * time.Millisecond)
+	t.Logf("session has %d processes", len(sessionPids(pid)))
+
+	exp.SetShutdownGrace(500 * time.Millisecond)
+	if err := exp.Shutdown(context.Background()); err != nil {
+		t.Errorf("Shutdown failed %s", err)
+	}
+	// The grandchildren may take a moment to be reaped by init
+	time.Sleep(200 * time.Millisecond)
+	if pids := sessionPids(pid); len(pids) != 0 {
+		t.Errorf("processes left in the session: %v", pids)
+	}
+}
+
+func Test_ShutdownKill(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	t.Log("starting a shell that ignores EOF, SIGHUP and SIGTERM")
+	exp, err := NewExpect("sh", "-c", "trap '' HUP TERM; sleep 60")
+	if err != nil {
+		t.Errorf("NewExpect failed %s", err)
+		return
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	exp.SetShutdownGrace(200 * time.Millisecond)
+	if err := exp.Shutdown(context.Background()); err != nil {
+		t.Errorf("Shutdown failed %s", err)
+	}
+	if sig, ok := exp.ExitSignal(); !ok || sig != syscall.SIGKILL {
+		t.Errorf("ExitSignal() is %v, %t not SIGKILL", sig, ok)
+	}
+
+	select {
+	case <-exp.readerDone:
+		t.Log("expectReader has ended")
+	default:
+		t.Errorf("expectReader still running after Shutdown")
+	}
+}
+
+func Test_SignalAfterReap(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	exp, err := NewExpect("sleep", "60")
+	if err != nil {
+		t.Fatalf("NewExpect failed %s", err)
+	}
+	if !exp.signalSession(syscall.SIGTERM) {
+		t.Errorf("running command was not signalled")
+	}
+	exp.Wait()
+	if exp.signalSession(syscall.SIGTERM) {
+		t.Errorf("reaped command was signalled")
+	}
+	exp.Close()
+
+	t.Log("the caller of NewExpectProc reaps the command itself")
+	exp, cmd, err := NewExpectProc("true")
+	if err != nil {
+		t.Fatalf("NewExpectProc failed %s", err)
+	}
+	<-exp.Done()
+	if !exp.signalSession(syscall.SIGTERM) {
+		t.Errorf("exited but unreaped command was not signalled")
+	}
+	cmd.Process.Wait()
+	if exp.signalSession(syscall.SIGTERM) {
+		t.Errorf("command reaped by the caller was signalled")
+	}
+	exp.Close()
+}
@@ -0,0 +1,166 @@
+/*
+File summary: Follow the output live through channels and iterators
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"bytes"
+	"context"
+	"iter"
+	"sync"
+)
+
+// subscriber is the queue of output for one Chunks() channel
+type subscriber struct {
+	mu    sync.Mutex
+	queue []byte
+	ended bool
+	wake  chan struct{}
+}
+
+// Chunks returns a channel that is sent the output of the process as it is
+// read, from now on. The channel is closed when the process closes its output
+// or ctx is done. Each call returns a channel of its own.
+// The output is also read by Expect() as normal, Chunks() does not take
+// anything from Buffer. Output is queued, not dropped, until it is received
+// and whatever has been queued is sent as one chunk, so the chunks are not
+// the size of the reads from the pty.
+func (exp *Expect) Chunks(ctx context.Context) <-chan []byte {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	sub := &subscriber{wake: make(chan struct{}, 1)}
+	ch := make(chan []byte)
+
+	exp.subscribersMu.Lock()
+	if exp.subscribersEnded {
+		sub.ended = true
+	} else {
+		subs := append(append([]*subscriber{}, exp.subscribers()...), sub)
+		exp.subs.Store(&subs)
+	}
+	exp.subscribersMu.Unlock()
+
+	go func() {
+		defer close(ch)
+		defer exp.unsubscribe(sub)
+		for {
+			sub.mu.Lock()
+			data, ended := sub.queue, sub.ended
+			sub.queue = nil
+			sub.mu.Unlock()
+			if len(data) > 0 {
+				select {
+				case ch <- data:
+				case <-ctx.Done():
+					return
+				}
+				continue
+			}
+			if ended {
+				return
+			}
+			select {
+			case <-sub.wake:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// Lines returns an iterator over the lines of output, without their line
+// endings, as for Chunks(). Each iteration follows the output from when it
+// starts. A line is only yielded once it is complete, apart from any last
+// line without a newline when the output ends. Stopping the iteration, or
+// ctx being done, ends it.
+//
+//	for line := range exp.Lines(ctx) {
+//		log.Print(line)
+//	}
+func (exp *Expect) Lines(ctx context.Context) iter.Seq[string] {
+	return func(yield func(string) bool) {
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		var partial []byte
+		for chunk := range exp.Chunks(ctx) {
+			partial = append(partial, chunk...)
+			for {
+				i := bytes.IndexByte(partial, '\n')
+				if i < 0 {
+					break
+				}
+				line := string(bytes.TrimSuffix(partial[:i], []byte("\r")))
+				partial = partial[i+1:]
+				if !yield(line) {
+					return
+				}
+			}
+		}
+		if len(partial) > 0 && ctx.Err() == nil {
+			yield(string(bytes.TrimSuffix(partial, []byte("\r"))))
+		}
+	}
+}
+
+// subscribers returns the current Chunks() subscribers
+func (exp *Expect) subscribers() []*subscriber {
+	if subs := exp.subs.Load(); subs != nil {
+		return *subs
+	}
+	return nil
+}
+
+// publish queues output for every subscriber
+func (exp *Expect) publish(p []byte) {
+	for _, sub := range exp.subscribers() {
+		sub.mu.Lock()
+		sub.queue = append(sub.queue, p...)
+		sub.mu.Unlock()
+		sub.signal()
+	}
+}
+
+// endSubscribers tells every subscriber there is no more output
+func (exp *Expect) endSubscribers() {
+	exp.subscribersMu.Lock()
+	defer exp.subscribersMu.Unlock()
+	exp.subscribersEnded = true
+	for _, sub := range exp.subscribers() {
+		sub.mu.Lock()
+		sub.ended = true
+		sub.mu.Unlock()
+		sub.signal()
+	}
+}
+
+// unsubscribe removes sub
+func (exp *Expect) unsubscribe(sub *subscriber) {
+	exp.subscribersMu.Lock()
+	defer exp.subscribersMu.Unlock()
+	var subs []*subscriber
+	for _, s := range exp.subscribers() {
+		if s != sub {
+			subs = append(subs, s)
+		}
+	}
+	exp.subs.Store(&subs)
+}
+
+// signal wakes the goroutine sending to sub's channel
+func (sub *subscriber) signal() {
+	select {
+	case sub.wake <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,116 @@
+/*
+File summary: go test of Chunks and Lines
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_Chunks(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	exp, err := NewExpect("sh", "-c", "read a; echo one; echo two; printf three")
+	if err != nil {
+		t.Fatalf("NewExpect failed %s", err)
+	}
+	defer exp.Kill()
+	exp.SetTimeout(5 * time.Second)
+
+	// Two subscribers follow the output while Expect matches it
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	var chunks strings.Builder
+	var lines []string
+	chunkCh := exp.Chunks(ctx)
+	linesSeq := exp.Lines(ctx)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for chunk := range chunkCh {
+			chunks.Write(chunk)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for line := range linesSeq {
+			lines = append(lines, line)
+		}
+	}()
+
+	// Wait for the iteration to start before there is output
+	deadline := time.Now().Add(5 * time.Second)
+	for len(exp.subscribers()) != 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	exp.Send("go\r")
+	for _, want := range []string{"one", "two", "three"} {
+		if n, _, err := exp.Expect(want); n != 0 {
+			t.Errorf("Expect %s failed %d %v", want, n, err)
+		}
+	}
+	if n, _, err := exp.Expect(EndOfFile); n != 0 {
+		t.Errorf("Expect EndOfFile failed %d %v", n, err)
+	}
+	wg.Wait()
+
+	if chunks.String() != "go\r\none\r\ntwo\r\nthree" {
+		t.Errorf("chunks are %q", chunks.String())
+	}
+	if strings.Join(lines, "|") != "go|one|two|three" {
+		t.Errorf("lines are %q", lines)
+	}
+
+	// Once the output has ended a new channel is closed at once
+	if _, ok := <-exp.Chunks(ctx); ok {
+		t.Errorf("Chunks after EOF sent something")
+	}
+}
+
+func Test_LinesStop(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	exp, err := NewExpect("sh", "-c", "for i in 1 2 3 4 5; do echo line $i; done; sleep 5")
+	if err != nil {
+		t.Fatalf("NewExpect failed %s", err)
+	}
+	defer exp.Kill()
+
+	// Stopping the iteration ends the subscription
+	var got []string
+	for line := range exp.Lines(context.Background()) {
+		got = append(got, line)
+		if len(got) == 2 {
+			break
+		}
+	}
+	if strings.Join(got, "|") != "line 1|line 2" {
+		t.Errorf("lines are %q", got)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(exp.subscribers()) != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := len(exp.subscribers()); n != 0 {
+		t.Errorf("%d subscribers after break", n)
+	}
+
+	// And the output is still there for Expect
+	exp.SetTimeout(5 * time.Second)
+	if n, _, err := exp.Expect("line 5"); n != 0 {
+		t.Errorf("Expect line 5 failed %d %v", n, err)
+	}
+}
@@ -0,0 +1,153 @@
+/*
+File summary: Access to the termios settings of the pty
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"fmt"
+)
+
+// TermFlag is one of the termios flags that control the line discipline.
+// See Termios.
+type TermFlag int
+
+const (
+	// TermEcho is ECHO, echo input characters
+	TermEcho TermFlag = iota
+
+	// TermEchoE is ECHOE, echo the erase character as backspace space
+	// backspace
+	TermEchoE
+
+	// TermEchoK is ECHOK, echo a newline after the kill character
+	TermEchoK
+
+	// TermEchoNL is ECHONL, echo newline even if TermEcho is off
+	TermEchoNL
+
+	// TermEchoCtl is ECHOCTL, echo control characters as ^X
+	TermEchoCtl
+
+	// TermIcanon is ICANON, canonical (line at a time) input
+	TermIcanon
+
+	// TermIsig is ISIG, generate signals for INTR, QUIT and SUSP
+	TermIsig
+
+	// TermIexten is IEXTEN, extended input processing such as WERASE
+	TermIexten
+
+	// TermIcrnl is ICRNL, translate carriage return to newline on input
+	TermIcrnl
+
+	// TermInlcr is INLCR, translate newline to carriage return on input
+	TermInlcr
+
+	// TermIgncr is IGNCR, ignore carriage return on input
+	TermIgncr
+
+	// TermIxon is IXON, START and STOP flow control on output
+	TermIxon
+
+	// TermOpost is OPOST, output processing. Without it TermOnlcr and
+	// TermOcrnl do nothing
+	TermOpost
+
+	// TermOnlcr is ONLCR, translate newline to carriage return newline on
+	// output
+	TermOnlcr
+
+	// TermOcrnl is OCRNL, translate carriage return to newline on output
+	TermOcrnl
+
+	// numTermFlags is the number of TermFlags
+	numTermFlags
+)
+
+// String returns the termios name of the flag
+func (f TermFlag) String() string {
+	names := [...]string{
+		TermEcho:    "ECHO",
+		TermEchoE:   "ECHOE",
+		TermEchoK:   "ECHOK",
+		TermEchoNL:  "ECHONL",
+		TermEchoCtl: "ECHOCTL",
+		TermIcanon:  "ICANON",
+		TermIsig:    "ISIG",
+		TermIexten:  "IEXTEN",
+		TermIcrnl:   "ICRNL",
+		TermInlcr:   "INLCR",
+		TermIgncr:   "IGNCR",
+		TermIxon:    "IXON",
+		TermOpost:   "OPOST",
+		TermOnlcr:   "ONLCR",
+		TermOcrnl:   "OCRNL",
+	}
+	if f >= 0 && int(f) < len(names) {
+		return names[f]
+	}
+	return fmt.Sprintf("TermFlag(%d)", int(f))
+}
+
+// GetTermios returns the current termios settings of the pty. The master
+// and slave sides of a pty share one set of settings, the ones the command
+// sees. Changing the returned Termios has no effect until passed to
+// SetTermios() so it can also be kept to restore the settings later:
+//
+//	saved, err := exp.GetTermios()
+//	...
+//	exp.Raw()
+//	...
+//	exp.SetTermios(saved)
+func (exp *Expect) GetTermios() (*Termios, error) {
+	return getTermios(exp.File)
+}
+
+// SetTermios changes the termios settings of the pty to t at once
+func (exp *Expect) SetTermios(t *Termios) error {
+	return setTermios(exp.File, t)
+}
+
+// SetTermFlag turns a single termios flag of the pty on or off
+func (exp *Expect) SetTermFlag(f TermFlag, on bool) error {
+	t, err := exp.GetTermios()
+	if err != nil {
+		return err
+	}
+	t.SetFlag(f, on)
+	return exp.SetTermios(t)
+}
+
+// Raw puts the pty in raw mode, as cfmakeraw(3) does: no echo, no line
+// editing, no signals and no translation of input or output. The previous
+// settings are returned so they can be restored with SetTermios()
+func (exp *Expect) Raw() (*Termios, error) {
+	return exp.changeTermios((*Termios).Raw)
+}
+
+// Cooked puts the pty in the usual interactive mode, as stty sane does. The
+// previous settings are returned so they can be restored with SetTermios()
+func (exp *Expect) Cooked() (*Termios, error) {
+	return exp.changeTermios((*Termios).Cooked)
+}
+
+// changeTermios applies change to the pty's settings and returns the
+// settings as they were before
+func (exp *Expect) changeTermios(change func(*Termios)) (*Termios, error) {
+	saved, err := exp.GetTermios()
+	if err != nil {
+		return nil, err
+	}
+	t := *saved
+	change(&t)
+	if err := exp.SetTermios(&t); err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
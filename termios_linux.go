@@ -0,0 +1,170 @@
+/*
+File summary: The termios settings of the pty on Linux
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Termios holds the termios settings of a terminal.
+// See Expect.GetTermios()
+type Termios struct {
+	raw syscall.Termios
+}
+
+// getTermios reads the termios settings of the terminal on f. On Linux this
+// works on the master side of a pty and returns the slave's settings.
+func getTermios(f *os.File) (*Termios, error) {
+	t := new(Termios)
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TCGETS,
+		uintptr(unsafe.Pointer(&t.raw)))
+	if errno != 0 {
+		return nil, errno
+	}
+	return t, nil
+}
+
+// setTermios sets the termios settings of the terminal on f at once
+func setTermios(f *os.File, t *Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TCSETS,
+		uintptr(unsafe.Pointer(&t.raw)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Which of the termios flag fields a TermFlag is in
+const (
+	iflag = iota
+	oflag
+	lflag
+)
+
+// termFlags maps each TermFlag to its field and bit
+var termFlags = [numTermFlags]struct {
+	field int
+	mask  uint32
+}{
+	TermEcho:    {lflag, syscall.ECHO},
+	TermEchoE:   {lflag, syscall.ECHOE},
+	TermEchoK:   {lflag, syscall.ECHOK},
+	TermEchoNL:  {lflag, syscall.ECHONL},
+	TermEchoCtl: {lflag, syscall.ECHOCTL},
+	TermIcanon:  {lflag, syscall.ICANON},
+	TermIsig:    {lflag, syscall.ISIG},
+	TermIexten:  {lflag, syscall.IEXTEN},
+	TermIcrnl:   {iflag, syscall.ICRNL},
+	TermInlcr:   {iflag, syscall.INLCR},
+	TermIgncr:   {iflag, syscall.IGNCR},
+	TermIxon:    {iflag, syscall.IXON},
+	TermOpost:   {oflag, syscall.OPOST},
+	TermOnlcr:   {oflag, syscall.ONLCR},
+	TermOcrnl:   {oflag, syscall.OCRNL},
+}
+
+// flagField returns the field of raw that holds f
+func (t *Termios) flagField(f TermFlag) *uint32 {
+	switch termFlags[f].field {
+	case iflag:
+		return &t.raw.Iflag
+	case oflag:
+		return &t.raw.Oflag
+	}
+	return &t.raw.Lflag
+}
+
+// Flag returns true if f is set
+func (t *Termios) Flag(f TermFlag) bool {
+	if f < 0 || f >= numTermFlags {
+		return false
+	}
+	return *t.flagField(f)&termFlags[f].mask != 0
+}
+
+// SetFlag sets or clears f
+func (t *Termios) SetFlag(f TermFlag, on bool) {
+	if f < 0 || f >= numTermFlags {
+		return
+	}
+	if on {
+		*t.flagField(f) |= termFlags[f].mask
+	} else {
+		*t.flagField(f) &^= termFlags[f].mask
+	}
+}
+
+// controlIndex maps each Control to its index in c_cc
+var controlIndex = [numControls]int{
+	ControlIntr:   syscall.VINTR,
+	ControlEOF:    syscall.VEOF,
+	ControlSusp:   syscall.VSUSP,
+	ControlQuit:   syscall.VQUIT,
+	ControlErase:  syscall.VERASE,
+	ControlKill:   syscall.VKILL,
+	ControlWerase: syscall.VWERASE,
+	ControlStart:  syscall.VSTART,
+	ControlStop:   syscall.VSTOP,
+}
+
+// Char returns the character for ctl, zero if it is disabled
+func (t *Termios) Char(ctl Control) byte {
+	if ctl < 0 || ctl >= numControls {
+		return 0
+	}
+	return t.raw.Cc[controlIndex[ctl]]
+}
+
+// SetChar sets the character for ctl, zero disables it
+func (t *Termios) SetChar(ctl Control, c byte) {
+	if ctl < 0 || ctl >= numControls {
+		return
+	}
+	t.raw.Cc[controlIndex[ctl]] = c
+}
+
+// Raw changes t to raw mode as cfmakeraw(3) does
+func (t *Termios) Raw() {
+	t.raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	t.raw.Oflag &^= syscall.OPOST
+	t.raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	t.raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	t.raw.Cflag |= syscall.CS8
+	t.raw.Cc[syscall.VMIN] = 1
+	t.raw.Cc[syscall.VTIME] = 0
+}
+
+// Cooked changes t to the usual interactive settings as stty sane does
+func (t *Termios) Cooked() {
+	t.raw.Iflag &^= syscall.IGNBRK | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR |
+		syscall.IGNCR | syscall.IXOFF
+	t.raw.Iflag |= syscall.BRKINT | syscall.ICRNL | syscall.IXON | syscall.IMAXBEL
+	t.raw.Oflag &^= syscall.OCRNL | syscall.ONOCR | syscall.ONLRET
+	t.raw.Oflag |= syscall.OPOST | syscall.ONLCR
+	t.raw.Lflag &^= syscall.ECHONL | syscall.NOFLSH | syscall.TOSTOP | syscall.ECHOPRT
+	t.raw.Lflag |= syscall.ECHO | syscall.ECHOE | syscall.ECHOK | syscall.ECHOCTL |
+		syscall.ECHOKE | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	t.raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	t.raw.Cflag |= syscall.CS8 | syscall.CREAD
+	t.raw.Cc[syscall.VINTR] = 003
+	t.raw.Cc[syscall.VQUIT] = 034
+	t.raw.Cc[syscall.VERASE] = 0177
+	t.raw.Cc[syscall.VKILL] = 025
+	t.raw.Cc[syscall.VEOF] = 004
+	t.raw.Cc[syscall.VSTART] = 021
+	t.raw.Cc[syscall.VSTOP] = 023
+	t.raw.Cc[syscall.VSUSP] = 032
+	t.raw.Cc[syscall.VWERASE] = 027
+	t.raw.Cc[syscall.VMIN] = 1
+	t.raw.Cc[syscall.VTIME] = 0
+}
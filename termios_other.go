@@ -0,0 +1,90 @@
+//go:build !linux
+
+/*
+File summary: The termios settings of the pty, unsupported
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"errors"
+	"os"
+)
+
+// Termios holds the termios settings of a terminal. Only Linux can read and
+// set them, elsewhere this just holds the usual defaults.
+// See Expect.GetTermios()
+type Termios struct {
+	flags [numTermFlags]bool
+	cc    [numControls]byte
+}
+
+// getTermios cannot read the termios except on Linux so returns the usual
+// defaults
+func getTermios(f *os.File) (*Termios, error) {
+	t := new(Termios)
+	t.Cooked()
+	return t, nil
+}
+
+// setTermios is only supported on Linux
+func setTermios(f *os.File, t *Termios) error {
+	return errors.New("setting termios not supported")
+}
+
+// Flag returns true if f is set
+func (t *Termios) Flag(f TermFlag) bool {
+	return f >= 0 && f < numTermFlags && t.flags[f]
+}
+
+// SetFlag sets or clears f
+func (t *Termios) SetFlag(f TermFlag, on bool) {
+	if f >= 0 && f < numTermFlags {
+		t.flags[f] = on
+	}
+}
+
+// Char returns the character for ctl, zero if it is disabled
+func (t *Termios) Char(ctl Control) byte {
+	if ctl < 0 || ctl >= numControls {
+		return 0
+	}
+	return t.cc[ctl]
+}
+
+// SetChar sets the character for ctl, zero disables it
+func (t *Termios) SetChar(ctl Control, c byte) {
+	if ctl >= 0 && ctl < numControls {
+		t.cc[ctl] = c
+	}
+}
+
+// Raw changes t to raw mode
+func (t *Termios) Raw() {
+	t.flags = [numTermFlags]bool{}
+}
+
+// Cooked changes t to the usual interactive settings
+func (t *Termios) Cooked() {
+	t.flags = [numTermFlags]bool{}
+	for _, f := range []TermFlag{TermEcho, TermEchoE, TermEchoK, TermEchoCtl,
+		TermIcanon, TermIsig, TermIexten, TermIcrnl, TermIxon, TermOpost, TermOnlcr} {
+		t.flags[f] = true
+	}
+	t.cc = [numControls]byte{
+		ControlIntr:   003,
+		ControlEOF:    004,
+		ControlSusp:   032,
+		ControlQuit:   034,
+		ControlErase:  0177,
+		ControlKill:   025,
+		ControlWerase: 027,
+		ControlStart:  021,
+		ControlStop:   023,
+	}
+}
@@ -0,0 +1,94 @@
+/*
+File summary: go test of termios access
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"testing"
+)
+
+func Test_TermiosRawAndRestore(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	exp, err := NewExpect("cat")
+	if err != nil {
+		t.Errorf("NewExpect failed %s", err)
+		return
+	}
+	defer exp.Kill()
+	exp.SetTimeoutSecs(5) // Shouldn't happen
+
+	saved, err := exp.Raw()
+	if err != nil {
+		t.Errorf("Raw failed %s", err)
+		return
+	}
+	for _, f := range []TermFlag{TermEcho, TermIcanon, TermIsig, TermIcrnl, TermOpost} {
+		if !saved.Flag(f) {
+			t.Errorf("%s was not set before Raw", f)
+		}
+	}
+	raw, _ := exp.GetTermios()
+	for _, f := range []TermFlag{TermEcho, TermIcanon, TermIsig, TermIcrnl, TermOpost} {
+		if raw.Flag(f) {
+			t.Errorf("%s still set after Raw", f)
+		}
+	}
+
+	t.Log("in raw mode there is no echo and cat gets \\r unchanged at once")
+	exp.Send("hi\r")
+	n, found, err := exp.Expect("hi\r\n", "hi\r")
+	checkResultStr(t, "hi\r", 1, n, found, err)
+	if exp.BufStr() != "" {
+		t.Errorf("unexpected input after raw output <<%s>>", exp.BufStr())
+	}
+
+	if err := exp.SetTermios(saved); err != nil {
+		t.Errorf("SetTermios failed %s", err)
+	}
+	restored, _ := exp.GetTermios()
+	if *restored != *saved {
+		t.Errorf("settings not restored")
+	}
+}
+
+func Test_TermiosFlagsAndChars(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	exp, err := NewExpect("cat")
+	if err != nil {
+		t.Errorf("NewExpect failed %s", err)
+		return
+	}
+	defer exp.Kill()
+
+	if err := exp.SetTermFlag(TermOnlcr, false); err != nil {
+		t.Errorf("SetTermFlag failed %s", err)
+	}
+	termios, _ := exp.GetTermios()
+	if termios.Flag(TermOnlcr) {
+		t.Errorf("%s still set", TermOnlcr)
+	}
+
+	termios.SetChar(ControlIntr, 'x'&0x1f)
+	exp.SetTermios(termios)
+	if c, _ := exp.ControlChar(ControlIntr); c != 030 {
+		t.Errorf("ControlIntr is %q not ^X", c)
+	}
+
+	if _, err := exp.Cooked(); err != nil {
+		t.Errorf("Cooked failed %s", err)
+	}
+	termios, _ = exp.GetTermios()
+	if !termios.Flag(TermOnlcr) || termios.Char(ControlIntr) != 003 {
+		t.Errorf("Cooked did not restore ONLCR and ^C")
+	}
+}
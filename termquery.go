@@ -0,0 +1,404 @@
+/*
+File summary: Answer terminal queries such as cursor position and device attributes
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"fmt"
+
+	"github.com/kr/pty"
+)
+
+// TermResponder answers the queries programs send to find out about their
+// terminal. Without answers programs that send them, such as those built on
+// crossterm or prompt_toolkit and the fish shell, can hang waiting.
+// The queries answered are:
+//
+//	ESC[6n and ESC[?6n   cursor position
+//	ESC[5n               device status
+//	ESC[c and ESC[>c     primary and secondary device attributes
+//	ESC[>q               XTVERSION
+//	ESC[18t and ESC[19t  size in characters
+//	ESC]10;? and ESC]11;? foreground and background colour
+//
+// Turn it on with SetTermResponder(). The queries are still passed on to
+// Expect() as usual.
+type TermResponder struct {
+	// TrackCursor follows the cursor through the output, with a simple
+	// model of the screen, to report its position. Otherwise CursorRow and
+	// CursorCol are reported.
+	TrackCursor bool
+
+	// CursorRow and CursorCol are the position reported, counting from 1,
+	// when TrackCursor is false. Zero means 1.
+	CursorRow int
+	CursorCol int
+
+	// Rows and Cols are the size reported. Zero means the pty's size or, if
+	// that has not been set, 24 by 80.
+	Rows int
+	Cols int
+
+	// DeviceAttributes is the reply to primary DA without the ESC[? and c.
+	// Empty means "1;2", a VT100 with advanced video.
+	DeviceAttributes string
+
+	// SecondaryDeviceAttributes is the reply to secondary DA without the
+	// ESC[> and c. Empty means "0;0;0".
+	SecondaryDeviceAttributes string
+
+	// Version is the reply to XTVERSION. Empty means "expect".
+	Version string
+
+	// Foreground and Background are the colours reported in X11 form.
+	// Empty means "rgb:ffff/ffff/ffff" and "rgb:0000/0000/0000".
+	Foreground string
+	Background string
+}
+
+// DefaultTermResponder tracks the cursor and otherwise uses the defaults
+var DefaultTermResponder = TermResponder{TrackCursor: true}
+
+// trackingTermResponder is used to parse the output when no TermResponder
+// is set
+var trackingTermResponder = TermResponder{TrackCursor: true}
+
+// InitialTermResponder, if not nil, is copied to each new Expect so queries
+// sent as soon as the command starts are answered. SetTermResponder() can
+// only be called once the command has started and may be too late.
+var InitialTermResponder *TermResponder
+
+// SetTermResponder turns on answering terminal queries as described by r.
+// Pass nil to turn it off. r is copied.
+func (exp *Expect) SetTermResponder(r *TermResponder) {
+	if r == nil {
+		exp.termResponder.Store(nil)
+		return
+	}
+	c := *r
+	exp.termResponder.Store(&c)
+}
+
+// termQueries parses the output for terminal queries and, if TrackCursor
+// is set, the cursor position. Only used by the expectReader goroutine.
+type termQueries struct {
+	state  int
+	params []byte
+
+	// row and col are the cursor position counting from 0
+	row, col int
+
+	// pendingWrap is set when a character has been written in the last
+	// column, the next one wraps to the following line
+	pendingWrap bool
+}
+
+const (
+	tqGround = iota
+	tqEscape
+	tqCSI
+	tqOSC
+	tqOSCEscape
+	tqSkip
+	tqSkipOne
+)
+
+// scan handles the next byte of output and returns the reply to send, if any
+func (tq *termQueries) scan(b byte, r *TermResponder, rows, cols func() int) []byte {
+	switch tq.state {
+	case tqGround:
+		switch {
+		case b == 0x1b:
+			tq.state = tqEscape
+		case r.TrackCursor:
+			tq.cursor(b, rows(), cols())
+		}
+	case tqEscape:
+		tq.state = tqGround
+		tq.params = tq.params[:0]
+		switch b {
+		case '[':
+			tq.state = tqCSI
+		case ']':
+			tq.state = tqOSC
+		case 'P', 'X', '^', '_':
+			// DCS, SOS, PM and APC strings, skipped to their terminator
+			tq.state = tqSkip
+		case '(', ')', '*', '+', '#', '%':
+			// Character set and similar, with one more byte to skip
+			tq.state = tqSkipOne
+		case 'c':
+			tq.row, tq.col, tq.pendingWrap = 0, 0, false
+		case 'E':
+			tq.row, tq.col = tq.clampRow(tq.row+1, rows()), 0
+		case 0x1b:
+			tq.state = tqEscape
+		}
+	case tqCSI:
+		if b >= 0x20 && b <= 0x3f {
+			if len(tq.params) < 64 {
+				tq.params = append(tq.params, b)
+			}
+			return nil
+		}
+		tq.state = tqGround
+		if b >= 0x40 && b <= 0x7e {
+			return tq.csi(b, r, rows(), cols())
+		}
+	case tqOSC, tqSkip:
+		osc := tq.state == tqOSC
+		switch b {
+		case 0x07:
+			tq.state = tqGround
+			if osc {
+				return tq.osc(r)
+			}
+		case 0x1b:
+			if osc {
+				tq.state = tqOSCEscape
+			} else {
+				tq.state = tqEscape
+			}
+		default:
+			if osc && len(tq.params) < 64 {
+				tq.params = append(tq.params, b)
+			}
+		}
+	case tqSkipOne:
+		tq.state = tqGround
+	case tqOSCEscape:
+		tq.state = tqGround
+		if b == '\\' {
+			return tq.osc(r)
+		}
+	}
+	return nil
+}
+
+// csi handles a complete control sequence ending in final
+func (tq *termQueries) csi(final byte, r *TermResponder, rows, cols int) []byte {
+	params := string(tq.params)
+	switch final {
+	case 'n':
+		switch params {
+		case "5":
+			return []byte("\x1b[0n")
+		case "6", "?6":
+			row, col := r.CursorRow, r.CursorCol
+			if r.TrackCursor {
+				row, col = tq.row+1, tq.col+1
+			}
+			if row < 1 {
+				row = 1
+			}
+			if col < 1 {
+				col = 1
+			}
+			if params == "?6" {
+				return []byte(fmt.Sprintf("\x1b[?%d;%dR", row, col))
+			}
+			return []byte(fmt.Sprintf("\x1b[%d;%dR", row, col))
+		}
+	case 'c':
+		switch params {
+		case "", "0":
+			return []byte("\x1b[?" + orDefault(r.DeviceAttributes, "1;2") + "c")
+		case ">", ">0":
+			return []byte("\x1b[>" + orDefault(r.SecondaryDeviceAttributes, "0;0;0") + "c")
+		}
+	case 'q':
+		if params == ">" || params == ">0" {
+			return []byte("\x1bP>|" + orDefault(r.Version, "expect") + "\x1b\\")
+		}
+	case 't':
+		switch params {
+		case "18":
+			return []byte(fmt.Sprintf("\x1b[8;%d;%dt", rows, cols))
+		case "19":
+			return []byte(fmt.Sprintf("\x1b[9;%d;%dt", rows, cols))
+		}
+	}
+	if r.TrackCursor {
+		tq.moveCursor(final, params, rows, cols)
+	}
+	return nil
+}
+
+// osc handles a complete OSC string
+func (tq *termQueries) osc(r *TermResponder) []byte {
+	switch string(tq.params) {
+	case "10;?":
+		return []byte("\x1b]10;" + orDefault(r.Foreground, "rgb:ffff/ffff/ffff") + "\x1b\\")
+	case "11;?":
+		return []byte("\x1b]11;" + orDefault(r.Background, "rgb:0000/0000/0000") + "\x1b\\")
+	}
+	return nil
+}
+
+// cursor moves the cursor for a byte of text
+func (tq *termQueries) cursor(b byte, rows, cols int) {
+	switch {
+	case b == '\r':
+		tq.col, tq.pendingWrap = 0, false
+	case b == '\n', b == '\v', b == '\f':
+		tq.row, tq.pendingWrap = tq.clampRow(tq.row+1, rows), false
+	case b == '\b':
+		if tq.col > 0 {
+			tq.col--
+		}
+		tq.pendingWrap = false
+	case b == '\t':
+		tq.col = tq.col/8*8 + 8
+		if tq.col >= cols {
+			tq.col = cols - 1
+		}
+	case b < 0x20 || b == 0x7f:
+	case b&0xc0 == 0x80:
+		// UTF-8 continuation byte, the lead byte moved the cursor
+	default:
+		if tq.pendingWrap {
+			tq.row, tq.col, tq.pendingWrap = tq.clampRow(tq.row+1, rows), 0, false
+		}
+		if tq.col < cols-1 {
+			tq.col++
+		} else {
+			tq.pendingWrap = true
+		}
+	}
+}
+
+// moveCursor handles the control sequences that move the cursor
+func (tq *termQueries) moveCursor(final byte, params string, rows, cols int) {
+	if params != "" && (params[0] < '0' || params[0] > ';') {
+		// Private sequences such as ESC[?25h do not move the cursor
+		return
+	}
+	n := splitParams(params)
+	arg := func(i, def int) int {
+		if i < len(n) && n[i] > 0 {
+			return n[i]
+		}
+		return def
+	}
+	switch final {
+	case 'A':
+		tq.row -= arg(0, 1)
+	case 'B', 'e':
+		tq.row += arg(0, 1)
+	case 'C', 'a':
+		tq.col += arg(0, 1)
+	case 'D':
+		tq.col -= arg(0, 1)
+	case 'E':
+		tq.row, tq.col = tq.row+arg(0, 1), 0
+	case 'F':
+		tq.row, tq.col = tq.row-arg(0, 1), 0
+	case 'G', '`':
+		tq.col = arg(0, 1) - 1
+	case 'd':
+		tq.row = arg(0, 1) - 1
+	case 'H', 'f':
+		tq.row, tq.col = arg(0, 1)-1, arg(1, 1)-1
+	default:
+		return
+	}
+	tq.pendingWrap = false
+	tq.row = tq.clampRow(tq.row, rows)
+	if tq.col < 0 {
+		tq.col = 0
+	}
+	if tq.col >= cols {
+		tq.col = cols - 1
+	}
+}
+
+// clampRow keeps row on the screen, scrolling at the bottom
+func (tq *termQueries) clampRow(row, rows int) int {
+	if row < 0 {
+		return 0
+	}
+	if row >= rows {
+		return rows - 1
+	}
+	return row
+}
+
+// splitParams splits the numeric parameters of a control sequence. Missing
+// or non-numeric parameters are 0.
+func splitParams(params string) []int {
+	var n []int
+	cur := 0
+	for i := 0; i < len(params); i++ {
+		c := params[i]
+		switch {
+		case c >= '0' && c <= '9':
+			cur = cur*10 + int(c-'0')
+		case c == ';':
+			n = append(n, cur)
+			cur = 0
+		}
+	}
+	return append(n, cur)
+}
+
+// orDefault returns s or def if s is empty
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// termSize returns the rows and columns to report
+func (exp *Expect) termSize(r *TermResponder) (int, int) {
+	rows, cols := r.Rows, r.Cols
+	if rows <= 0 || cols <= 0 {
+		if prows, pcols, err := pty.Getsize(exp.File); err == nil && prows > 0 && pcols > 0 {
+			if rows <= 0 {
+				rows = prows
+			}
+			if cols <= 0 {
+				cols = pcols
+			}
+		}
+	}
+	if rows <= 0 {
+		rows = 24
+	}
+	if cols <= 0 {
+		cols = 80
+	}
+	return rows, cols
+}
+
+// answerQuery passes b to the query parser and writes any reply to the pty.
+// With no TermResponder set the output is still parsed, following the cursor,
+// so that one set later starts from the right place, but nothing is written.
+func (exp *Expect) answerQuery(b byte) {
+	r := exp.termResponder.Load()
+	responding := r != nil
+	if !responding {
+		r = &trackingTermResponder
+	}
+	var rows, cols int
+	size := func() {
+		if rows == 0 {
+			rows, cols = exp.termSize(r)
+		}
+	}
+	reply := exp.termQueries.scan(b, r,
+		func() int { size(); return rows },
+		func() int { size(); return cols })
+	if reply != nil && responding {
+		debugf("answerQuery reply %q", reply)
+		if _, err := exp.File.Write(reply); err != nil {
+			debugf("answerQuery cannot reply: %s", err)
+		}
+	}
+}
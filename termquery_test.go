@@ -0,0 +1,115 @@
+/*
+File summary: go test of the terminal query responder
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"regexp"
+	"testing"
+)
+
+// scanQueries feeds output to a termQueries with a 24x80 screen and returns
+// all the replies
+func scanQueries(tq *termQueries, r *TermResponder, output string) string {
+	size := func(n int) func() int { return func() int { return n } }
+	replies := ""
+	for i := 0; i < len(output); i++ {
+		replies += string(tq.scan(output[i], r, size(24), size(80)))
+	}
+	return replies
+}
+
+func Test_TermQueries(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	fixed := &TermResponder{CursorRow: 3, CursorCol: 7, Version: "test(1.0)"}
+	for _, tc := range []struct {
+		r        *TermResponder
+		output   string
+		expected string
+	}{
+		{fixed, "\x1b[6n", "\x1b[3;7R"},
+		{fixed, "\x1b[?6n", "\x1b[?3;7R"},
+		{fixed, "\x1b[5n", "\x1b[0n"},
+		{fixed, "\x1b[c", "\x1b[?1;2c"},
+		{fixed, "\x1b[0c\x1b[>c", "\x1b[?1;2c\x1b[>0;0;0c"},
+		{fixed, "\x1b[>q", "\x1bP>|test(1.0)\x1b\\"},
+		{fixed, "\x1b[18t\x1b[19t", "\x1b[8;24;80t\x1b[9;24;80t"},
+		{fixed, "\x1b]11;?\x07\x1b]10;?\x1b\\", "\x1b]11;rgb:0000/0000/0000\x1b\\\x1b]10;rgb:ffff/ffff/ffff\x1b\\"},
+		{fixed, "\x1b[31mred\x1b[0m\x1b]0;title\x07", ""},
+		{&DefaultTermResponder, "hello\x1b[6n", "\x1b[1;6R"},
+		{&DefaultTermResponder, "one\r\ntwo\x1b[6n", "\x1b[2;4R"},
+		{&DefaultTermResponder, "\x1b[10;20H\x1b[2A\x1b[5C\x1b[6n", "\x1b[8;25R"},
+		{&DefaultTermResponder, "ab\bc\tx\x1b(B\x1b[?25l\x1b[6n", "\x1b[1;10R"},
+		{&DefaultTermResponder, "\x1b[24;79Habc\x1b[6n", "\x1b[24;2R"},
+		{&DefaultTermResponder, "h\xc3\xa9\x1b[6n", "\x1b[1;3R"},
+		{&DefaultTermResponder, "\x1bP+q544e\x1b\\\x1b[6n", "\x1b[1;1R"},
+	} {
+		var tq termQueries
+		if replies := scanQueries(&tq, tc.r, tc.output); replies != tc.expected {
+			t.Errorf("replies to %q are %q not %q", tc.output, replies, tc.expected)
+		}
+	}
+}
+
+func Test_TermResponder(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	// The shell asks for the cursor position and shows the reply it reads
+	script := `stty -icanon -echo min 1; printf 'hello\033[6n'; ` +
+		`r=$(dd bs=1 count=6 2>/dev/null); printf '%s' "$r" | od -An -c`
+	InitialTermResponder = &DefaultTermResponder
+	exp, err := NewExpect("sh", "-c", script)
+	InitialTermResponder = nil
+	if err != nil {
+		t.Errorf("NewExpect failed %s", err)
+		return
+	}
+	defer exp.Kill()
+	exp.SetTimeoutSecs(5)
+
+	n, found, err := exp.Expect(regexp.MustCompile(`033\s+\[\s+1\s+;\s+6\s+R`))
+	if n != 0 {
+		t.Errorf("no cursor position reply seen %d %v buffer %q", n, err, exp.BufStr())
+	} else {
+		t.Logf("reply read by command: %q", found)
+	}
+}
+
+func Test_TermResponderSetLater(t *testing.T) {
+	debugf("%s start", funcName())
+	defer debugf("%s end", funcName())
+
+	// The cursor is followed before the responder is set
+	script := `stty -icanon -echo min 1; printf 'hello'; dd bs=1 count=1 >/dev/null 2>&1; ` +
+		`printf '\033[6n'; r=$(dd bs=1 count=6 2>/dev/null); printf '%s' "$r" | od -An -c`
+	exp, err := NewExpect("sh", "-c", script)
+	if err != nil {
+		t.Errorf("NewExpect failed %s", err)
+		return
+	}
+	defer exp.Kill()
+	exp.SetTimeoutSecs(5)
+
+	if n, _, err := exp.Expect("hello"); n != 0 {
+		t.Errorf("no hello %d %v", n, err)
+		return
+	}
+	exp.SetTermResponder(&DefaultTermResponder)
+	exp.Send("x")
+
+	n, found, err := exp.Expect(regexp.MustCompile(`033\s+\[\s+1\s+;\s+6\s+R`))
+	if n != 0 {
+		t.Errorf("no cursor position reply seen %d %v buffer %q", n, err, exp.BufStr())
+	} else {
+		t.Logf("reply read by command: %q", found)
+	}
+}
@@ -52,6 +52,10 @@ func main() {
 			fmt.Println("ijk")
 		case "4":
 			fmt.Println("世界")
+		case "5":
+			for i := 1; i <= 100; i++ {
+				fmt.Printf("line %d of chatty output\n", i)
+			}
 		case "HELLO":
 			fmt.Println("I saw hello")
 		default:
@@ -0,0 +1,55 @@
+/*
+File summary: Wait for a process to exit without reaping it on Linux
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// From <sys/wait.h>, not all of these are in syscall
+	pPID    = 1
+	wNOHANG = 0x1
+	wEXITED = 0x4
+	wNOWAIT = 0x1000000
+)
+
+// waitExited blocks until process pid exits but, thanks to WNOWAIT, leaves it
+// waitable so it can still be reaped by os.Process.Wait()
+func waitExited(pid int) error {
+	// siginfo_t is 128 bytes on all Linux platforms
+	var siginfo [128]byte
+	for {
+		_, _, errno := syscall.Syscall6(syscall.SYS_WAITID, pPID, uintptr(pid),
+			uintptr(unsafe.Pointer(&siginfo[0])), wEXITED|wNOWAIT, 0, 0)
+		if errno == syscall.EINTR {
+			continue
+		}
+		if errno != 0 {
+			return errno
+		}
+		return nil
+	}
+}
+
+// unreaped reports whether pid is a child that is still running or has
+// exited but not yet been reaped, so that its pid cannot have been reused
+func unreaped(pid int) bool {
+	var siginfo [128]byte
+	for {
+		_, _, errno := syscall.Syscall6(syscall.SYS_WAITID, pPID, uintptr(pid),
+			uintptr(unsafe.Pointer(&siginfo[0])), wEXITED|wNOWAIT|wNOHANG, 0, 0)
+		if errno == syscall.EINTR {
+			continue
+		}
+		return errno == 0
+	}
+}
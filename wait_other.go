@@ -0,0 +1,26 @@
+//go:build !linux
+
+/*
+File summary: Wait for a process to exit without reaping it, unsupported
+Package: expect
+Author: Lee McLoughlin
+
+Copyright (C) 2016 LMMR Tech Ltd
+
+*/
+
+package expect
+
+import "errors"
+
+// waitExited is only supported on Linux. Elsewhere Done() for NewExpectProc
+// is not closed until Wait() is called.
+func waitExited(pid int) error {
+	return errors.New("waiting without reaping not supported")
+}
+
+// unreaped is only supported on Linux. Elsewhere only a cmd reaped by Wait()
+// is known to have been reaped.
+func unreaped(pid int) bool {
+	return true
+}